@@ -0,0 +1,53 @@
+package doppelganger
+
+import (
+	"context"
+	"doppelganger/pkg/audit"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// WithAuditLog writes a record of every decision, including failed ones, to
+// l. A decision whose record cannot be written fails, so no answer is
+// returned without a record of how it was made.
+func WithAuditLog(l *audit.Log) Option {
+	return func(d *Doppelganger) {
+		d.audit = l
+	}
+}
+
+func (d *Doppelganger) writeAudit(ctx context.Context, req DecisionRequest, start time.Time, tools []string, messages []llms.MessageContent, decision *DecisionResult, decisionErr error) error {
+	record := audit.Record{
+		CorrelationID: req.CorrelationID,
+		Model:         req.Model,
+		ResponseModel: decision.ResponseModel,
+		System:        req.SystemInstruction,
+		Prompt:        req.UserInstruction,
+		Tools:         tools,
+		Messages:      messages,
+		ToolCalls:     []audit.ToolCall{},
+		Answer:        decision.Answer,
+		StartedAt:     start.UTC(),
+		FinishedAt:    time.Now().UTC(),
+		Cached:        decision.Cached,
+	}
+	if decisionErr != nil {
+		record.Error = decisionErr.Error()
+	}
+	for _, call := range decision.ToolCalls {
+		record.ToolCalls = append(record.ToolCalls, audit.ToolCall{
+			ID:         call.ID,
+			Name:       call.Name,
+			Arguments:  call.Arguments,
+			Result:     call.Result,
+			StartedAt:  call.StartedAt.UTC(),
+			DurationMs: call.Duration.Milliseconds(),
+			Cache:      call.Cache,
+		})
+	}
+
+	// The record is written even when the decision was cancelled
+	_, err := d.audit.Append(context.WithoutCancel(ctx), record)
+	return err
+}
@@ -0,0 +1,157 @@
+package doppelganger
+
+import (
+	"context"
+	"doppelganger/pkg/audit"
+	"doppelganger/pkg/llm"
+	"doppelganger/pkg/tool"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+type failingSink struct {
+	audit.Sink
+}
+
+func (f failingSink) Write(ctx context.Context, e audit.Entry) error {
+	return fmt.Errorf("disk full")
+}
+
+func TestAuditLog(t *testing.T) {
+	tt := []struct {
+		description    string
+		providerError  error
+		failWrite      bool
+		expectedError  bool
+		expectedRecord audit.Record
+	}{
+		{
+			description: "when a decision succeeds should record the inputs, tool calls and answer",
+			expectedRecord: audit.Record{
+				Model:         "mock",
+				ResponseModel: "mock-2025-01-01",
+				System:        "abc",
+				Prompt:        "who is 42?",
+				Tools:         []string{"get_user"},
+				Answer:        "user 42",
+			},
+		},
+		{
+			description:   "when a decision fails should record the error",
+			providerError: fmt.Errorf("provider down"),
+			expectedError: true,
+			expectedRecord: audit.Record{
+				Model:  "mock",
+				System: "abc",
+				Prompt: "who is 42?",
+				Tools:  []string{"get_user"},
+				Error:  "provider down",
+			},
+		},
+		{
+			description:   "when the record cannot be written should fail the decision",
+			failWrite:     true,
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			ctx := context.Background()
+
+			var sink audit.Sink
+			sink, err := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.jsonl"))
+			require.Nil(t, err)
+			defer sink.Close(ctx)
+			if test.failWrite {
+				sink = failingSink{sink}
+			}
+
+			l, err := audit.NewLog(ctx, sink)
+			require.Nil(t, err)
+
+			provider := &mockProvider{
+				err: test.providerError,
+				responses: []*llms.ContentResponse{
+					{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{ID: "call_1", FunctionCall: &llms.FunctionCall{Name: "get_user", Arguments: `{"id":"42"}`}},
+								},
+							},
+						},
+					},
+					{
+						Choices: []*llms.ContentChoice{{Content: "user 42", GenerationInfo: map[string]any{llm.ResponseModelInfo: "mock-2025-01-01"}}},
+					},
+				},
+			}
+
+			d := New(
+				WithAuditLog(l),
+				WithProviderGenerator(func(model string) (llms.Model, error) {
+					return provider, nil
+				}),
+			)
+			err = d.RegisterTool(tool.DataSourceTool{
+				Name:        "get_user",
+				Description: "Gets a user",
+				Parameters:  map[string]any{"type": "object"},
+				Method:      "findOne",
+				Query:       `{"id":"{{ .id }}"}`,
+				Source:      &mockDatasource{},
+			})
+			require.Nil(t, err)
+
+			res, err := d.MakeDecision(ctx, "abc", "who is 42?", "mock")
+			if test.expectedError {
+				require.NotNil(t, err)
+			} else {
+				require.Nil(t, err)
+				require.Equal(t, "user 42", res)
+			}
+
+			entries, err := sink.Entries(ctx)
+			require.Nil(t, err)
+			if test.failWrite {
+				require.Empty(t, entries)
+				return
+			}
+			require.Len(t, entries, 1)
+			require.Nil(t, audit.Verify(entries))
+
+			record, err := entries[0].Decode()
+			require.Nil(t, err)
+			require.NotEmpty(t, record.CorrelationID)
+			require.False(t, record.StartedAt.IsZero())
+			require.False(t, record.FinishedAt.Before(record.StartedAt))
+			require.Equal(t, test.expectedRecord.Model, record.Model)
+			require.Equal(t, test.expectedRecord.ResponseModel, record.ResponseModel)
+			require.Equal(t, test.expectedRecord.System, record.System)
+			require.Equal(t, test.expectedRecord.Prompt, record.Prompt)
+			require.Equal(t, test.expectedRecord.Tools, record.Tools)
+			require.Equal(t, test.expectedRecord.Answer, record.Answer)
+			require.Equal(t, test.expectedRecord.Error, record.Error)
+
+			if test.expectedError {
+				return
+			}
+
+			// What the model saw, from the system instruction to the answer
+			require.Len(t, record.Messages, 5)
+			require.Equal(t, llms.ChatMessageTypeSystem, record.Messages[0].Role)
+			require.Equal(t, llms.ChatMessageTypeTool, record.Messages[3].Role)
+			require.Equal(t, llms.ChatMessageTypeAI, record.Messages[4].Role)
+
+			require.Len(t, record.ToolCalls, 1)
+			require.Equal(t, "get_user", record.ToolCalls[0].Name)
+			require.Equal(t, `{"id":"42"}`, record.ToolCalls[0].Arguments)
+			require.Equal(t, `["{\"id\":\"42\"}"]`, record.ToolCalls[0].Result)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package doppelganger
+
+import (
+	"context"
+	"doppelganger/pkg/auth"
+	"doppelganger/pkg/tool"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorization(t *testing.T) {
+	tt := []struct {
+		description         string
+		principal           *auth.Principal
+		expectedTools       []string
+		expectedError       error
+		expectedConstraints map[string]interface{}
+	}{
+		{
+			description:   "when there is no principal should only allow the tools without roles",
+			expectedTools: []string{"get_rate"},
+			expectedError: auth.ErrForbidden,
+		},
+		{
+			description:   "when the principal lacks the role should hide the tool and refuse to run it",
+			principal:     &auth.Principal{Subject: "alice", Roles: []string{"customer"}, Attributes: map[string]interface{}{"branch": "b1"}},
+			expectedTools: []string{"get_rate"},
+			expectedError: auth.ErrForbidden,
+		},
+		{
+			description:   "when the principal has the role but not the attribute should refuse to run the tool",
+			principal:     &auth.Principal{Subject: "bob", Roles: []string{"teller"}},
+			expectedTools: []string{"get_rate", "list_accounts"},
+			expectedError: auth.ErrForbidden,
+		},
+		{
+			description:         "when the principal has the role should constrain the query with its attributes",
+			principal:           &auth.Principal{Subject: "bob", Roles: []string{"teller"}, Attributes: map[string]interface{}{"branch": "b1"}},
+			expectedTools:       []string{"get_rate", "list_accounts"},
+			expectedConstraints: map[string]interface{}{"branch_id": "b1"},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			source := &mockDatasource{}
+			d := New()
+
+			err := d.RegisterTool(tool.DataSourceTool{
+				Name:        "get_rate",
+				Description: "Gets an exchange rate",
+				Parameters:  map[string]any{"type": "object"},
+				Method:      "findOne",
+				Query:       `{"currency":"{{ .currency }}"}`,
+				Source:      &mockDatasource{},
+			})
+			require.Nil(t, err)
+			err = d.RegisterTool(tool.DataSourceTool{
+				Name:        "list_accounts",
+				Description: "Lists the accounts of a branch",
+				Parameters:  map[string]any{"type": "object"},
+				Method:      "find",
+				Query:       `{}`,
+				Source:      source,
+				Roles:       []string{"teller", "auditor"},
+				Constraints: map[string]string{"branch_id": "branch"},
+			})
+			require.Nil(t, err)
+
+			ctx := context.Background()
+			if test.principal != nil {
+				ctx = auth.WithPrincipal(ctx, test.principal)
+			}
+
+			var names []string
+			for _, t := range d.AllowedTools(ctx) {
+				names = append(names, t.Name)
+			}
+			require.Equal(t, test.expectedTools, names)
+
+			_, err = d.ExecuteTool(ctx, "list_accounts", `{}`)
+			if test.expectedError != nil {
+				require.ErrorIs(t, err, test.expectedError)
+				require.Equal(t, 0, source.calls)
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, test.expectedConstraints, source.constraints)
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package doppelganger
+
+import (
+	"context"
+	"doppelganger/pkg/approval"
+	"doppelganger/pkg/auth"
+	"doppelganger/pkg/cache"
+	"doppelganger/pkg/redact"
+	"doppelganger/pkg/tool"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestCache(t *testing.T) {
+	validate := func(id, arguments string) llms.ToolCall {
+		return llms.ToolCall{ID: id, FunctionCall: &llms.FunctionCall{Name: "validate_swift_code", Arguments: arguments}}
+	}
+	update := llms.ToolCall{ID: "update", FunctionCall: &llms.FunctionCall{Name: "update_swift_code", Arguments: `{"code":"UBSWCHZH80A"}`}}
+
+	tt := []struct {
+		description     string
+		withCache       bool
+		calls           []llms.ToolCall
+		expectedQueries int
+		expectedCache   []string
+		expectedHits    int
+		expectedMisses  int
+	}{
+		{
+			description:     "when the agent has no cache should run every call",
+			calls:           []llms.ToolCall{validate("1", `{"code":"UBSWCHZH80A"}`), validate("2", `{"code":"UBSWCHZH80A"}`)},
+			expectedQueries: 2,
+			expectedCache:   []string{"", ""},
+		},
+		{
+			description:     "when the arguments are the same should answer from the cache, whatever the order of their fields",
+			withCache:       true,
+			calls:           []llms.ToolCall{validate("1", `{"code":"UBSWCHZH80A","branch":"80A"}`), validate("2", `{"branch":"80A", "code":"UBSWCHZH80A"}`)},
+			expectedQueries: 1,
+			expectedCache:   []string{cache.Miss, cache.Hit},
+			expectedHits:    1,
+			expectedMisses:  1,
+		},
+		{
+			description:     "when the arguments differ should run the call",
+			withCache:       true,
+			calls:           []llms.ToolCall{validate("1", `{"code":"UBSWCHZH80A"}`), validate("2", `{"code":"DEUTDEFF"}`)},
+			expectedQueries: 2,
+			expectedCache:   []string{cache.Miss, cache.Miss},
+			expectedMisses:  2,
+		},
+		{
+			description:     "when a tool invalidates the cached tool should run the next call",
+			withCache:       true,
+			calls:           []llms.ToolCall{validate("1", `{"code":"UBSWCHZH80A"}`), update, validate("2", `{"code":"UBSWCHZH80A"}`)},
+			expectedQueries: 2,
+			expectedCache:   []string{cache.Miss, "", cache.Miss},
+			expectedMisses:  2,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			var responses []*llms.ContentResponse
+			for _, call := range test.calls {
+				responses = append(responses, &llms.ContentResponse{Choices: []*llms.ContentChoice{{ToolCalls: []llms.ToolCall{call}}}})
+			}
+			responses = append(responses, &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "valid"}}})
+			provider := &mockProvider{responses: responses}
+
+			opts := []Option{WithProviderGenerator(func(model string) (llms.Model, error) {
+				return provider, nil
+			})}
+			if test.withCache {
+				opts = append(opts, WithCache(cache.New(cache.NewLRU(10))))
+			}
+			d := New(opts...)
+
+			source := &mockDatasource{}
+			err := d.RegisterTool(tool.DataSourceTool{
+				Name:        "validate_swift_code",
+				Description: "Validates whether a swift code is valid",
+				Parameters:  map[string]any{"type": "object"},
+				Query:       `{{ .code }}`,
+				Source:      source,
+				CacheTTL:    time.Minute,
+			})
+			require.Nil(t, err)
+			err = d.RegisterTool(tool.DataSourceTool{
+				Name:        "update_swift_code",
+				Description: "Updates a swift code",
+				Parameters:  map[string]any{"type": "object"},
+				Query:       `{{ .code }}`,
+				Source:      &mockDatasource{},
+				Invalidates: []string{"validate_swift_code"},
+			})
+			require.Nil(t, err)
+
+			res, err := d.Decide(context.Background(), DecisionRequest{UserInstruction: "validate", Model: "mock"})
+			require.Nil(t, err)
+
+			require.Equal(t, test.expectedQueries, source.calls)
+			var statuses []string
+			for _, call := range res.ToolCalls {
+				statuses = append(statuses, call.Cache)
+			}
+			require.Equal(t, test.expectedCache, statuses)
+			require.Equal(t, test.expectedHits, res.CacheHits)
+			require.Equal(t, test.expectedMisses, res.CacheMisses)
+		})
+	}
+}
+
+func TestCacheRedaction(t *testing.T) {
+	redactor, err := redact.New(redact.Rules{Detect: []redact.Kind{redact.Email}, Action: redact.Tokenise})
+	require.Nil(t, err)
+
+	call := llms.ToolCall{ID: "call_1", FunctionCall: &llms.FunctionCall{Name: "get_customer", Arguments: `{}`}}
+	provider := &mockProvider{
+		responses: []*llms.ContentResponse{
+			{Choices: []*llms.ContentChoice{{ToolCalls: []llms.ToolCall{call}}}},
+			{Choices: []*llms.ContentChoice{{Content: "done"}}},
+			{Choices: []*llms.ContentChoice{{ToolCalls: []llms.ToolCall{call}}}},
+			{Choices: []*llms.ContentChoice{{Content: "done"}}},
+		},
+	}
+
+	d := New(
+		WithProviderGenerator(func(model string) (llms.Model, error) {
+			return provider, nil
+		}),
+		WithCache(cache.New(cache.NewLRU(10))),
+	)
+	source := &mockDatasource{}
+	err = d.RegisterTool(tool.DataSourceTool{
+		Name:        "get_customer",
+		Description: "Gets the customer",
+		Parameters:  map[string]any{"type": "object"},
+		Query:       `{"email":"jane@example.com"}`,
+		Source:      source,
+		Redact:      redactor,
+		CacheTTL:    time.Minute,
+	})
+	require.Nil(t, err)
+
+	// Each decision has its own vault, so hits are redacted again rather than
+	// carrying the tokens of another decision
+	for _, expectedCache := range []string{cache.Miss, cache.Hit} {
+		res, err := d.Decide(context.Background(), DecisionRequest{UserInstruction: "get Jane", Model: "mock"})
+		require.Nil(t, err)
+		require.Equal(t, expectedCache, res.ToolCalls[0].Cache)
+		require.Equal(t, `["{\"email\":\"[EMAIL_1]\"}"]`, res.ToolCalls[0].Result)
+		require.Equal(t, "jane@example.com", res.Vault.Detokenise("[EMAIL_1]"))
+	}
+	require.Equal(t, 1, source.calls)
+}
+
+func TestDecisionCache(t *testing.T) {
+	alice := &auth.Principal{Subject: "alice", Roles: []string{"teller"}}
+
+	tt := []struct {
+		description    string
+		tool           string
+		principal      *auth.Principal
+		history        bool
+		register       bool
+		expectedCached bool
+	}{
+		{
+			description:    "when the same caller asks again should answer from the cache without the model",
+			tool:           "validate_swift_code",
+			principal:      alice,
+			expectedCached: true,
+		},
+		{
+			description: "when another caller asks should call the model",
+			tool:        "validate_swift_code",
+			principal:   &auth.Principal{Subject: "bob", Roles: []string{"teller"}},
+		},
+		{
+			description: "when the question continues a conversation should call the model",
+			tool:        "validate_swift_code",
+			principal:   alice,
+			history:     true,
+		},
+		{
+			description: "when a tool was registered since should call the model",
+			tool:        "validate_swift_code",
+			principal:   alice,
+			register:    true,
+		},
+		{
+			description: "when the decision called a tool that requires approval should call the model",
+			tool:        "flag_account",
+			principal:   alice,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			call := llms.ToolCall{ID: "call_1", FunctionCall: &llms.FunctionCall{Name: test.tool, Arguments: `{"code":"BARCGB22"}`}}
+			var responses []*llms.ContentResponse
+			for i := 0; i < 2; i++ {
+				responses = append(responses,
+					&llms.ContentResponse{Choices: []*llms.ContentChoice{{ToolCalls: []llms.ToolCall{call}}}},
+					&llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "BARCGB22 is valid"}}},
+				)
+			}
+			provider := &mockProvider{responses: responses}
+
+			d := New(
+				WithProviderGenerator(func(model string) (llms.Model, error) {
+					return provider, nil
+				}),
+				WithApprover(approval.Func(func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+					return approval.Decision{Approved: true}, nil
+				})),
+				WithDecisionCache(cache.NewDecisions(cache.NewLRU(10), time.Hour)),
+			)
+			for _, rt := range []tool.DataSourceTool{
+				{Name: "validate_swift_code"},
+				{Name: "flag_account", RequiresApproval: true},
+			} {
+				rt.Description = "A tool"
+				rt.Parameters = map[string]any{"type": "object"}
+				rt.Query = `{{ .code }}`
+				rt.Source = &mockDatasource{}
+				require.Nil(t, d.RegisterTool(rt))
+			}
+
+			req := DecisionRequest{SystemInstruction: "You are a banking assistant", UserInstruction: "Is BARCGB22 valid?", Model: "mock"}
+			first, err := d.Decide(auth.WithPrincipal(context.Background(), alice), req)
+			require.Nil(t, err)
+			require.False(t, first.Cached)
+
+			if test.history {
+				req.History = first.Messages
+			}
+			if test.register {
+				require.Nil(t, d.RegisterTool(tool.DataSourceTool{Name: "get_account", Parameters: map[string]any{"type": "object"}, Source: &mockDatasource{}}))
+			}
+
+			var streamed string
+			req.StreamingFunc = func(ctx context.Context, chunk []byte) error {
+				streamed += string(chunk)
+				return nil
+			}
+			second, err := d.Decide(auth.WithPrincipal(context.Background(), test.principal), req)
+			require.Nil(t, err)
+
+			require.Equal(t, test.expectedCached, second.Cached)
+			require.Equal(t, "BARCGB22 is valid", second.Answer)
+			if test.expectedCached {
+				require.Equal(t, 2, provider.counter)
+				require.Empty(t, second.ToolCalls)
+				require.Equal(t, "BARCGB22 is valid", streamed)
+				require.Equal(t, []llms.MessageContent{
+					llms.TextParts(llms.ChatMessageTypeHuman, "Is BARCGB22 valid?"),
+					llms.TextParts(llms.ChatMessageTypeAI, "BARCGB22 is valid"),
+				}, second.Messages)
+			} else {
+				require.Equal(t, 4, provider.counter)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"doppelganger/pkg/audit"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func (c *cli) audit(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "verify" {
+		return fmt.Errorf("%w: audit verify <file> [--last-hash <hash>]", errUsage)
+	}
+
+	return c.auditVerify(ctx, args[1:])
+}
+
+func (c *cli) auditVerify(ctx context.Context, args []string) error {
+	// The file comes before the flags
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return fmt.Errorf("%w: audit verify <file> [--last-hash <hash>]", errUsage)
+	}
+	path := args[0]
+
+	fs := c.flagSet("audit verify")
+	lastHash := fs.String("last-hash", "", "hash the last entry must have, to detect entries removed from the end")
+	err := fs.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entries, err := audit.ReadEntries(file)
+	if err != nil {
+		return err
+	}
+
+	err = audit.Verify(entries)
+	if err != nil {
+		return err
+	}
+
+	var last string
+	if len(entries) > 0 {
+		last = entries[len(entries)-1].Hash
+	}
+	if *lastHash != "" && *lastHash != last {
+		return fmt.Errorf("%w: the last entry does not have hash %s", audit.ErrTampered, *lastHash)
+	}
+
+	fmt.Fprintf(c.stdout, "%d entries verified, last hash %s\n", len(entries), last)
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"doppelganger"
+	"doppelganger/pkg/redact"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+func (c *cli) chat(ctx context.Context, args []string) error {
+	fs := c.flagSet("chat")
+	configPath := fs.String("config", "", "path to the YAML or JSON config")
+	system := fs.String("system", "You are a helpful assistant", "system instruction")
+	systemFile := fs.String("system-file", "", "read the system instruction from a file")
+	model := fs.String("model", defaultModel, "model to use")
+	trace := fs.Bool("trace", false, "print the tool calls made for every answer")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	systemInstruction, err := readInstruction(*system, *systemFile)
+	if err != nil {
+		return err
+	}
+
+	d, sources, err := c.loadAgent(ctx, *configPath)
+	if err != nil {
+		return err
+	}
+	defer sources.Close(ctx)
+
+	fmt.Fprintln(c.stderr, "Type your message, or /exit to quit and /reset to start over.")
+
+	var history []llms.MessageContent
+	var vault *redact.Vault
+	for {
+		fmt.Fprint(c.stderr, "> ")
+
+		line, err := c.stdin.ReadString('\n')
+		if errors.Is(err, io.EOF) && line == "" {
+			fmt.Fprintln(c.stderr)
+			return nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		switch line {
+		case "":
+			continue
+		case "/exit", "/quit":
+			return nil
+		case "/reset":
+			history = nil
+			vault = nil
+			continue
+		}
+
+		res, err := d.Decide(ctx, doppelganger.DecisionRequest{
+			SystemInstruction: systemInstruction,
+			UserInstruction:   line,
+			Model:             *model,
+			History:           history,
+			Vault:             vault,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			// Keep the session going, the message can be retried
+			fmt.Fprintln(c.stderr, "error:", err)
+			continue
+		}
+
+		if *trace {
+			printTrace(c.stderr, res.ToolCalls)
+		}
+		fmt.Fprintln(c.stdout, res.Answer)
+
+		history = res.Messages
+		vault = res.Vault
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"doppelganger"
+	"doppelganger/pkg/approval"
+	"doppelganger/pkg/config"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const usage = `Usage: doppelganger <command> [flags]
+
+Commands:
+  run                 Make a decision and print the answer with a trace of tool calls
+  chat                Hold a multi-turn conversation on the terminal
+  serve               Serve decisions over HTTP
+  mcp                 Serve the tools over the Model Context Protocol
+  tools list          List the tools defined in a config
+  tools test <name>   Run a single tool without a model
+  audit verify <file> Check the hash chain of an audit log
+
+Run "doppelganger <command> -h" for the flags of a command.
+`
+
+var errUsage = errors.New("invalid usage")
+
+// cli holds the streams commands read from and write to, so they can be
+// exercised in tests.
+type cli struct {
+	stdin  *bufio.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := &cli{
+		stdin:  bufio.NewReader(os.Stdin),
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+
+	code := c.exitCode(c.run(ctx, os.Args[1:]))
+	if code != 0 {
+		os.Exit(code)
+	}
+}
+
+// exitCode reports err on stderr and returns the code to exit with. Usage
+// errors exit with 2, silently on -h as the flags printed their usage.
+func (c *cli) exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 2
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(c.stderr, "%v\n\n%s", err, usage)
+		return 2
+	}
+
+	fmt.Fprintln(c.stderr, "error:", err)
+	return 1
+}
+
+func (c *cli) run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: missing command", errUsage)
+	}
+
+	switch args[0] {
+	case "run":
+		return c.runDecision(ctx, args[1:])
+	case "chat":
+		return c.chat(ctx, args[1:])
+	case "serve":
+		return c.serve(ctx, args[1:])
+	case "mcp":
+		return c.mcp(ctx, args[1:])
+	case "tools":
+		return c.tools(ctx, args[1:])
+	case "audit":
+		return c.audit(ctx, args[1:])
+	case "help", "-h", "--help":
+		fmt.Fprint(c.stdout, usage)
+		return nil
+	}
+
+	return fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+}
+
+func (c *cli) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
+
+// loadAgent builds an agent from a config file. Tools that require approval
+// are confirmed on the terminal.
+func (c *cli) loadAgent(ctx context.Context, configPath string) (*doppelganger.Doppelganger, config.Sources, error) {
+	_, d, sources, err := c.loadConfig(ctx, configPath, approval.NewPrompt(c.stdin, c.stderr))
+	return d, sources, err
+}
+
+func (c *cli) loadConfig(ctx context.Context, configPath string, approver approval.Approver, opts ...doppelganger.Option) (*config.Config, *doppelganger.Doppelganger, config.Sources, error) {
+	if configPath == "" {
+		return nil, nil, nil, fmt.Errorf("%w: --config is required", errUsage)
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	secrets := cfg.SecretStore()
+	agentOpts := []doppelganger.Option{doppelganger.WithApprover(approver)}
+	if len(cfg.Providers) > 0 || cfg.Models != nil {
+		agentOpts = append(agentOpts, doppelganger.WithProviderGenerator(cfg.ProviderGenerator(secrets)))
+	}
+	if cfg.Guard != nil {
+		agentOpts = append(agentOpts, doppelganger.WithGuard(cfg.GuardPolicy()))
+	}
+	if results := cfg.ResultCache(); results != nil {
+		agentOpts = append(agentOpts, doppelganger.WithCache(results))
+	}
+	decisions, err := cfg.Decisions(ctx, secrets)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if decisions != nil {
+		agentOpts = append(agentOpts, doppelganger.WithDecisionCache(decisions))
+	}
+
+	d := doppelganger.New(append(agentOpts, opts...)...)
+	sources, err := cfg.Apply(ctx, d, config.WithSecrets(secrets))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return cfg, d, sources, nil
+}
+
+// readInstruction returns text, or the content of file when it is set.
+func readInstruction(text, file string) (string, error) {
+	if file == "" {
+		return text, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"doppelganger/pkg/audit"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `datasources:
+  - name: cases
+    type: http
+    connection: %s
+tools:
+  - name: get_case
+    description: Gets a case
+    datasource: cases
+    collection: cases
+    method: get
+    query: 'id={{ .id }}'
+  - name: close_case
+    description: Closes a case
+    datasource: cases
+    collection: cases/close
+    method: post
+    query: '{ "id": "{{ .id }}" }'
+    requiresApproval: true
+`
+
+func TestRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"path":%q,"query":%q}`, r.URL.Path, r.URL.RawQuery)
+	}))
+	defer server.Close()
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configPath, []byte(fmt.Sprintf(testConfig, server.URL)), 0o600)
+	require.Nil(t, err)
+
+	auditPath, tamperedPath, lastHash := writeAuditLogs(t)
+
+	tt := []struct {
+		description      string
+		args             []string
+		stdin            string
+		expectedError    bool
+		expectedOutput   []string
+		unexpectedOutput []string
+	}{
+		{
+			description:    "when listing tools should print every tool",
+			args:           []string{"tools", "list", "--config", configPath},
+			expectedError:  false,
+			expectedOutput: []string{"get_case", "close_case", "http", "Gets a case"},
+		},
+		{
+			description:    "when testing a tool should print its result",
+			args:           []string{"tools", "test", "get_case", "--config", configPath, "--args", `{"id":"42"}`},
+			expectedError:  false,
+			expectedOutput: []string{`/cases`, `id=42`},
+		},
+		{
+			description:    "when testing a tool that requires approval and it is approved should run it",
+			args:           []string{"tools", "test", "close_case", "--config", configPath, "--args", `{"id":"42"}`},
+			stdin:          "y\n",
+			expectedError:  false,
+			expectedOutput: []string{`/cases/close`},
+		},
+		{
+			description:      "when testing a tool that requires approval and it is rejected should not run it",
+			args:             []string{"tools", "test", "close_case", "--config", configPath, "--args", `{"id":"42"}`},
+			stdin:            "n\n",
+			expectedError:    false,
+			expectedOutput:   []string{`rejected`},
+			unexpectedOutput: []string{`/cases/close`},
+		},
+		{
+			description:    "when serving MCP over stdio should answer on stdout",
+			args:           []string{"mcp", "--config", configPath},
+			stdin:          `{"jsonrpc":"2.0","id":1,"method":"tools/list"}` + "\n" + `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"get_case","arguments":{"id":"42"}}}` + "\n",
+			expectedError:  false,
+			expectedOutput: []string{`"name":"get_case"`, `"name":"close_case"`, `id=42`},
+		},
+		{
+			description:      "when serving MCP over stdio should reject calls that require approval",
+			args:             []string{"mcp", "--config", configPath},
+			stdin:            `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"close_case","arguments":{"id":"42"}}}` + "\n",
+			expectedError:    false,
+			expectedOutput:   []string{`rejected`},
+			unexpectedOutput: []string{`/cases/close`},
+		},
+		{
+			description:   "when serving MCP over HTTP on a remote address without allowing it should return error",
+			args:          []string{"mcp", "--config", configPath, "--http", "0.0.0.0:0"},
+			expectedError: true,
+		},
+		{
+			description:    "when verifying an untouched audit log should print the entries and last hash",
+			args:           []string{"audit", "verify", auditPath, "--last-hash", lastHash},
+			expectedError:  false,
+			expectedOutput: []string{"2 entries verified", lastHash},
+		},
+		{
+			description:   "when verifying a tampered audit log should return error",
+			args:          []string{"audit", "verify", tamperedPath},
+			expectedError: true,
+		},
+		{
+			description:   "when the audit log does not end with the expected hash should return error",
+			args:          []string{"audit", "verify", auditPath, "--last-hash", "abc"},
+			expectedError: true,
+		},
+		{
+			description:   "when testing an unknown tool should return error",
+			args:          []string{"tools", "test", "delete_case", "--config", configPath},
+			expectedError: true,
+		},
+		{
+			description:   "when the config is missing should return error",
+			args:          []string{"tools", "list"},
+			expectedError: true,
+		},
+		{
+			description:   "when serving tools that require approval without an approver should return error",
+			args:          []string{"serve", "--config", configPath, "--addr", "127.0.0.1:0"},
+			expectedError: true,
+		},
+		{
+			description:   "when run has no prompt should return error",
+			args:          []string{"run", "--config", configPath},
+			expectedError: true,
+		},
+		{
+			description:   "when the command is unknown should return error",
+			args:          []string{"deploy"},
+			expectedError: true,
+		},
+		{
+			description:   "when no command is given should return error",
+			args:          []string{},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			c := &cli{
+				stdin:  bufio.NewReader(strings.NewReader(test.stdin)),
+				stdout: &stdout,
+				stderr: &stderr,
+			}
+
+			err := c.run(context.Background(), test.args)
+			if test.expectedError {
+				require.NotNil(t, err)
+				return
+			}
+
+			require.Nil(t, err)
+			for _, expected := range test.expectedOutput {
+				require.Contains(t, stdout.String(), expected)
+			}
+			for _, unexpected := range test.unexpectedOutput {
+				require.NotContains(t, stdout.String(), unexpected)
+			}
+		})
+	}
+}
+
+// writeAuditLogs writes an audit log of two decisions, and a copy where the
+// first answer was changed.
+func writeAuditLogs(t *testing.T) (string, string, string) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+
+	sink, err := audit.NewFileSink(path)
+	require.Nil(t, err)
+	defer sink.Close(ctx)
+
+	l, err := audit.NewLog(ctx, sink)
+	require.Nil(t, err)
+
+	var last audit.Entry
+	for _, answer := range []string{"approved", "declined"} {
+		last, err = l.Append(ctx, audit.Record{Model: "gpt-4.1", Answer: answer})
+		require.Nil(t, err)
+	}
+
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+
+	tamperedPath := filepath.Join(dir, "tampered.jsonl")
+	err = os.WriteFile(tamperedPath, []byte(strings.Replace(string(data), "approved", "declined", 1)), 0o600)
+	require.Nil(t, err)
+
+	return path, tamperedPath, last.Hash
+}
+
+func TestExitCode(t *testing.T) {
+	tt := []struct {
+		description    string
+		args           []string
+		expectedCode   int
+		expectedStderr []string
+	}{
+		{
+			description:  "when the command succeeds should exit with 0",
+			args:         []string{"help"},
+			expectedCode: 0,
+		},
+		{
+			description:    "when the config is missing should print the error and usage",
+			args:           []string{"run", "--prompt", "hi"},
+			expectedCode:   2,
+			expectedStderr: []string{"invalid usage: --config is required", "Usage: doppelganger"},
+		},
+		{
+			description:    "when the tool name is missing should print the error and usage",
+			args:           []string{"tools", "test"},
+			expectedCode:   2,
+			expectedStderr: []string{"invalid usage: tools test <name>", "Usage: doppelganger"},
+		},
+		{
+			description:    "when the command is unknown should print it",
+			args:           []string{"deploy"},
+			expectedCode:   2,
+			expectedStderr: []string{`invalid usage: unknown command "deploy"`},
+		},
+		{
+			description:    "when asked for help on a command should only print its flags",
+			args:           []string{"run", "-h"},
+			expectedCode:   2,
+			expectedStderr: []string{"-prompt"},
+		},
+		{
+			description:    "when the command fails should print the error",
+			args:           []string{"audit", "verify", filepath.Join(t.TempDir(), "missing.jsonl")},
+			expectedCode:   1,
+			expectedStderr: []string{"error: "},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			c := &cli{
+				stdin:  bufio.NewReader(strings.NewReader("")),
+				stdout: &stdout,
+				stderr: &stderr,
+			}
+
+			code := c.exitCode(c.run(context.Background(), test.args))
+			require.Equal(t, test.expectedCode, code)
+			for _, expected := range test.expectedStderr {
+				require.Contains(t, stderr.String(), expected)
+			}
+			if test.expectedCode == 0 {
+				require.Empty(t, stderr.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"doppelganger/pkg/approval"
+	"doppelganger/pkg/mcp"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+func (c *cli) mcp(ctx context.Context, args []string) error {
+	fs := c.flagSet("mcp")
+	configPath := fs.String("config", "", "path to the YAML or JSON config")
+	addr := fs.String("http", "", "serve over HTTP on this address instead of stdio, on loopback unless --allow-remote")
+	allowRemote := fs.Bool("allow-remote", false, "serve over HTTP on addresses other than loopback, which gives every tool to anyone reaching them")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *addr != "" {
+		*addr, err = loopback(*addr, *allowRemote)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Over stdio the terminal carries the protocol, so nobody can be asked
+	// to approve a call
+	var approver approval.Approver = approval.Func(func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+		return approval.Decision{Approved: false, Reason: "approval is not available over MCP stdio"}, nil
+	})
+	if *addr != "" {
+		approver = approval.NewPrompt(c.stdin, c.stderr)
+	}
+
+	_, d, _, err := c.loadConfig(ctx, *configPath, approver)
+	if err != nil {
+		return err
+	}
+	defer d.Close(context.WithoutCancel(ctx))
+
+	s := mcp.NewServer(d)
+	if *addr == "" {
+		return s.ServeStdio(ctx, c.stdin, c.stdout)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/mcp", s)
+	httpServer := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "Serving %d tools over MCP on http://%s/mcp\n", len(d.Tools), listener.Addr())
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- httpServer.Serve(listener)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+
+		return httpServer.Shutdown(shutdownCtx)
+	}
+}
+
+// loopback returns addr listening on loopback when it names no host. The
+// MCP server does not authenticate its clients, so other hosts are refused
+// unless allowRemote is set.
+func loopback(addr string, allowRemote bool) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("mcp: invalid --http address %q: %w", addr, err)
+	}
+
+	if host == "" {
+		return net.JoinHostPort("127.0.0.1", port), nil
+	}
+
+	ip := net.ParseIP(host)
+	if host == "localhost" || (ip != nil && ip.IsLoopback()) || allowRemote {
+		return addr, nil
+	}
+
+	return "", fmt.Errorf("mcp: %s is not a loopback address and the MCP server does not authenticate its clients; pass --allow-remote to serve every tool to anyone reaching it", addr)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"doppelganger"
+	"doppelganger/pkg/cache"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const defaultModel = "gpt-4.1"
+
+func (c *cli) runDecision(ctx context.Context, args []string) error {
+	fs := c.flagSet("run")
+	configPath := fs.String("config", "", "path to the YAML or JSON config")
+	system := fs.String("system", "You are a helpful assistant", "system instruction")
+	systemFile := fs.String("system-file", "", "read the system instruction from a file")
+	prompt := fs.String("prompt", "", "user prompt")
+	promptFile := fs.String("prompt-file", "", "read the user prompt from a file")
+	model := fs.String("model", defaultModel, "model to use")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	systemInstruction, err := readInstruction(*system, *systemFile)
+	if err != nil {
+		return err
+	}
+
+	userInstruction, err := readInstruction(*prompt, *promptFile)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(userInstruction) == "" {
+		return fmt.Errorf("%w: --prompt or --prompt-file is required", errUsage)
+	}
+
+	d, sources, err := c.loadAgent(ctx, *configPath)
+	if err != nil {
+		return err
+	}
+	defer sources.Close(ctx)
+
+	res, err := d.Decide(ctx, doppelganger.DecisionRequest{
+		SystemInstruction: systemInstruction,
+		UserInstruction:   userInstruction,
+		Model:             *model,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			CorrelationID string                       `json:"correlationId"`
+			Answer        string                       `json:"answer"`
+			ToolCalls     []doppelganger.ToolCallTrace `json:"toolCalls"`
+			Cached        bool                         `json:"cached,omitempty"`
+		}{res.CorrelationID, res.Answer, res.ToolCalls, res.Cached})
+	}
+
+	printTrace(c.stdout, res.ToolCalls)
+	fmt.Fprintln(c.stdout, res.Answer)
+
+	return nil
+}
+
+const maxTraceResult = 200
+
+func printTrace(w io.Writer, calls []doppelganger.ToolCallTrace) {
+	if len(calls) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "Tool calls:")
+	for i, call := range calls {
+		result := call.Result
+		if len(result) > maxTraceResult {
+			result = result[:maxTraceResult] + "..."
+		}
+
+		duration := call.Duration.Round(time.Millisecond).String()
+		if call.Cache == cache.Hit {
+			duration += ", cached"
+		}
+
+		fmt.Fprintf(w, "  %d. %s %s (%s)\n", i+1, call.Name, call.Arguments, duration)
+		fmt.Fprintf(w, "     -> %s\n", result)
+	}
+	fmt.Fprintln(w)
+}
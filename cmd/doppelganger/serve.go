@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"doppelganger"
+	"doppelganger/pkg/approval"
+	"doppelganger/pkg/audit"
+	"doppelganger/pkg/metrics"
+	"doppelganger/pkg/server"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func (c *cli) serve(ctx context.Context, args []string) error {
+	fs := c.flagSet("serve")
+	configPath := fs.String("config", "", "path to the YAML or JSON config")
+	addr := fs.String("addr", ":8080", "address to listen on")
+	model := fs.String("model", defaultModel, "model used when a request does not name one")
+	models := fs.String("models", "", "comma separated models requests may select, any when empty")
+	timeout := fs.Duration("timeout", time.Minute, "time a decision may take when the request does not set timeoutMs")
+	maxTimeout := fs.Duration("max-timeout", 5*time.Minute, "longest timeout a request may ask for")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 30*time.Second, "time to wait for in-flight requests on shutdown")
+	exposeMetrics := fs.Bool("metrics", false, "serve Prometheus metrics on /metrics")
+	auditLog := fs.String("audit-log", "", "append a hash-chained record of every decision to this JSONL file")
+	logLevel := fs.String("log-level", "info", "level of the JSON logs written to stderr: debug, info, warn or error")
+	promptApprovals := fs.Bool("prompt-approvals", false, "ask for approvals on this terminal, tools that require approval are refused otherwise")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	var level slog.Level
+	err = level.UnmarshalText([]byte(*logLevel))
+	if err != nil {
+		return fmt.Errorf("%w: invalid --log-level %q", errUsage, *logLevel)
+	}
+
+	logger := slog.New(slog.NewJSONHandler(c.stderr, &slog.HandlerOptions{Level: level}))
+	// Data sources log the failures of their background refreshes to the
+	// default logger
+	slog.SetDefault(logger)
+	agentOpts := []doppelganger.Option{
+		doppelganger.WithLogger(logger),
+	}
+	var serverOpts []server.Option
+	if *auditLog != "" {
+		sink, err := audit.NewFileSink(*auditLog)
+		if err != nil {
+			return err
+		}
+		defer sink.Close(ctx)
+
+		l, err := audit.NewLog(ctx, sink)
+		if err != nil {
+			return err
+		}
+
+		agentOpts = append(agentOpts, doppelganger.WithAuditLog(l))
+	}
+
+	if *exposeMetrics {
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+		recorder, err := metrics.NewPrometheus(reg)
+		if err != nil {
+			return err
+		}
+
+		agentOpts = append(agentOpts, doppelganger.WithMetrics(recorder))
+		serverOpts = append(serverOpts, server.WithMetricsHandler(promhttp.HandlerFor(reg, promhttp.HandlerOpts{})))
+	}
+
+	// A daemon usually runs without a terminal, so tools that require approval
+	// are only registered when the operator asks to approve them here
+	var approver approval.Approver
+	if *promptApprovals {
+		approver = approval.NewPrompt(c.stdin, c.stderr)
+	}
+
+	// The server closes the data sources of the agent when it shuts down
+	cfg, d, sources, err := c.loadConfig(ctx, *configPath, approver, agentOpts...)
+	if errors.Is(err, doppelganger.ErrNoApprover) {
+		return fmt.Errorf("%w: %w, pass --prompt-approvals to approve calls on this terminal", errUsage, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	profiles, err := cfg.Profiles()
+	if err != nil {
+		sources.Close(ctx)
+		return err
+	}
+
+	opts := append([]server.Option{
+		server.WithDefaultModel(*model),
+		server.WithTimeout(*timeout, *maxTimeout),
+		server.WithShutdownTimeout(*shutdownTimeout),
+		server.WithProfiles(profiles...),
+	}, serverOpts...)
+	if *models != "" {
+		opts = append(opts, server.WithModels(strings.Split(*models, ",")...))
+	}
+
+	fmt.Fprintf(c.stderr, "Serving %d tools and %d agents on %s\n", len(d.Tools), len(profiles), *addr)
+	return server.New(d, opts...).ListenAndServe(ctx, *addr)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+)
+
+func (c *cli) tools(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: missing tools command", errUsage)
+	}
+
+	switch args[0] {
+	case "list":
+		return c.toolsList(ctx, args[1:])
+	case "test":
+		return c.toolsTest(ctx, args[1:])
+	}
+
+	return fmt.Errorf("%w: unknown tools command %q", errUsage, args[0])
+}
+
+func (c *cli) toolsList(ctx context.Context, args []string) error {
+	fs := c.flagSet("tools list")
+	configPath := fs.String("config", "", "path to the YAML or JSON config")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	d, sources, err := c.loadAgent(ctx, *configPath)
+	if err != nil {
+		return err
+	}
+	defer sources.Close(ctx)
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSOURCE\tMETHOD\tDESCRIPTION")
+	for _, t := range d.Tools {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Name, t.Source.Type(), t.Method, t.Description)
+	}
+
+	return tw.Flush()
+}
+
+func (c *cli) toolsTest(ctx context.Context, args []string) error {
+	// The tool name comes before the flags
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return fmt.Errorf("%w: tools test <name> --config <file> --args '{...}'", errUsage)
+	}
+	name := args[0]
+
+	fs := c.flagSet("tools test")
+	configPath := fs.String("config", "", "path to the YAML or JSON config")
+	arguments := fs.String("args", "{}", "tool arguments as a JSON object")
+	err := fs.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+
+	d, sources, err := c.loadAgent(ctx, *configPath)
+	if err != nil {
+		return err
+	}
+	defer sources.Close(ctx)
+
+	result, err := d.ExecuteTool(ctx, name, *arguments)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.stdout, result)
+	return nil
+}
@@ -0,0 +1,96 @@
+package doppelganger
+
+import (
+	"context"
+	"doppelganger/pkg/redact"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+type DecisionRequest struct {
+	SystemInstruction string
+	UserInstruction   string
+	Model             string
+	// History holds earlier turns of the conversation, placed between the
+	// system and user instructions. Pass the Messages of the previous result
+	// to continue a conversation.
+	History []llms.MessageContent
+	// Tools limits the tools offered to the model to those named. Empty
+	// offers every registered tool.
+	Tools []string
+	// OnToolCall, when set, is called after every tool call, for example to
+	// report progress while the decision is made.
+	OnToolCall func(ToolCallTrace)
+	// StreamingFunc, when set, receives the answer once the model has written
+	// it, with its tokens replaced by their values. The text and tool calls of
+	// the rounds before are not sent.
+	StreamingFunc func(ctx context.Context, chunk []byte) error
+	// CorrelationID identifies the decision in logs and traces, for example
+	// the ID of the request that asked for it. Empty generates one.
+	CorrelationID string
+	// Vault holds the tokens given to personal data in earlier turns of the
+	// conversation. Nil starts a new one. Pass the Vault of the previous
+	// result along with its Messages.
+	Vault *redact.Vault
+}
+
+type DecisionResult struct {
+	// CorrelationID is the ID found on every log line of the decision.
+	CorrelationID string
+	// Answer is de-tokenised, while Messages keep the tokens the model saw.
+	Answer string
+	// ResponseModel is the model that wrote the answer, as named by its
+	// provider, such as gpt-4.1-2025-04-14 for gpt-4.1. It is empty when
+	// the provider does not report it.
+	ResponseModel string
+	Vault         *redact.Vault
+	ToolCalls     []ToolCallTrace
+	// CacheHits and CacheMisses count the tool calls answered from the
+	// result cache, and those of cached tools that ran.
+	CacheHits   int
+	CacheMisses int
+	// Cached is true when the answer came from the decision cache, without
+	// calling the model.
+	Cached bool
+	// Messages is the conversation after the system instruction, ending with
+	// the answer.
+	Messages []llms.MessageContent
+}
+
+// ToolCallTrace records a tool call made by the model while deciding.
+type ToolCallTrace struct {
+	ID        string
+	Name      string
+	Arguments string
+	Result    string
+	StartedAt time.Time
+	Duration  time.Duration
+	// Cache is cache.Hit or cache.Miss for tools with a CacheTTL, when the
+	// agent has a cache.
+	Cache string
+}
+
+// Profile is a named agent: a model, its instructions and the tools it may
+// use, so that callers only need to pick the agent they talk to.
+type Profile struct {
+	Name              string
+	Model             string
+	SystemInstruction string
+	Tools             []string
+	// AllowSystemMessages lets clients of the OpenAI compatible endpoint add
+	// system messages to the instructions. They can override the instructions
+	// and their rules, so they are rejected unless this is set.
+	AllowSystemMessages bool
+}
+
+// Request builds the request asking the agent of the profile to answer
+// userInstruction.
+func (p Profile) Request(userInstruction string) DecisionRequest {
+	return DecisionRequest{
+		SystemInstruction: p.SystemInstruction,
+		UserInstruction:   userInstruction,
+		Model:             p.Model,
+		Tools:             p.Tools,
+	}
+}
@@ -2,31 +2,138 @@ package doppelganger
 
 import (
 	"context"
+	"doppelganger/pkg/approval"
+	"doppelganger/pkg/audit"
+	"doppelganger/pkg/auth"
+	"doppelganger/pkg/cache"
+	"doppelganger/pkg/datasource"
+	"doppelganger/pkg/guard"
 	"doppelganger/pkg/llm"
+	"doppelganger/pkg/metrics"
+	"doppelganger/pkg/ratelimit"
+	"doppelganger/pkg/redact"
+	"doppelganger/pkg/telemetry"
 	"doppelganger/pkg/tool"
+	"errors"
 	"fmt"
+	"log/slog"
+	"time"
 
+	"github.com/google/uuid"
 	jsoniter "github.com/json-iterator/go"
 
 	"github.com/tmc/langchaingo/llms"
 	"github.com/xeipuuv/gojsonschema"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
+var ErrNoApprover = errors.New("tool requires approval but no approver is configured")
+
+var ErrToolNotFound = errors.New("tool not found")
+
+var ErrApprovalRequired = errors.New("tool changes data but does not require approval")
+
+var ErrDuplicateTool = errors.New("a tool with this name is already registered")
+
 type ProviderGeneratorFunc func(model string) (llms.Model, error)
 
 type Doppelganger struct {
 	Tools                 []tool.DataSourceTool
 	providerGeneratorFunc ProviderGeneratorFunc
 	toolsMap              map[string]tool.DataSourceTool
+	approver              approval.Approver
+	tracer                trace.Tracer
+	recordContent         bool
+	metrics               metrics.Recorder
+	logger                *slog.Logger
+	redactArguments       ArgumentRedactor
+	audit                 *audit.Log
+	guard                 guard.Policy
+	cache                 *cache.Results
+	decisions             *cache.Decisions
+}
+
+type Option func(*Doppelganger)
+
+// WithApprover sets the approver consulted before running tools that require
+// approval.
+func WithApprover(a approval.Approver) Option {
+	return func(d *Doppelganger) {
+		d.approver = a
+	}
+}
+
+// WithProviderGenerator replaces how models are created from their names,
+// for example to configure API keys or use another provider.
+func WithProviderGenerator(f ProviderGeneratorFunc) Option {
+	return func(d *Doppelganger) {
+		d.providerGeneratorFunc = f
+	}
+}
+
+// WithGuard sets the defences against prompt injection applied to the
+// results of untrusted tools.
+func WithGuard(p guard.Policy) Option {
+	return func(d *Doppelganger) {
+		d.guard = p
+	}
+}
+
+// WithCache caches the results of tools with a CacheTTL in c.
+func WithCache(c *cache.Results) Option {
+	return func(d *Doppelganger) {
+		d.cache = c
+	}
+}
+
+// WithDecisionCache answers prompts asked before from c, without calling the
+// model. Only decisions without history are cached.
+func WithDecisionCache(c *cache.Decisions) Option {
+	return func(d *Doppelganger) {
+		d.decisions = c
+	}
+}
+
+// WithTracerProvider sets where the spans of decisions, model rounds, tool
+// calls and data source queries go. The global provider is used by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(d *Doppelganger) {
+		d.tracer = tp.Tracer(telemetry.TracerName)
+	}
 }
 
-func New() *Doppelganger {
-	return &Doppelganger{
+// WithContentRecording adds prompts, responses, tool arguments and results,
+// and query text to spans. They may contain personal data.
+func WithContentRecording() Option {
+	return func(d *Doppelganger) {
+		d.recordContent = true
+	}
+}
+
+// WithMetrics records decisions, model calls, tool calls and data source
+// queries with r.
+func WithMetrics(r metrics.Recorder) Option {
+	return func(d *Doppelganger) {
+		d.metrics = r
+	}
+}
+
+func New(opts ...Option) *Doppelganger {
+	d := &Doppelganger{
 		providerGeneratorFunc: llm.GetProvider,
 		toolsMap:              make(map[string]tool.DataSourceTool),
+		tracer:                otel.GetTracerProvider().Tracer(telemetry.TracerName),
+		metrics:               metrics.Nop{},
+		logger:                slog.New(slog.DiscardHandler),
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
 }
 
 func (d *Doppelganger) RegisterTool(tool tool.DataSourceTool) error {
@@ -40,6 +147,16 @@ func (d *Doppelganger) RegisterTool(tool tool.DataSourceTool) error {
 		return err
 	}
 
+	if _, exists := d.toolsMap[tool.Name]; exists {
+		return fmt.Errorf("registering %s: %w", tool.Name, ErrDuplicateTool)
+	}
+	if tool.Source != nil && datasource.Writes(tool.Source.Type(), tool.Method) && !tool.RequiresApproval {
+		return fmt.Errorf("registering %s: %w", tool.Name, ErrApprovalRequired)
+	}
+	if tool.RequiresApproval && d.approver == nil {
+		return fmt.Errorf("registering %s: %w", tool.Name, ErrNoApprover)
+	}
+
 	// Save tool definition to the base struct
 	d.Tools = append(d.Tools, tool)
 	d.toolsMap[tool.Name] = tool
@@ -48,22 +165,104 @@ func (d *Doppelganger) RegisterTool(tool tool.DataSourceTool) error {
 }
 
 func (d *Doppelganger) MakeDecision(ctx context.Context, systemInstruction, userInstruction, model string) (string, error) {
-	// Get Provider
-	provider, err := d.providerGeneratorFunc(model)
+	res, err := d.Decide(ctx, DecisionRequest{
+		SystemInstruction: systemInstruction,
+		UserInstruction:   userInstruction,
+		Model:             model,
+	})
 	if err != nil {
 		return "", err
 	}
 
+	return res.Answer, nil
+}
+
+// Decide runs the model with the registered tools until it answers, and
+// returns the answer with a trace of the tool calls made on the way.
+func (d *Doppelganger) Decide(ctx context.Context, req DecisionRequest) (result *DecisionResult, err error) {
+	if req.CorrelationID == "" {
+		req.CorrelationID = uuid.NewString()
+	}
+	if req.Vault == nil {
+		req.Vault = redact.NewVault()
+	}
+	ctx, log := d.withCorrelationID(ctx, req.CorrelationID)
+	ctx = redact.WithVault(ctx, req.Vault)
+	session := guard.NewSession()
+	ctx = guard.WithSession(ctx, session)
+	// Untrusted content read in earlier turns is still in the conversation
+	for _, message := range req.History {
+		for _, part := range message.Parts {
+			if res, ok := part.(llms.ToolCallResponse); ok && d.toolsMap[res.Name].Untrusted {
+				session.Received()
+			}
+		}
+	}
+	ctx = telemetry.WithRecordContent(ctx, d.recordContent)
+	// Providers that retry or fall back record it with metrics.FromContext
+	ctx = metrics.WithRecorder(ctx, d.metrics)
+	ctx, span := d.tracer.Start(ctx, "decision", trace.WithAttributes(
+		telemetry.RequestModel.String(req.Model),
+		telemetry.CorrelationID.String(req.CorrelationID),
+	))
+	start := time.Now()
+	var rounds, inputTokens, outputTokens int
+	var messageHistory []llms.MessageContent
+	var offered []string
+	decision := &DecisionResult{CorrelationID: req.CorrelationID, Vault: req.Vault}
+	log.InfoContext(ctx, "decision started", "model", req.Model, "history", len(req.History))
+	defer func() {
+		if d.audit != nil {
+			auditErr := d.writeAudit(ctx, req, start, offered, messageHistory, decision, err)
+			if auditErr != nil {
+				result, err = nil, fmt.Errorf("writing audit record: %w", auditErr)
+			}
+		}
+		d.metrics.Decision(req.Model, metrics.Outcome(err), rounds, time.Since(start))
+		if err != nil {
+			log.ErrorContext(ctx, "decision failed", "model", req.Model, "rounds", rounds, "duration", time.Since(start), "error", err)
+		} else {
+			attrs := []any{"model", req.Model, "rounds", rounds, "tool_calls", len(decision.ToolCalls),
+				"input_tokens", inputTokens, "output_tokens", outputTokens, "duration", time.Since(start)}
+			if decision.Cached {
+				attrs = append(attrs, "cached", true)
+			}
+			log.InfoContext(ctx, "decision finished", attrs...)
+		}
+		span.SetAttributes(
+			telemetry.Rounds.Int(rounds),
+			telemetry.InputTokens.Int(inputTokens),
+			telemetry.OutputTokens.Int(outputTokens),
+		)
+		span.SetAttributes(telemetry.ToolCalls.Int(len(decision.ToolCalls)))
+		telemetry.End(span, err)
+	}()
+
+	// Get Provider
+	provider, err := d.providerGeneratorFunc(req.Model)
+	if err != nil {
+		return nil, err
+	}
+
 	// Construct history
-	messageHistory := []llms.MessageContent{
-		llms.TextParts(llms.ChatMessageTypeSystem, systemInstruction),
-		llms.TextParts(llms.ChatMessageTypeHuman, userInstruction),
+	messageHistory = []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeSystem, req.SystemInstruction),
+	}
+	messageHistory = append(messageHistory, req.History...)
+	messageHistory = append(messageHistory, llms.TextParts(llms.ChatMessageTypeHuman, req.UserInstruction))
+
+	tools, err := d.toolsFor(ctx, req.Tools)
+	if err != nil {
+		return nil, err
 	}
 
 	// Inject tool definitions available to the callout
 	var toolDef []llms.Tool
+	allowed := make(map[string]bool)
 
-	for _, tool := range d.Tools {
+	for _, tool := range tools {
+		allowed[tool.Name] = true
+		offered = append(offered, tool.Name)
 		toolDef = append(toolDef, llms.Tool{
 			Type: "function",
 			Function: &llms.FunctionDefinition{
@@ -74,13 +273,47 @@ func (d *Doppelganger) MakeDecision(ctx context.Context, systemInstruction, user
 		})
 	}
 
+	var lookup *cache.Lookup
+	if d.decisions != nil && len(req.History) == 0 {
+		answer, l, hit := d.decisions.Get(ctx, d.decisionScope(ctx, req, tools), req.UserInstruction)
+		if hit {
+			span.SetAttributes(telemetry.DecisionCache.String(cache.Hit))
+			messageHistory = append(messageHistory, llms.TextParts(llms.ChatMessageTypeAI, answer))
+			decision.Messages = messageHistory[1:]
+			decision.Answer = answer
+			decision.Cached = true
+			if req.StreamingFunc != nil {
+				err = req.StreamingFunc(ctx, []byte(answer))
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			return decision, nil
+		}
+		span.SetAttributes(telemetry.DecisionCache.String(cache.Miss))
+		lookup = l
+	}
+	// Decisions that change data must run again when asked again
+	cacheable := true
+
+	// Providers do not stream to req.StreamingFunc: a round may call tools
+	// after it starts writing, and its text still holds the tokens of the vault
+	callOptions := []llms.CallOption{llms.WithTools(toolDef)}
+
 	// Start inifinite loop
 	for {
-		res, err := provider.GenerateContent(ctx, messageHistory, llms.WithTools(toolDef))
+		rounds += 1
+		session.Sent()
+		res, err := d.generate(ctx, provider, req.Model, rounds, messageHistory, callOptions)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
+		input, output := telemetry.Usage(res)
+		inputTokens += input
+		outputTokens += output
+
 		// Parse response to check if tool calls requested
 		var isToolCalled bool
 		for _, choice := range res.Choices {
@@ -105,9 +338,34 @@ func (d *Doppelganger) MakeDecision(ctx context.Context, systemInstruction, user
 				messageHistory = append(messageHistory, aiResponse)
 
 				// Call tools if requested
-				toolResult, err := d.callTool(ctx, toolCall)
+				start := time.Now()
+				run, err := d.callTool(ctx, toolCall, allowed)
 				if err != nil {
-					return "", err
+					return nil, err
+				}
+				toolResult := run.result
+				if t := d.toolsMap[toolCall.FunctionCall.Name]; t.RequiresApproval || len(t.Invalidates) > 0 || run.outcome != metrics.OK {
+					cacheable = false
+				}
+
+				call := ToolCallTrace{
+					ID:        toolCall.ID,
+					Name:      toolCall.FunctionCall.Name,
+					Arguments: toolCall.FunctionCall.Arguments,
+					Result:    toolResult,
+					StartedAt: start,
+					Duration:  time.Since(start),
+					Cache:     run.cache,
+				}
+				switch run.cache {
+				case cache.Hit:
+					decision.CacheHits++
+				case cache.Miss:
+					decision.CacheMisses++
+				}
+				decision.ToolCalls = append(decision.ToolCalls, call)
+				if req.OnToolCall != nil {
+					req.OnToolCall(call)
 				}
 
 				// Write back
@@ -127,34 +385,370 @@ func (d *Doppelganger) MakeDecision(ctx context.Context, systemInstruction, user
 		}
 
 		if !isToolCalled {
-			return res.Choices[0].Content, nil
+			decision.ResponseModel = llm.ResponseModel(res)
+			messageHistory = append(messageHistory, llms.TextParts(llms.ChatMessageTypeAI, res.Choices[0].Content))
+			decision.Messages = messageHistory[1:]
+			decision.Answer = req.Vault.Detokenise(res.Choices[0].Content)
+			if req.StreamingFunc != nil && decision.Answer != "" {
+				err = req.StreamingFunc(ctx, []byte(decision.Answer))
+				if err != nil {
+					return nil, err
+				}
+			}
+			// Tokens in the answer could not be de-tokenised by the vault of
+			// another decision
+			if lookup != nil && cacheable && decision.Answer == res.Choices[0].Content {
+				d.decisions.Set(ctx, lookup, decision.Answer)
+			}
+
+			return decision, nil
+		}
+	}
+}
+
+// Close closes the data sources of the registered tools. A source shared by
+// several tools is closed once.
+func (d *Doppelganger) Close(ctx context.Context) error {
+	closed := make(map[datasource.DataSource]bool)
+
+	var errs []error
+	for _, t := range d.Tools {
+		if t.Source == nil || closed[t.Source] {
+			continue
+		}
+		closed[t.Source] = true
+
+		err := t.Source.Close(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("closing datasource of %s: %w", t.Name, err))
 		}
 	}
+
+	return errors.Join(errs...)
 }
 
-func (d *Doppelganger) callTool(ctx context.Context, toolRequested llms.ToolCall) (string, error) {
+// generate asks the model for its next response, in a span for the round.
+func (d *Doppelganger) generate(ctx context.Context, provider llms.Model, model string, round int, messages []llms.MessageContent, options []llms.CallOption) (res *llms.ContentResponse, err error) {
+	ctx, span := d.tracer.Start(ctx, "chat "+model,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			telemetry.OperationName.String("chat"),
+			telemetry.RequestModel.String(model),
+			telemetry.Round.Int(round),
+		),
+	)
+	start := time.Now()
+	var input, output int
+	var reasons []string
+	defer func() {
+		d.metrics.ModelCall(model, metrics.Outcome(err), time.Since(start), input, output)
+		if err != nil {
+			d.loggerFor(ctx).ErrorContext(ctx, "model call failed", "model", model, "round", round, "duration", time.Since(start), "error", err)
+		} else {
+			d.loggerFor(ctx).DebugContext(ctx, "model call", "model", model, "round", round, "input_tokens", input, "output_tokens", output,
+				"finish_reasons", reasons, "duration", time.Since(start))
+		}
+		telemetry.End(span, err)
+	}()
+
+	if d.recordContent {
+		prompt, err := json.Marshal(messages)
+		if err == nil {
+			span.SetAttributes(telemetry.Prompt.String(string(prompt)))
+		}
+	}
 
+	res, err = provider.GenerateContent(ctx, messages, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	input, output = telemetry.Usage(res)
+	span.SetAttributes(telemetry.InputTokens.Int(input), telemetry.OutputTokens.Int(output))
+	if model := llm.ResponseModel(res); model != "" {
+		span.SetAttributes(telemetry.ResponseModel.String(model))
+	}
+
+	for _, choice := range res.Choices {
+		if choice.StopReason != "" {
+			reasons = append(reasons, choice.StopReason)
+		}
+	}
+	span.SetAttributes(telemetry.FinishReasons.StringSlice(reasons))
+
+	if d.recordContent && len(res.Choices) > 0 {
+		span.SetAttributes(telemetry.Completion.String(res.Choices[0].Content))
+	}
+
+	return res, nil
+}
+
+// ExecuteTool runs a registered tool with JSON arguments, as if the model had
+// called it, without involving a model.
+func (d *Doppelganger) ExecuteTool(ctx context.Context, name, arguments string) (string, error) {
+	run, err := d.callTool(ctx, llms.ToolCall{
+		ID:   uuid.NewString(),
+		Type: "function",
+		FunctionCall: &llms.FunctionCall{
+			Name:      name,
+			Arguments: arguments,
+		},
+	}, nil)
+	return run.result, err
+}
+
+// AllowedTools returns the registered tools the auth.Principal of ctx may
+// use.
+func (d *Doppelganger) AllowedTools(ctx context.Context) []tool.DataSourceTool {
+	principal := auth.FromContext(ctx)
+
+	tools := make([]tool.DataSourceTool, 0, len(d.Tools))
+	for _, t := range d.Tools {
+		if auth.Allowed(principal, t.Roles) {
+			tools = append(tools, t)
+		}
+	}
+
+	return tools
+}
+
+// toolsFor returns the registered tools with the given names, or all of them
+// when names is empty, leaving out those the caller may not use.
+func (d *Doppelganger) toolsFor(ctx context.Context, names []string) ([]tool.DataSourceTool, error) {
+	if len(names) == 0 {
+		return d.AllowedTools(ctx), nil
+	}
+
+	principal := auth.FromContext(ctx)
+	tools := make([]tool.DataSourceTool, 0, len(names))
+	for _, name := range names {
+		t, exists := d.toolsMap[name]
+		if !exists {
+			return nil, fmt.Errorf("%w: %s", ErrToolNotFound, name)
+		}
+		if auth.Allowed(principal, t.Roles) {
+			tools = append(tools, t)
+		}
+	}
+
+	return tools, nil
+}
+
+// decisionScope returns what the answer to a prompt depends on besides the
+// prompt: the instructions, the model, the tools offered and the caller.
+func (d *Doppelganger) decisionScope(ctx context.Context, req DecisionRequest, tools []tool.DataSourceTool) string {
+	versions := make([]string, 0, len(tools))
+	for _, t := range tools {
+		versions = append(versions, t.Version())
+	}
+
+	scope, _ := json.Marshal([]interface{}{req.SystemInstruction, req.Model, versions, auth.FromContext(ctx)})
+	return string(scope)
+}
+
+// callTool runs the tool requested by the model, in a span for the call, and
+// records its outcome. When allowed is not nil only the tools in it may run.
+func (d *Doppelganger) callTool(ctx context.Context, toolRequested llms.ToolCall, allowed map[string]bool) (run toolRun, err error) {
+	ctx = telemetry.WithRecordContent(ctx, d.recordContent)
+	ctx = metrics.WithRecorder(ctx, d.metrics)
+	log := d.loggerFor(ctx)
+	start := time.Now()
+	log.InfoContext(ctx, "tool call", "tool", toolRequested.FunctionCall.Name, "call_id", toolRequested.ID,
+		"arguments", d.redacted(toolRequested.FunctionCall.Name, toolRequested.FunctionCall.Arguments))
+	ctx, span := d.tracer.Start(ctx, "execute_tool "+toolRequested.FunctionCall.Name, trace.WithAttributes(
+		telemetry.OperationName.String("execute_tool"),
+		telemetry.ToolName.String(toolRequested.FunctionCall.Name),
+		telemetry.ToolCallID.String(toolRequested.ID),
+		telemetry.ArgumentsSize.Int(len(toolRequested.FunctionCall.Arguments)),
+	))
+	defer func() {
+		span.SetAttributes(telemetry.ResultSize.Int(len(run.result)))
+		if run.cache != "" {
+			span.SetAttributes(telemetry.Cache.String(run.cache))
+		}
+		if d.recordContent {
+			span.SetAttributes(
+				telemetry.ToolArguments.String(toolRequested.FunctionCall.Arguments),
+				telemetry.ToolResult.String(run.result),
+			)
+		}
+		outcome := run.outcome
+		if err != nil {
+			outcome = metrics.Error
+		}
+		switch outcome {
+		case metrics.OK:
+			attrs := []any{"tool", toolRequested.FunctionCall.Name, "call_id", toolRequested.ID, "size", len(run.result), "duration", time.Since(start)}
+			if run.cache != "" {
+				attrs = append(attrs, "cache", run.cache)
+			}
+			log.InfoContext(ctx, "tool result", attrs...)
+		case metrics.Error:
+			log.ErrorContext(ctx, "tool call failed", "tool", toolRequested.FunctionCall.Name, "call_id", toolRequested.ID,
+				"duration", time.Since(start), "error", err)
+		default:
+			log.WarnContext(ctx, "tool call "+outcome, "tool", toolRequested.FunctionCall.Name, "call_id", toolRequested.ID,
+				"duration", time.Since(start))
+		}
+		d.metrics.ToolCall(toolRequested.FunctionCall.Name, outcome, time.Since(start))
+		telemetry.End(span, err)
+	}()
+
+	return d.runTool(ctx, toolRequested, allowed)
+}
+
+// toolRun is what came of a tool call.
+type toolRun struct {
+	result string
+	// outcome is reported to the metrics recorder
+	outcome string
+	// cache is cache.Hit or cache.Miss for cached tools
+	cache string
+}
+
+// runTool runs the call requested by the model.
+func (d *Doppelganger) runTool(ctx context.Context, toolRequested llms.ToolCall, allowed map[string]bool) (toolRun, error) {
 	rt, exists := d.toolsMap[toolRequested.FunctionCall.Name]
-	if !exists {
-		return "", fmt.Errorf("invalid tool")
+	if !exists || (allowed != nil && !allowed[rt.Name]) {
+		return toolRun{}, fmt.Errorf("invalid tool")
+	}
+
+	session := guard.SessionFrom(ctx)
+	if session != nil && session.Tainted() && !d.guard.AllowedAfterUntrusted(rt.Name) {
+		result, err := errorResult("blocked", fmt.Sprintf("%s may not be called after reading untrusted content", rt.Name))
+		return toolRun{result: result, outcome: metrics.Blocked}, err
 	}
 
 	var params map[string]interface{}
 	err := json.Unmarshal([]byte(toolRequested.FunctionCall.Arguments), &params)
 	if err != nil {
-		return "", err
+		return toolRun{}, err
+	}
+
+	if rt.RequiresApproval {
+		if d.approver == nil {
+			return toolRun{}, ErrNoApprover
+		}
+
+		decision, err := d.approver.Approve(ctx, approval.Request{
+			ID:        uuid.NewString(),
+			Tool:      rt.Name,
+			Arguments: toolRequested.FunctionCall.Arguments,
+		})
+		if err != nil {
+			return toolRun{}, err
+		}
+
+		trace.SpanFromContext(ctx).SetAttributes(telemetry.Approved.Bool(decision.Approved))
+
+		// Let the model know so it can carry on without the action
+		if !decision.Approved {
+			result, err := errorResult("rejected", decision.Reason)
+			return toolRun{result: result, outcome: metrics.Rejected}, err
+		}
 	}
 
-	result, err := rt.Execute(ctx, params)
+	result, hit, err := rt.ExecuteCached(ctx, params, d.cache)
+	// Let the model know so it can wait or carry on without the data
+	if errors.Is(err, ratelimit.ErrRateLimited) {
+		result, err := errorResult("rate_limited", fmt.Sprintf("%s is %v, try again later", rt.Name, err))
+		return toolRun{result: result, outcome: metrics.RateLimited}, err
+	}
+	if errors.Is(err, tool.ErrTimeout) {
+		result, err := errorResult("timeout", fmt.Sprintf("%s %v", rt.Name, err))
+		return toolRun{result: result, outcome: metrics.TimedOut}, err
+	}
 	if err != nil {
-		return "", err
+		return toolRun{}, err
+	}
+
+	run := toolRun{outcome: metrics.OK}
+	if d.cache != nil {
+		if rt.CacheTTL > 0 {
+			run.cache = cache.Miss
+			if hit {
+				run.cache = cache.Hit
+			}
+		}
+		if len(rt.Invalidates) > 0 {
+			d.cache.Invalidate(rt.Invalidates...)
+		}
+	}
+	// Cached answers may rely on the data the tool changed
+	if d.decisions != nil && len(rt.Invalidates) > 0 {
+		d.decisions.Invalidate()
+	}
+
+	var matched []string
+	if rt.Untrusted {
+		var withhold bool
+		matched, withhold = d.guard.Inspect(result, d.otherTools(rt.Name, allowed))
+		span := trace.SpanFromContext(ctx)
+		span.SetAttributes(telemetry.Untrusted.Bool(true))
+		if len(matched) > 0 {
+			span.SetAttributes(telemetry.Injection.StringSlice(matched))
+			d.loggerFor(ctx).WarnContext(ctx, "possible prompt injection", "tool", rt.Name, "call_id", toolRequested.ID,
+				"heuristics", matched)
+		}
+		if withhold {
+			run.result, err = errorResult("withheld", "the result looked like an attempt to instruct the assistant and was withheld")
+			run.outcome = metrics.Withheld
+			return run, err
+		}
 	}
 
 	resBytes, err := json.Marshal(result)
+	if err != nil {
+		return toolRun{}, err
+	}
+
+	if !rt.Untrusted {
+		run.result = string(resBytes)
+		return run, nil
+	}
+
+	if session == nil {
+		session = guard.NewSession()
+	}
+	session.Received()
+
+	run.result = d.guard.Label(session, rt.Name, string(resBytes), matched)
+	return run, nil
+}
+
+// otherTools returns the names of the tools the model may call besides
+// name, which untrusted content should not mention.
+func (d *Doppelganger) otherTools(name string, allowed map[string]bool) []string {
+	var names []string
+	for _, t := range d.Tools {
+		if t.Name != name && (allowed == nil || allowed[t.Name]) {
+			names = append(names, t.Name)
+		}
+	}
+
+	return names
+}
+
+// loggerFor returns the logger of the decision running under ctx, or the
+// logger of the agent for calls made outside a decision.
+func (d *Doppelganger) loggerFor(ctx context.Context) *slog.Logger {
+	if log, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return log
+	}
+
+	return d.logger
+}
+
+// errorResult builds the tool result returned to the model when a call did
+// not run, so the model can react instead of the decision failing.
+func errorResult(code, message string) (string, error) {
+	resBytes, err := json.Marshal(map[string]string{
+		"error":   code,
+		"message": message,
+	})
 	if err != nil {
 		return "", err
 	}
 
 	return string(resBytes), nil
-
 }
@@ -2,6 +2,9 @@ package doppelganger
 
 import (
 	"context"
+	"doppelganger/pkg/approval"
+	"doppelganger/pkg/datasource"
+	"doppelganger/pkg/redact"
 	"doppelganger/pkg/tool"
 	"errors"
 	"fmt"
@@ -15,6 +18,7 @@ func TestRegisterTool(t *testing.T) {
 	tt := []struct {
 		description   string
 		toolDef       tool.DataSourceTool
+		opts          []Option
 		expectedError bool
 	}{
 		{
@@ -55,11 +59,49 @@ func TestRegisterTool(t *testing.T) {
 			},
 			expectedError: true,
 		},
+		{
+			description: "When a tool requires approval and no approver is configured, it should throw an error",
+			toolDef: tool.DataSourceTool{
+				Name:             "mockFunction",
+				Description:      "A function to interact with the Mock tool",
+				Parameters:       map[string]any{},
+				Source:           &mockDatasource{},
+				RequiresApproval: true,
+			},
+			expectedError: true,
+		},
+		{
+			description: "When a tool requires approval and an approver is configured, it should get added",
+			toolDef: tool.DataSourceTool{
+				Name:             "mockFunction",
+				Description:      "A function to interact with the Mock tool",
+				Parameters:       map[string]any{},
+				Source:           &mockDatasource{},
+				RequiresApproval: true,
+			},
+			opts: []Option{
+				WithApprover(approval.Func(func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+					return approval.Decision{Approved: true}, nil
+				})),
+			},
+			expectedError: false,
+		},
+		{
+			description: "When a tool changes data without requiring approval, it should throw an error",
+			toolDef: tool.DataSourceTool{
+				Name:        "create_case",
+				Description: "Creates a case",
+				Parameters:  map[string]any{},
+				Method:      "post",
+				Source:      &datasource.HTTPDataSource{},
+			},
+			expectedError: true,
+		},
 	}
 
 	for _, test := range tt {
 		t.Run(test.description, func(t *testing.T) {
-			dg := New()
+			dg := New(test.opts...)
 			err := dg.RegisterTool(test.toolDef)
 			if test.expectedError {
 				require.NotNil(t, err)
@@ -73,6 +115,9 @@ func TestRegisterTool(t *testing.T) {
 
 type mockDatasource struct {
 	returnError bool
+	calls       int
+	closed      int
+	constraints map[string]interface{}
 }
 
 func (m *mockDatasource) Connect(ctx context.Context, connectionString string) error {
@@ -80,10 +125,13 @@ func (m *mockDatasource) Connect(ctx context.Context, connectionString string) e
 }
 
 func (m *mockDatasource) Close(ctx context.Context) error {
+	m.closed += 1
 	return nil
 }
 
-func (m *mockDatasource) Query(ctx context.Context, database, method, collection, query string) ([]string, error) {
+func (m *mockDatasource) Query(ctx context.Context, database, method, collection, query string, opts ...datasource.QueryOption) ([]string, error) {
+	m.calls += 1
+	m.constraints = datasource.NewQueryOptions(opts...).Constraints
 	mockError := errors.New("Mock Error")
 	if m.returnError == true {
 		return nil, mockError
@@ -361,9 +409,11 @@ type mockProvider struct {
 	responses []*llms.ContentResponse
 	err       error
 	counter   int
+	messages  []llms.MessageContent
 }
 
 func (m *mockProvider) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	m.messages = messages
 	response := m.responses[m.counter]
 	m.counter += 1
 	return response, m.err
@@ -372,3 +422,419 @@ func (m *mockProvider) GenerateContent(ctx context.Context, messages []llms.Mess
 func (m *mockProvider) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
 	return "", fmt.Errorf("not supported")
 }
+
+func TestMakeDecisionApproval(t *testing.T) {
+	tt := []struct {
+		description           string
+		decision              approval.Decision
+		approverErr           error
+		expectedError         bool
+		expectedQueries       int
+		expectedResultMessage string
+	}{
+		{
+			description:           "when the call is approved the tool should be executed",
+			decision:              approval.Decision{Approved: true},
+			expectedError:         false,
+			expectedQueries:       1,
+			expectedResultMessage: "[\"{ \\\"id\\\": \\\"42\\\" }\"]",
+		},
+		{
+			description:           "when the call is rejected the tool should not be executed and the model should be told why",
+			decision:              approval.Decision{Approved: false, Reason: "not on a Friday"},
+			expectedError:         false,
+			expectedQueries:       0,
+			expectedResultMessage: "{\"error\":\"rejected\",\"message\":\"not on a Friday\"}",
+		},
+		{
+			description:     "when the approver fails should return error",
+			approverErr:     fmt.Errorf("approval service down"),
+			expectedError:   true,
+			expectedQueries: 0,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			var requests []approval.Request
+			d := New(WithApprover(approval.Func(func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+				requests = append(requests, req)
+				return test.decision, test.approverErr
+			})))
+
+			provider := &mockProvider{
+				responses: []*llms.ContentResponse{
+					{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										ID: "123",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "flag_account",
+											Arguments: "{ \"id\": \"42\" }",
+										},
+									},
+								},
+							},
+						},
+					},
+					{
+						Choices: []*llms.ContentChoice{
+							{
+								Content: "done",
+							},
+						},
+					},
+				},
+			}
+			d.providerGeneratorFunc = func(model string) (llms.Model, error) {
+				return provider, nil
+			}
+
+			source := &mockDatasource{}
+			err := d.RegisterTool(tool.DataSourceTool{
+				Name:        "flag_account",
+				Description: "Flags an account for review",
+				Parameters: map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"id": map[string]any{
+							"type": "string",
+						},
+					},
+				},
+				Query:            "{ \"id\": \"{{ .id }}\" }",
+				Method:           "updateOne",
+				Source:           source,
+				RequiresApproval: true,
+			})
+			require.Nil(t, err)
+
+			_, err = d.MakeDecision(context.Background(), "abc", "efg", "mock")
+			require.Equal(t, test.expectedQueries, source.calls)
+			require.Len(t, requests, 1)
+			require.Equal(t, "flag_account", requests[0].Tool)
+			if test.expectedError {
+				require.NotNil(t, err)
+				return
+			}
+
+			require.Nil(t, err)
+			toolResponse := provider.messages[len(provider.messages)-1].Parts[0].(llms.ToolCallResponse)
+			require.Equal(t, test.expectedResultMessage, toolResponse.Content)
+		})
+	}
+}
+
+func TestDecide(t *testing.T) {
+	tt := []struct {
+		description          string
+		history              []llms.MessageContent
+		responses            []*llms.ContentResponse
+		expectedAnswer       string
+		expectedToolCalls    []string
+		expectedSentMessages int
+		expectedMessages     int
+	}{
+		{
+			description: "when no tool is called should return the answer without a trace",
+			responses: []*llms.ContentResponse{
+				{Choices: []*llms.ContentChoice{{Content: "hello"}}},
+			},
+			expectedAnswer:       "hello",
+			expectedSentMessages: 2,
+			expectedMessages:     2,
+		},
+		{
+			description: "when tools are called should trace every call",
+			responses: []*llms.ContentResponse{
+				{
+					Choices: []*llms.ContentChoice{
+						{
+							ToolCalls: []llms.ToolCall{
+								{ID: "1", FunctionCall: &llms.FunctionCall{Name: "get_user", Arguments: "{ \"id\": \"1\" }"}},
+								{ID: "2", FunctionCall: &llms.FunctionCall{Name: "get_user", Arguments: "{ \"id\": \"2\" }"}},
+							},
+						},
+					},
+				},
+				{Choices: []*llms.ContentChoice{{Content: "two users"}}},
+			},
+			expectedAnswer:       "two users",
+			expectedToolCalls:    []string{"{\"id\":\"1\"}", "{\"id\":\"2\"}"},
+			expectedSentMessages: 6,
+			expectedMessages:     6,
+		},
+		{
+			description: "when history is passed should send it before the new instruction",
+			history: []llms.MessageContent{
+				llms.TextParts(llms.ChatMessageTypeHuman, "who am I?"),
+				llms.TextParts(llms.ChatMessageTypeAI, "user 1"),
+			},
+			responses: []*llms.ContentResponse{
+				{Choices: []*llms.ContentChoice{{Content: "still user 1"}}},
+			},
+			expectedAnswer:       "still user 1",
+			expectedSentMessages: 4,
+			expectedMessages:     4,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			d := New()
+			provider := &mockProvider{responses: test.responses}
+			d.providerGeneratorFunc = func(model string) (llms.Model, error) {
+				return provider, nil
+			}
+
+			err := d.RegisterTool(tool.DataSourceTool{
+				Name:        "get_user",
+				Description: "Gets a user",
+				Parameters:  map[string]any{"type": "object"},
+				Query:       "{\"id\":\"{{ .id }}\"}",
+				Source:      &mockDatasource{},
+			})
+			require.Nil(t, err)
+
+			var traced []ToolCallTrace
+			res, err := d.Decide(context.Background(), DecisionRequest{
+				SystemInstruction: "abc",
+				UserInstruction:   "efg",
+				Model:             "mock",
+				History:           test.history,
+				OnToolCall: func(trace ToolCallTrace) {
+					traced = append(traced, trace)
+				},
+			})
+			require.Nil(t, err)
+			require.Equal(t, res.ToolCalls, traced)
+			require.Equal(t, test.expectedAnswer, res.Answer)
+			require.Len(t, provider.messages, test.expectedSentMessages)
+			require.Len(t, res.Messages, test.expectedMessages)
+			require.Equal(t, llms.ChatMessageTypeHuman, res.Messages[len(test.history)].Role)
+			require.Equal(t, llms.ChatMessageTypeAI, res.Messages[len(res.Messages)-1].Role)
+
+			require.Len(t, res.ToolCalls, len(test.expectedToolCalls))
+			for i, query := range test.expectedToolCalls {
+				require.Equal(t, "get_user", res.ToolCalls[i].Name)
+				require.Equal(t, fmt.Sprintf("[%q]", query), res.ToolCalls[i].Result)
+			}
+		})
+	}
+}
+
+func TestExecuteTool(t *testing.T) {
+	tt := []struct {
+		description    string
+		name           string
+		arguments      string
+		expectedError  bool
+		expectedResult string
+	}{
+		{
+			description:    "when the tool exists should run it",
+			name:           "get_user",
+			arguments:      "{ \"id\": \"42\" }",
+			expectedError:  false,
+			expectedResult: "[\"{\\\"id\\\":\\\"42\\\"}\"]",
+		},
+		{
+			description:   "when the tool does not exist should return error",
+			name:          "delete_user",
+			arguments:     "{}",
+			expectedError: true,
+		},
+		{
+			description:   "when the arguments are not JSON should return error",
+			name:          "get_user",
+			arguments:     "id=42",
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			d := New()
+			err := d.RegisterTool(tool.DataSourceTool{
+				Name:        "get_user",
+				Description: "Gets a user",
+				Parameters:  map[string]any{"type": "object"},
+				Query:       "{\"id\":\"{{ .id }}\"}",
+				Source:      &mockDatasource{},
+			})
+			require.Nil(t, err)
+
+			result, err := d.ExecuteTool(context.Background(), test.name, test.arguments)
+			if test.expectedError {
+				require.NotNil(t, err)
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, test.expectedResult, result)
+		})
+	}
+}
+
+func TestClose(t *testing.T) {
+	shared := &mockDatasource{}
+	other := &mockDatasource{}
+
+	d := New()
+	for i, source := range []*mockDatasource{shared, shared, other} {
+		err := d.RegisterTool(tool.DataSourceTool{
+			Name:        fmt.Sprintf("tool_%d", i),
+			Description: "A tool",
+			Parameters:  map[string]any{"type": "object"},
+			Source:      source,
+		})
+		require.Nil(t, err)
+	}
+
+	err := d.Close(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, 1, shared.closed)
+	require.Equal(t, 1, other.closed)
+}
+
+func TestDecideTools(t *testing.T) {
+	tt := []struct {
+		description     string
+		tools           []string
+		expectedError   error
+		expectedQueries int
+	}{
+		{
+			description:     "when no tools are listed should allow every tool",
+			tools:           nil,
+			expectedQueries: 1,
+		},
+		{
+			description:     "when the called tool is listed should run it",
+			tools:           []string{"get_user"},
+			expectedQueries: 1,
+		},
+		{
+			description:     "when the called tool is not listed should return error",
+			tools:           []string{"get_account"},
+			expectedQueries: 0,
+		},
+		{
+			description:     "when a listed tool does not exist should return error",
+			tools:           []string{"delete_user"},
+			expectedError:   ErrToolNotFound,
+			expectedQueries: 0,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			provider := &mockProvider{
+				responses: []*llms.ContentResponse{
+					{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{ID: "1", FunctionCall: &llms.FunctionCall{Name: "get_user", Arguments: "{}"}},
+								},
+							},
+						},
+					},
+					{Choices: []*llms.ContentChoice{{Content: "done"}}},
+				},
+			}
+			d := New(WithProviderGenerator(func(model string) (llms.Model, error) {
+				return provider, nil
+			}))
+
+			source := &mockDatasource{}
+			for _, name := range []string{"get_user", "get_account"} {
+				err := d.RegisterTool(tool.DataSourceTool{
+					Name:        name,
+					Description: "Gets something",
+					Parameters:  map[string]any{"type": "object"},
+					Source:      source,
+				})
+				require.Nil(t, err)
+			}
+
+			profile := Profile{Name: "support", Model: "mock", SystemInstruction: "abc", Tools: test.tools}
+			_, err := d.Decide(context.Background(), profile.Request("efg"))
+			require.Equal(t, test.expectedQueries, source.calls)
+			if test.expectedQueries == 0 {
+				require.NotNil(t, err)
+				if test.expectedError != nil {
+					require.ErrorIs(t, err, test.expectedError)
+				}
+				return
+			}
+
+			require.Nil(t, err)
+		})
+	}
+}
+
+func TestDecideRedaction(t *testing.T) {
+	redactor, err := redact.New(redact.Rules{Detect: []redact.Kind{redact.IBAN, redact.Email}, Action: redact.Tokenise})
+	require.Nil(t, err)
+
+	provider := &mockProvider{
+		responses: []*llms.ContentResponse{
+			{Choices: []*llms.ContentChoice{{ToolCalls: []llms.ToolCall{
+				{ID: "call_1", FunctionCall: &llms.FunctionCall{Name: "get_customer", Arguments: `{}`}},
+			}}}},
+			{Choices: []*llms.ContentChoice{{ToolCalls: []llms.ToolCall{
+				{ID: "call_2", FunctionCall: &llms.FunctionCall{Name: "send_statement", Arguments: `{"to":"[EMAIL_1]"}`}},
+			}}}},
+			{Choices: []*llms.ContentChoice{{Content: "Sent the statement of [IBAN_1] to [EMAIL_1]"}}},
+		},
+	}
+
+	d := New(WithProviderGenerator(func(model string) (llms.Model, error) {
+		return provider, nil
+	}))
+	err = d.RegisterTool(tool.DataSourceTool{
+		Name:        "get_customer",
+		Description: "Gets the customer",
+		Parameters:  map[string]any{"type": "object"},
+		Query:       `{"name":"Jane","email":"jane@example.com","iban":"GB82WEST12345698765432"}`,
+		Source:      &mockDatasource{},
+		Redact:      redactor,
+	})
+	require.Nil(t, err)
+	err = d.RegisterTool(tool.DataSourceTool{
+		Name:        "send_statement",
+		Description: "Sends a statement",
+		Parameters:  map[string]any{"type": "object"},
+		Query:       `{{ .to }}`,
+		Source:      &mockDatasource{},
+	})
+	require.Nil(t, err)
+
+	var streamed string
+	res, err := d.Decide(context.Background(), DecisionRequest{
+		UserInstruction: "send Jane her statement",
+		Model:           "mock",
+		StreamingFunc: func(ctx context.Context, chunk []byte) error {
+			streamed += string(chunk)
+			return nil
+		},
+	})
+	require.Nil(t, err)
+
+	// The model only sees tokens in the results of tools that redact
+	require.Equal(t, `["{\"email\":\"[EMAIL_1]\",\"iban\":\"[IBAN_1]\",\"name\":\"Jane\"}"]`, res.ToolCalls[0].Result)
+	prompt, err := json.Marshal(provider.messages)
+	require.Nil(t, err)
+	require.NotContains(t, string(prompt), "GB82WEST12345698765432")
+
+	// Tools get the values behind the tokens
+	require.Equal(t, `["jane@example.com"]`, res.ToolCalls[1].Result)
+
+	// The end user gets the values, the conversation keeps the tokens
+	require.Equal(t, "Sent the statement of GB82WEST12345698765432 to jane@example.com", res.Answer)
+	require.Equal(t, res.Answer, streamed)
+	require.Equal(t, llms.TextParts(llms.ChatMessageTypeAI, "Sent the statement of [IBAN_1] to [EMAIL_1]"), res.Messages[len(res.Messages)-1])
+}
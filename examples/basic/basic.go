@@ -4,6 +4,7 @@ import (
 	"context"
 	"doppelganger"
 	"doppelganger/pkg/datasource"
+	"doppelganger/pkg/secret"
 	"doppelganger/pkg/tool"
 )
 
@@ -13,7 +14,13 @@ func main() {
 
 	mongoConnection := datasource.NewMongoDataSource()
 
-	err := mongoConnection.Connect(ctx, "mongodb://localhost:27017")
+	// Keep credentials out of the code, e.g. MONGO_URI=mongodb://localhost:27017
+	uri, err := secret.NewStore().Resolve(ctx, "env://MONGO_URI")
+	if err != nil {
+		panic(err)
+	}
+
+	err = mongoConnection.Connect(ctx, uri)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"doppelganger"
+	"doppelganger/pkg/config"
+)
+
+func main() {
+	ctx := context.Background()
+
+	cfg, err := config.Load("examples/config/config.yaml")
+	if err != nil {
+		panic(err)
+	}
+
+	// Model API keys come from the providers section of the config
+	app := doppelganger.New(doppelganger.WithProviderGenerator(cfg.ProviderGenerator(cfg.SecretStore())))
+
+	sources, err := cfg.Apply(ctx, app)
+	if err != nil {
+		panic(err)
+	}
+	defer sources.Close(ctx)
+
+	systemInstruction := "You are a helpful assistant"
+	prompt := "Can you validate if this swift code exists? Swift Code: UBSWCHZH80A"
+	model := "gpt-4.1"
+
+	result, err := app.MakeDecision(ctx, systemInstruction, prompt, model)
+	if err != nil {
+		panic(err)
+	}
+
+	println(result)
+}
@@ -0,0 +1,66 @@
+package doppelganger
+
+import (
+	"context"
+	"log/slog"
+)
+
+// ArgumentRedactor returns the arguments of a tool call as they should
+// appear in logs, for example with personal data masked.
+type ArgumentRedactor func(tool, arguments string) string
+
+// WithLogger logs decisions, model calls and tool calls to l. Tool arguments
+// are logged at info level, so consider WithArgumentRedactor.
+func WithLogger(l *slog.Logger) Option {
+	return func(d *Doppelganger) {
+		d.logger = l
+	}
+}
+
+// WithArgumentRedactor rewrites tool arguments before they are logged.
+func WithArgumentRedactor(f ArgumentRedactor) Option {
+	return func(d *Doppelganger) {
+		d.redactArguments = f
+	}
+}
+
+type loggerKey struct{}
+
+type correlationKey struct{}
+
+// withCorrelationID returns a context carrying id and a logger that adds it
+// to every line.
+func (d *Doppelganger) withCorrelationID(ctx context.Context, id string) (context.Context, *slog.Logger) {
+	log := d.logger.With("correlation_id", id)
+	ctx = context.WithValue(ctx, correlationKey{}, id)
+	ctx = context.WithValue(ctx, loggerKey{}, log)
+
+	return ctx, log
+}
+
+// Logger returns the logger of the decision running under ctx, which adds
+// its correlation ID to every line, or a logger that discards everything.
+// Providers that retry or fall back between models can use it so their logs
+// are correlated with the decision.
+func Logger(ctx context.Context) *slog.Logger {
+	if log, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return log
+	}
+
+	return slog.New(slog.DiscardHandler)
+}
+
+// CorrelationID returns the correlation ID of the decision running under
+// ctx, or an empty string.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+func (d *Doppelganger) redacted(tool, arguments string) string {
+	if d.redactArguments == nil {
+		return arguments
+	}
+
+	return d.redactArguments(tool, arguments)
+}
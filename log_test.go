@@ -0,0 +1,138 @@
+package doppelganger
+
+import (
+	"bytes"
+	"context"
+	"doppelganger/pkg/tool"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestLogging(t *testing.T) {
+	tt := []struct {
+		description       string
+		correlationID     string
+		providerError     error
+		redactor          ArgumentRedactor
+		expectedError     bool
+		expectedMessages  []string
+		expectedArguments string
+	}{
+		{
+			description:       "when a decision calls a tool should log the decision, the model calls, the call and its result",
+			expectedMessages:  []string{"decision started", "model call", "tool call", "tool result", "model call", "decision finished"},
+			expectedArguments: `{"id":"42"}`,
+		},
+		{
+			description:       "when the caller sets a correlation ID should use it",
+			correlationID:     "req-123",
+			expectedMessages:  []string{"decision started", "model call", "tool call", "tool result", "model call", "decision finished"},
+			expectedArguments: `{"id":"42"}`,
+		},
+		{
+			description: "when a redactor is set should log the redacted arguments",
+			redactor: func(tool, arguments string) string {
+				return tool + ": redacted"
+			},
+			expectedMessages:  []string{"decision started", "model call", "tool call", "tool result", "model call", "decision finished"},
+			expectedArguments: "get_user: redacted",
+		},
+		{
+			description:      "when the provider fails should log the error of the model call and the decision",
+			providerError:    fmt.Errorf("rate limited by provider"),
+			expectedError:    true,
+			expectedMessages: []string{"decision started", "model call failed", "decision failed"},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+			provider := &mockProvider{
+				err: test.providerError,
+				responses: []*llms.ContentResponse{
+					{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{ID: "call_1", FunctionCall: &llms.FunctionCall{Name: "get_user", Arguments: `{"id":"42"}`}},
+								},
+							},
+						},
+					},
+					{
+						Choices: []*llms.ContentChoice{{Content: "user 42"}},
+					},
+				},
+			}
+
+			opts := []Option{
+				WithLogger(logger),
+				WithProviderGenerator(func(model string) (llms.Model, error) {
+					return provider, nil
+				}),
+			}
+			if test.redactor != nil {
+				opts = append(opts, WithArgumentRedactor(test.redactor))
+			}
+			d := New(opts...)
+
+			err := d.RegisterTool(tool.DataSourceTool{
+				Name:        "get_user",
+				Description: "Gets a user",
+				Parameters:  map[string]any{"type": "object"},
+				Method:      "findOne",
+				Query:       `{"id":"{{ .id }}"}`,
+				Source:      &mockDatasource{},
+			})
+			require.Nil(t, err)
+
+			res, err := d.Decide(context.Background(), DecisionRequest{
+				UserInstruction: "who is 42?",
+				Model:           "mock",
+				CorrelationID:   test.correlationID,
+			})
+			if test.expectedError {
+				require.NotNil(t, err)
+			} else {
+				require.Nil(t, err)
+				require.NotEmpty(t, res.CorrelationID)
+				if test.correlationID != "" {
+					require.Equal(t, test.correlationID, res.CorrelationID)
+				}
+			}
+
+			var messages []string
+			ids := make(map[string]bool)
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var entry map[string]interface{}
+				err := json.Unmarshal([]byte(line), &entry)
+				require.Nil(t, err)
+
+				messages = append(messages, entry["msg"].(string))
+				ids[entry["correlation_id"].(string)] = true
+
+				if entry["msg"] == "tool call" {
+					require.Equal(t, test.expectedArguments, entry["arguments"])
+				}
+				if strings.HasSuffix(entry["msg"].(string), "failed") {
+					require.Equal(t, "ERROR", entry["level"])
+				}
+			}
+			require.Equal(t, test.expectedMessages, messages)
+
+			// Every line carries the same correlation ID, the one returned
+			require.Len(t, ids, 1)
+			if res != nil {
+				require.True(t, ids[res.CorrelationID])
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package doppelganger
+
+import (
+	"context"
+	"doppelganger/pkg/approval"
+	"doppelganger/pkg/metrics"
+	"doppelganger/pkg/tool"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+type mockRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (m *mockRecorder) record(format string, args ...interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, fmt.Sprintf(format, args...))
+}
+
+func (m *mockRecorder) Decision(model, outcome string, rounds int, duration time.Duration) {
+	m.record("decision %s %s rounds=%d", model, outcome, rounds)
+}
+
+func (m *mockRecorder) ModelCall(model, outcome string, duration time.Duration, inputTokens, outputTokens int) {
+	m.record("model %s %s in=%d out=%d", model, outcome, inputTokens, outputTokens)
+}
+
+func (m *mockRecorder) ToolCall(tool, outcome string, duration time.Duration) {
+	m.record("tool %s %s", tool, outcome)
+}
+
+func (m *mockRecorder) Query(sourceType, method, outcome string, duration time.Duration) {
+	m.record("query %s %s %s", sourceType, method, outcome)
+}
+
+func (m *mockRecorder) Retry(model string) {
+	m.record("retry %s", model)
+}
+
+func (m *mockRecorder) Fallback(from, to string) {
+	m.record("fallback %s %s", from, to)
+}
+
+func TestMetrics(t *testing.T) {
+	tt := []struct {
+		description      string
+		sourceError      bool
+		requiresApproval bool
+		expectedError    bool
+		expectedEvents   []string
+	}{
+		{
+			description: "when a decision calls a tool should record the decision, every model call, the tool and the query",
+			expectedEvents: []string{
+				"model mock ok in=100 out=20",
+				"query mock findOne ok",
+				"tool get_user ok",
+				"model mock ok in=150 out=5",
+				"decision mock ok rounds=2",
+			},
+		},
+		{
+			description:   "when the query fails should record the error on the query, the tool and the decision",
+			sourceError:   true,
+			expectedError: true,
+			expectedEvents: []string{
+				"model mock ok in=100 out=20",
+				"query mock findOne error",
+				"tool get_user error",
+				"decision mock error rounds=1",
+			},
+		},
+		{
+			description:      "when the operator rejects the call should record the tool as rejected without a query",
+			requiresApproval: true,
+			expectedEvents: []string{
+				"model mock ok in=100 out=20",
+				"tool get_user rejected",
+				"model mock ok in=150 out=5",
+				"decision mock ok rounds=2",
+			},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			recorder := &mockRecorder{}
+
+			provider := &mockProvider{
+				responses: []*llms.ContentResponse{
+					{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{ID: "call_1", FunctionCall: &llms.FunctionCall{Name: "get_user", Arguments: `{"id":"42"}`}},
+								},
+								GenerationInfo: map[string]any{"PromptTokens": 100, "CompletionTokens": 20},
+							},
+						},
+					},
+					{
+						Choices: []*llms.ContentChoice{
+							{
+								Content:        "user 42",
+								GenerationInfo: map[string]any{"PromptTokens": 150, "CompletionTokens": 5},
+							},
+						},
+					},
+				},
+			}
+
+			d := New(
+				WithMetrics(recorder),
+				WithApprover(approval.Func(func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+					return approval.Decision{Approved: false, Reason: "no"}, nil
+				})),
+				WithProviderGenerator(func(model string) (llms.Model, error) {
+					return provider, nil
+				}),
+			)
+
+			err := d.RegisterTool(tool.DataSourceTool{
+				Name:             "get_user",
+				Description:      "Gets a user",
+				Parameters:       map[string]any{"type": "object"},
+				Database:         "crm",
+				Collection:       "users",
+				Method:           "findOne",
+				Query:            `{"id":"{{ .id }}"}`,
+				Source:           &mockDatasource{returnError: test.sourceError},
+				RequiresApproval: test.requiresApproval,
+			})
+			require.Nil(t, err)
+
+			_, err = d.MakeDecision(context.Background(), "abc", "who is 42?", "mock")
+			if test.expectedError {
+				require.NotNil(t, err)
+			} else {
+				require.Nil(t, err)
+			}
+
+			require.Equal(t, test.expectedEvents, recorder.events)
+		})
+	}
+}
+
+// retryingProvider records a retry of primary and a fall back to fallback
+// before answering with fallback, like a provider wrapping several models.
+type retryingProvider struct {
+	fallback llms.Model
+}
+
+func (r *retryingProvider) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	recorder := metrics.FromContext(ctx)
+	recorder.Retry("primary")
+	recorder.Fallback("primary", "fallback")
+
+	return r.fallback.GenerateContent(ctx, messages, options...)
+}
+
+func (r *retryingProvider) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return "", fmt.Errorf("not supported")
+}
+
+func TestMetricsRetryAndFallback(t *testing.T) {
+	recorder := &mockRecorder{}
+	provider := &retryingProvider{fallback: &mockProvider{
+		responses: []*llms.ContentResponse{{Choices: []*llms.ContentChoice{{Content: "hello"}}}},
+	}}
+
+	d := New(
+		WithMetrics(recorder),
+		WithProviderGenerator(func(model string) (llms.Model, error) {
+			return provider, nil
+		}),
+	)
+
+	_, err := d.MakeDecision(context.Background(), "abc", "hi", "primary")
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		"retry primary",
+		"fallback primary fallback",
+		"model primary ok in=0 out=0",
+		"decision primary ok rounds=1",
+	}, recorder.events)
+}
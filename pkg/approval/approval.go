@@ -0,0 +1,130 @@
+package approval
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+var ErrUnknownRequest = errors.New("unknown approval request")
+
+// Request describes a tool call that needs a human decision before it runs.
+type Request struct {
+	ID        string
+	Tool      string
+	Arguments string
+}
+
+type Decision struct {
+	Approved bool
+	Reason   string
+}
+
+type Approver interface {
+	Approve(ctx context.Context, req Request) (Decision, error)
+}
+
+type Func func(ctx context.Context, req Request) (Decision, error)
+
+func (f Func) Approve(ctx context.Context, req Request) (Decision, error) {
+	return f(ctx, req)
+}
+
+// Prompt asks for approval on a terminal and waits for a yes or no answer.
+type Prompt struct {
+	in  *bufio.Reader
+	out io.Writer
+	mu  sync.Mutex
+}
+
+func NewPrompt(in io.Reader, out io.Writer) *Prompt {
+	return &Prompt{
+		in:  bufio.NewReader(in),
+		out: out,
+	}
+}
+
+func (p *Prompt) Approve(ctx context.Context, req Request) (Decision, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err := fmt.Fprintf(p.out, "Tool %s wants to run with arguments %s\nApprove? [y/N]: ", req.Tool, req.Arguments)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	answer, err := p.in.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && answer != "") {
+		return Decision{}, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return Decision{Approved: true}, nil
+	}
+
+	return Decision{Approved: false, Reason: "rejected by operator"}, nil
+}
+
+// Callback hands approval requests to a service through Notify, for example
+// to post them to a review queue, and blocks until Resolve is called with the
+// answer or the context is done.
+type Callback struct {
+	notify  func(ctx context.Context, req Request) error
+	pending map[string]chan Decision
+	mu      sync.Mutex
+}
+
+func NewCallback(notify func(ctx context.Context, req Request) error) *Callback {
+	return &Callback{
+		notify:  notify,
+		pending: make(map[string]chan Decision),
+	}
+}
+
+func (c *Callback) Approve(ctx context.Context, req Request) (Decision, error) {
+	ch := make(chan Decision, 1)
+
+	c.mu.Lock()
+	c.pending[req.ID] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, req.ID)
+		c.mu.Unlock()
+	}()
+
+	err := c.notify(ctx, req)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	select {
+	case d := <-ch:
+		return d, nil
+	case <-ctx.Done():
+		return Decision{}, ctx.Err()
+	}
+}
+
+// Resolve answers the pending request with the given ID.
+func (c *Callback) Resolve(id string, d Decision) error {
+	c.mu.Lock()
+	ch, ok := c.pending[id]
+	if ok {
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownRequest, id)
+	}
+
+	ch <- d
+	return nil
+}
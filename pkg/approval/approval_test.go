@@ -0,0 +1,119 @@
+package approval
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromptApprove(t *testing.T) {
+	tt := []struct {
+		description      string
+		input            string
+		expectError      bool
+		expectedApproved bool
+	}{
+		{
+			description:      "When the operator answers yes, the call is approved",
+			input:            "y\n",
+			expectedApproved: true,
+		},
+		{
+			description:      "When the operator answers yes without a newline, the call is approved",
+			input:            "YES",
+			expectedApproved: true,
+		},
+		{
+			description:      "When the operator answers anything else, the call is rejected",
+			input:            "no\n",
+			expectedApproved: false,
+		},
+		{
+			description: "When there is no input, an error is returned",
+			input:       "",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			var out bytes.Buffer
+			p := NewPrompt(strings.NewReader(test.input), &out)
+
+			d, err := p.Approve(context.Background(), Request{ID: "1", Tool: "flag_account", Arguments: "{\"id\":\"42\"}"})
+			if test.expectError {
+				require.NotNil(t, err)
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, test.expectedApproved, d.Approved)
+			require.Contains(t, out.String(), "flag_account")
+		})
+	}
+}
+
+func TestCallbackApprove(t *testing.T) {
+	tt := []struct {
+		description string
+		resolve     *Decision
+		timeout     time.Duration
+		expectError bool
+	}{
+		{
+			description: "When the service resolves the request as approved, the call is approved",
+			resolve:     &Decision{Approved: true},
+			timeout:     time.Second,
+		},
+		{
+			description: "When the service resolves the request as rejected, the call is rejected",
+			resolve:     &Decision{Approved: false, Reason: "not allowed"},
+			timeout:     time.Second,
+		},
+		{
+			description: "When the request is never resolved, the context error is returned",
+			resolve:     nil,
+			timeout:     10 * time.Millisecond,
+			expectError: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			var c *Callback
+			c = NewCallback(func(ctx context.Context, req Request) error {
+				if test.resolve != nil {
+					go func() {
+						require.Nil(t, c.Resolve(req.ID, *test.resolve))
+					}()
+				}
+				return nil
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), test.timeout)
+			defer cancel()
+
+			d, err := c.Approve(ctx, Request{ID: "1", Tool: "flag_account"})
+			if test.expectError {
+				require.NotNil(t, err)
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, *test.resolve, d)
+		})
+	}
+}
+
+func TestCallbackResolveUnknown(t *testing.T) {
+	c := NewCallback(func(ctx context.Context, req Request) error {
+		return nil
+	})
+
+	err := c.Resolve("missing", Decision{Approved: true})
+	require.ErrorIs(t, err, ErrUnknownRequest)
+}
@@ -0,0 +1,181 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/tmc/langchaingo/llms"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+var ErrTampered = errors.New("audit log has been tampered with")
+
+// Record is what happened in a decision: everything the model saw, every
+// tool call it made and what it answered.
+type Record struct {
+	CorrelationID string `json:"correlationId"`
+	// Model is the model requested, and ResponseModel the version that
+	// answered, as named by the provider, when it reports it.
+	Model         string `json:"model"`
+	ResponseModel string `json:"responseModel,omitempty"`
+	System        string `json:"system"`
+	Prompt        string `json:"prompt"`
+	// Tools are the names of the tools offered to the model.
+	Tools []string `json:"tools"`
+	// Messages is the conversation sent to the model, from the system
+	// instruction to the answer, including history, tool calls and results.
+	Messages  []llms.MessageContent `json:"messages"`
+	ToolCalls []ToolCall            `json:"toolCalls"`
+	Answer    string                `json:"answer"`
+	Error     string                `json:"error,omitempty"`
+	// Cached is true when the answer came from the decision cache.
+	Cached     bool      `json:"cached,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+type ToolCall struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Arguments  string    `json:"arguments"`
+	Result     string    `json:"result"`
+	StartedAt  time.Time `json:"startedAt"`
+	DurationMs int64     `json:"durationMs"`
+	// Cache is "hit" when the result was served from the result cache.
+	Cache string `json:"cache,omitempty"`
+}
+
+// Entry is a record as stored. The hash covers the sequence, the hash of the
+// previous entry and the record JSON exactly as stored, so changing,
+// removing or reordering entries breaks the chain.
+type Entry struct {
+	Sequence uint64              `json:"sequence"`
+	PrevHash string              `json:"prevHash"`
+	Hash     string              `json:"hash"`
+	Record   jsoniter.RawMessage `json:"record"`
+}
+
+// Decode returns the record of the entry.
+func (e Entry) Decode() (Record, error) {
+	var r Record
+	err := json.Unmarshal(e.Record, &r)
+	return r, err
+}
+
+// Sink stores entries. Sinks only ever append.
+type Sink interface {
+	Write(ctx context.Context, e Entry) error
+	// Last returns the last entry written, or nil when there is none.
+	Last(ctx context.Context) (*Entry, error)
+	// Entries returns every entry in order.
+	Entries(ctx context.Context) ([]Entry, error)
+	Close(ctx context.Context) error
+}
+
+// Log chains records and writes them to a sink. A sink must have a single
+// Log writing to it, or the chain breaks.
+type Log struct {
+	sink     Sink
+	mu       sync.Mutex
+	sequence uint64
+	last     string
+}
+
+// NewLog continues the chain of the entries already in sink.
+func NewLog(ctx context.Context, sink Sink) (*Log, error) {
+	l := &Log{sink: sink}
+
+	last, err := sink.Last(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if last != nil {
+		l.sequence = last.Sequence
+		l.last = last.Hash
+	}
+
+	return l, nil
+}
+
+// Append chains r to the previous record and writes it.
+func (l *Log) Append(ctx context.Context, r Record) (Entry, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := Entry{
+		Sequence: l.sequence + 1,
+		PrevHash: l.last,
+		Record:   data,
+	}
+	e.Hash = hash(e)
+
+	err = l.sink.Write(ctx, e)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	l.sequence = e.Sequence
+	l.last = e.Hash
+
+	return e, nil
+}
+
+// Verify checks the chain of every entry in the sink.
+func (l *Log) Verify(ctx context.Context) error {
+	entries, err := l.sink.Entries(ctx)
+	if err != nil {
+		return err
+	}
+
+	return Verify(entries)
+}
+
+func (l *Log) Close(ctx context.Context) error {
+	return l.sink.Close(ctx)
+}
+
+// Verify checks that the entries form an unbroken chain from the first
+// entry, and returns an error wrapping ErrTampered at the first that does
+// not.
+func Verify(entries []Entry) error {
+	var prev string
+	for i, e := range entries {
+		if e.Sequence != uint64(i+1) {
+			return fmt.Errorf("%w: entry %d has sequence %d", ErrTampered, i+1, e.Sequence)
+		}
+		if e.PrevHash != prev {
+			return fmt.Errorf("%w: entry %d does not follow entry %d", ErrTampered, e.Sequence, i)
+		}
+		if hash(e) != e.Hash {
+			return fmt.Errorf("%w: entry %d does not match its hash", ErrTampered, e.Sequence)
+		}
+
+		prev = e.Hash
+	}
+
+	return nil
+}
+
+func hash(e Entry) string {
+	h := sha256.New()
+	h.Write([]byte(strconv.FormatUint(e.Sequence, 10)))
+	h.Write([]byte("\n"))
+	h.Write([]byte(e.PrevHash))
+	h.Write([]byte("\n"))
+	h.Write(e.Record)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,179 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	tt := []struct {
+		description   string
+		tamper        func(entries []Entry) []Entry
+		expectedError bool
+	}{
+		{
+			description: "when the entries are untouched should verify",
+			tamper: func(entries []Entry) []Entry {
+				return entries
+			},
+		},
+		{
+			description: "when a record is changed should fail",
+			tamper: func(entries []Entry) []Entry {
+				entries[1].Record = []byte(strings.Replace(string(entries[1].Record), "approved", "rejected", 1))
+				return entries
+			},
+			expectedError: true,
+		},
+		{
+			description: "when a record is changed and its hash recomputed should fail on the next entry",
+			tamper: func(entries []Entry) []Entry {
+				entries[1].Record = []byte(strings.Replace(string(entries[1].Record), "approved", "rejected", 1))
+				entries[1].Hash = hash(entries[1])
+				return entries
+			},
+			expectedError: true,
+		},
+		{
+			description: "when an entry is removed should fail",
+			tamper: func(entries []Entry) []Entry {
+				return append(entries[:1], entries[2:]...)
+			},
+			expectedError: true,
+		},
+		{
+			description: "when entries are reordered should fail",
+			tamper: func(entries []Entry) []Entry {
+				entries[1], entries[2] = entries[2], entries[1]
+				return entries
+			},
+			expectedError: true,
+		},
+		{
+			description: "when the last entry is removed should verify, as truncation is only caught by comparing the last hash",
+			tamper: func(entries []Entry) []Entry {
+				return entries[:2]
+			},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			ctx := context.Background()
+			sink, err := NewFileSink(filepath.Join(t.TempDir(), "audit.jsonl"))
+			require.Nil(t, err)
+			defer sink.Close(ctx)
+
+			l, err := NewLog(ctx, sink)
+			require.Nil(t, err)
+
+			for _, answer := range []string{"first", "approved", "third"} {
+				_, err := l.Append(ctx, Record{CorrelationID: answer, Model: "gpt-4.1", Answer: answer, StartedAt: time.Now()})
+				require.Nil(t, err)
+			}
+
+			entries, err := sink.Entries(ctx)
+			require.Nil(t, err)
+			require.Len(t, entries, 3)
+
+			err = Verify(test.tamper(entries))
+			if test.expectedError {
+				require.True(t, errors.Is(err, ErrTampered))
+			} else {
+				require.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	sink, err := NewFileSink(path)
+	require.Nil(t, err)
+
+	l, err := NewLog(ctx, sink)
+	require.Nil(t, err)
+
+	first, err := l.Append(ctx, Record{CorrelationID: "a", Answer: "yes"})
+	require.Nil(t, err)
+	require.Equal(t, uint64(1), first.Sequence)
+	require.Empty(t, first.PrevHash)
+	require.Nil(t, l.Close(ctx))
+
+	// A new log continues the chain of the file
+	sink, err = NewFileSink(path)
+	require.Nil(t, err)
+
+	l, err = NewLog(ctx, sink)
+	require.Nil(t, err)
+	defer l.Close(ctx)
+
+	second, err := l.Append(ctx, Record{CorrelationID: "b", Answer: "no"})
+	require.Nil(t, err)
+	require.Equal(t, uint64(2), second.Sequence)
+	require.Equal(t, first.Hash, second.PrevHash)
+
+	require.Nil(t, l.Verify(ctx))
+
+	entries, err := sink.Entries(ctx)
+	require.Nil(t, err)
+
+	record, err := entries[1].Decode()
+	require.Nil(t, err)
+	require.Equal(t, "b", record.CorrelationID)
+	require.Equal(t, "no", record.Answer)
+}
+
+// requireChainContinues writes an entry with a log on a sink, and another with
+// a new log on a sink opened again on the same storage, as after a restart,
+// and checks that the second continues the chain of the first.
+func requireChainContinues(t *testing.T, open func() Sink) {
+	ctx := context.Background()
+
+	l, err := NewLog(ctx, open())
+	require.Nil(t, err)
+
+	first, err := l.Append(ctx, Record{CorrelationID: "a", Answer: "yes"})
+	require.Nil(t, err)
+	require.Equal(t, uint64(1), first.Sequence)
+	require.Empty(t, first.PrevHash)
+	require.Nil(t, l.Close(ctx))
+
+	sink := open()
+	l, err = NewLog(ctx, sink)
+	require.Nil(t, err)
+	defer l.Close(ctx)
+
+	second, err := l.Append(ctx, Record{CorrelationID: "b", Answer: "no"})
+	require.Nil(t, err)
+	require.Equal(t, uint64(2), second.Sequence)
+	require.Equal(t, first.Hash, second.PrevHash)
+
+	require.Nil(t, l.Verify(ctx))
+
+	last, err := sink.Last(ctx)
+	require.Nil(t, err)
+	require.Equal(t, second, *last)
+
+	entries, err := sink.Entries(ctx)
+	require.Nil(t, err)
+	require.Equal(t, []Entry{first, second}, entries)
+
+	record, err := entries[1].Decode()
+	require.Nil(t, err)
+	require.Equal(t, "b", record.CorrelationID)
+	require.Equal(t, "no", record.Answer)
+
+	// An entry of the same sequence, such as one from a second writer, is
+	// refused
+	err = sink.Write(ctx, second)
+	require.NotNil(t, err)
+}
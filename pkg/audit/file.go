@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// FileSink appends entries to a file, one JSON entry per line.
+type FileSink struct {
+	path string
+	file *os.File
+	mu   sync.Mutex
+}
+
+// NewFileSink opens path for appending, creating it when needed.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{path: path, file: file}, nil
+}
+
+// Write appends the entry and syncs the file, so that an entry is on disk
+// once written.
+func (f *FileSink) Write(ctx context.Context, e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, err = f.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	return f.file.Sync()
+}
+
+func (f *FileSink) Last(ctx context.Context) (*Entry, error) {
+	entries, err := f.Entries(ctx)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+
+	return &entries[len(entries)-1], nil
+}
+
+func (f *FileSink) Entries(ctx context.Context) ([]Entry, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadEntries(file)
+}
+
+func (f *FileSink) Close(ctx context.Context) error {
+	return f.file.Close()
+}
+
+// ReadEntries reads JSON lines written by a FileSink, for example from a
+// copy of the log handed to an auditor.
+func ReadEntries(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var e Entry
+			uerr := json.Unmarshal(line, &e)
+			if uerr != nil {
+				return nil, uerr
+			}
+			entries = append(entries, e)
+		}
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// GCSSink writes every entry to its own object, named by its sequence under
+// a prefix. Objects are only created, never overwritten. Combine with a
+// bucket retention policy to make them immutable.
+type GCSSink struct {
+	objects objectStore
+	prefix  string
+}
+
+// objectStore is the part of a bucket used by GCSSink.
+type objectStore interface {
+	// create writes a new object, and fails when the name is taken.
+	create(ctx context.Context, name string, data []byte) error
+	// names lists the objects under prefix, in order.
+	names(ctx context.Context, prefix string) ([]string, error)
+	read(ctx context.Context, name string) ([]byte, error)
+}
+
+// NewGCSSink writes entries to bucket under prefix, such as "audit/". The
+// client of the bucket is not closed by Close.
+func NewGCSSink(bucket *storage.BucketHandle, prefix string) *GCSSink {
+	return &GCSSink{objects: bucketStore{bucket: bucket}, prefix: prefix}
+}
+
+func (g *GCSSink) Write(ctx context.Context, e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return g.objects.create(ctx, g.name(e.Sequence), data)
+}
+
+func (g *GCSSink) Last(ctx context.Context) (*Entry, error) {
+	names, err := g.objects.names(ctx, g.prefix)
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+
+	e, err := g.read(ctx, names[len(names)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+func (g *GCSSink) Entries(ctx context.Context) ([]Entry, error) {
+	names, err := g.objects.names(ctx, g.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(names))
+	for _, name := range names {
+		e, err := g.read(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+func (g *GCSSink) Close(ctx context.Context) error {
+	return nil
+}
+
+// name pads the sequence so that objects list in order.
+func (g *GCSSink) name(sequence uint64) string {
+	return fmt.Sprintf("%s%020d.json", g.prefix, sequence)
+}
+
+func (g *GCSSink) read(ctx context.Context, name string) (Entry, error) {
+	data, err := g.objects.read(ctx, name)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	var e Entry
+	err = json.Unmarshal(data, &e)
+	return e, err
+}
+
+// bucketStore keeps the objects of a GCSSink in a bucket.
+type bucketStore struct {
+	bucket *storage.BucketHandle
+}
+
+func (b bucketStore) create(ctx context.Context, name string, data []byte) error {
+	w := b.bucket.Object(name).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	w.ContentType = "application/json"
+
+	_, err := w.Write(data)
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+func (b bucketStore) names(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+
+	it := b.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, attrs.Name)
+	}
+}
+
+func (b bucketStore) read(ctx context.Context, name string) ([]byte, error) {
+	r, err := b.bucket.Object(name).NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeObjects keeps the objects of a GCSSink in memory, refusing to
+// overwrite one like the DoesNotExist condition of the bucket.
+type fakeObjects map[string][]byte
+
+func (f fakeObjects) create(ctx context.Context, name string, data []byte) error {
+	if _, ok := f[name]; ok {
+		return fmt.Errorf("object %s already exists", name)
+	}
+
+	f[name] = data
+	return nil
+}
+
+func (f fakeObjects) names(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	for name := range f {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (f fakeObjects) read(ctx context.Context, name string) ([]byte, error) {
+	data, ok := f[name]
+	if !ok {
+		return nil, fmt.Errorf("object %s does not exist", name)
+	}
+
+	return data, nil
+}
+
+func TestGCSSink(t *testing.T) {
+	objects := fakeObjects{"other/00000000000000000001.json": []byte("{}")}
+	requireChainContinues(t, func() Sink {
+		return &GCSSink{objects: objects, prefix: "audit/"}
+	})
+
+	// Entries are named by their sequence, padded so that they list in order
+	_, ok := objects["audit/00000000000000000002.json"]
+	require.True(t, ok)
+}
@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// MongoSink inserts entries into a collection. The record is stored as its
+// JSON text, so it hashes the same when read back.
+type MongoSink struct {
+	collection mongoCollection
+}
+
+// mongoCollection is the part of a collection used by MongoSink.
+type mongoCollection interface {
+	InsertOne(ctx context.Context, document any, opts ...options.Lister[options.InsertOneOptions]) (*mongo.InsertOneResult, error)
+	FindOne(ctx context.Context, filter any, opts ...options.Lister[options.FindOneOptions]) *mongo.SingleResult
+	Find(ctx context.Context, filter any, opts ...options.Lister[options.FindOptions]) (*mongo.Cursor, error)
+}
+
+type mongoEntry struct {
+	Sequence  int64     `bson:"sequence"`
+	PrevHash  string    `bson:"prevHash"`
+	Hash      string    `bson:"hash"`
+	Record    string    `bson:"record"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+// NewMongoSink creates a unique index on the sequence, so that two writers
+// cannot both extend the chain. The client of the collection is not closed
+// by Close.
+func NewMongoSink(ctx context.Context, collection *mongo.Collection) (*MongoSink, error) {
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "sequence", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MongoSink{collection: collection}, nil
+}
+
+func (m *MongoSink) Write(ctx context.Context, e Entry) error {
+	_, err := m.collection.InsertOne(ctx, mongoEntry{
+		Sequence:  int64(e.Sequence),
+		PrevHash:  e.PrevHash,
+		Hash:      e.Hash,
+		Record:    string(e.Record),
+		CreatedAt: time.Now().UTC(),
+	})
+	return err
+}
+
+func (m *MongoSink) Last(ctx context.Context) (*Entry, error) {
+	var doc mongoEntry
+	err := m.collection.FindOne(ctx, bson.D{}, options.FindOne().SetSort(bson.D{{Key: "sequence", Value: -1}})).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e := doc.entry()
+	return &e, nil
+}
+
+func (m *MongoSink) Entries(ctx context.Context) ([]Entry, error) {
+	cursor, err := m.collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "sequence", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var entries []Entry
+	for cursor.Next(ctx) {
+		var doc mongoEntry
+		err := cursor.Decode(&doc)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, doc.entry())
+	}
+
+	return entries, cursor.Err()
+}
+
+func (m *MongoSink) Close(ctx context.Context) error {
+	return nil
+}
+
+func (doc mongoEntry) entry() Entry {
+	return Entry{
+		Sequence: uint64(doc.Sequence),
+		PrevHash: doc.PrevHash,
+		Hash:     doc.Hash,
+		Record:   []byte(doc.Record),
+	}
+}
@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// fakeCollection keeps the documents of a MongoSink in memory, with the
+// unique index on the sequence. Reads are sorted by sequence, as the sink
+// asks.
+type fakeCollection struct {
+	docs []mongoEntry
+}
+
+func (f *fakeCollection) InsertOne(ctx context.Context, document any, opts ...options.Lister[options.InsertOneOptions]) (*mongo.InsertOneResult, error) {
+	doc := document.(mongoEntry)
+	for _, existing := range f.docs {
+		if existing.Sequence == doc.Sequence {
+			return nil, fmt.Errorf("duplicate key sequence %d", doc.Sequence)
+		}
+	}
+
+	f.docs = append(f.docs, doc)
+	return &mongo.InsertOneResult{}, nil
+}
+
+func (f *fakeCollection) FindOne(ctx context.Context, filter any, opts ...options.Lister[options.FindOneOptions]) *mongo.SingleResult {
+	docs := f.sorted()
+	if len(docs) == 0 {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+
+	return mongo.NewSingleResultFromDocument(docs[len(docs)-1], nil, nil)
+}
+
+func (f *fakeCollection) Find(ctx context.Context, filter any, opts ...options.Lister[options.FindOptions]) (*mongo.Cursor, error) {
+	var docs []any
+	for _, doc := range f.sorted() {
+		docs = append(docs, doc)
+	}
+
+	return mongo.NewCursorFromDocuments(docs, nil, nil)
+}
+
+func (f *fakeCollection) sorted() []mongoEntry {
+	docs := append([]mongoEntry(nil), f.docs...)
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].Sequence < docs[j].Sequence
+	})
+
+	return docs
+}
+
+func TestMongoSink(t *testing.T) {
+	collection := &fakeCollection{}
+	requireChainContinues(t, func() Sink {
+		return &MongoSink{collection: collection}
+	})
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+// Principal is the caller a decision is made for.
+type Principal struct {
+	Subject string
+	Roles   []string
+	// Attributes hold facts about the caller, such as the branch they work
+	// at, that tools can turn into query constraints.
+	Attributes map[string]interface{}
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of ctx, or nil for anonymous callers.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// HasRole tells whether the principal has the role. Anonymous callers have
+// no roles.
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Allowed tells whether p may use a tool requiring any of roles. Tools that
+// require no role are open to every caller.
+func Allowed(p *Principal, roles []string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+
+	for _, role := range roles {
+		if p.HasRole(role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Constraints resolves constraints, which map query fields to attribute
+// names, into the values of p. A missing principal or attribute returns an
+// error wrapping ErrForbidden, so that an unconstrained query never runs.
+func Constraints(p *Principal, constraints map[string]string) (map[string]interface{}, error) {
+	if len(constraints) == 0 {
+		return nil, nil
+	}
+	if p == nil {
+		return nil, fmt.Errorf("%w: the query is constrained by the caller but there is no caller", ErrForbidden)
+	}
+
+	values := make(map[string]interface{}, len(constraints))
+	for field, attribute := range constraints {
+		value, ok := p.Attributes[attribute]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s has no %s attribute", ErrForbidden, p.Subject, attribute)
+		}
+		values[field] = value
+	}
+
+	return values, nil
+}
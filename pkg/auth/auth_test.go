@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowed(t *testing.T) {
+	tt := []struct {
+		description string
+		principal   *Principal
+		roles       []string
+		expected    bool
+	}{
+		{
+			description: "when the tool requires no role should allow anonymous callers",
+			expected:    true,
+		},
+		{
+			description: "when the caller has one of the roles should allow",
+			principal:   &Principal{Subject: "jane", Roles: []string{"teller"}},
+			roles:       []string{"manager", "teller"},
+			expected:    true,
+		},
+		{
+			description: "when the caller has none of the roles should deny",
+			principal:   &Principal{Subject: "jane", Roles: []string{"teller"}},
+			roles:       []string{"manager"},
+			expected:    false,
+		},
+		{
+			description: "when the caller is anonymous and the tool requires a role should deny",
+			roles:       []string{"manager"},
+			expected:    false,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			require.Equal(t, test.expected, Allowed(test.principal, test.roles))
+		})
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	tt := []struct {
+		description    string
+		principal      *Principal
+		constraints    map[string]string
+		expectedValues map[string]interface{}
+		expectedError  bool
+	}{
+		{
+			description: "when the tool has no constraints should return none",
+		},
+		{
+			description:    "when the caller has the attributes should map them to the fields",
+			principal:      &Principal{Subject: "jane", Attributes: map[string]interface{}{"branch": 12}},
+			constraints:    map[string]string{"branch_id": "branch"},
+			expectedValues: map[string]interface{}{"branch_id": 12},
+		},
+		{
+			description:   "when the caller lacks an attribute should be forbidden",
+			principal:     &Principal{Subject: "jane"},
+			constraints:   map[string]string{"branch_id": "branch"},
+			expectedError: true,
+		},
+		{
+			description:   "when there is no caller should be forbidden",
+			constraints:   map[string]string{"branch_id": "branch"},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			values, err := Constraints(test.principal, test.constraints)
+			if test.expectedError {
+				require.True(t, errors.Is(err, ErrForbidden))
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, test.expectedValues, values)
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	require.Nil(t, FromContext(context.Background()))
+
+	p := &Principal{Subject: "jane"}
+	require.Equal(t, p, FromContext(WithPrincipal(context.Background(), p)))
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"net/http"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// Authenticator returns the principal making a request, for example from a
+// verified token, or an error when it cannot be trusted.
+type Authenticator func(r *http.Request) (*Principal, error)
+
+// Middleware authenticates every request before passing it to next, with
+// the principal in its context. Requests that fail are answered with 401.
+func Middleware(authenticate Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := authenticate(r)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized", "message": err.Error()})
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// Cache statuses of a tool call, as reported in its trace.
+const (
+	Hit  = "hit"
+	Miss = "miss"
+)
+
+// Store holds cached values until they expire. LRU keeps them in memory;
+// implement Store to share them between instances, for example in Redis.
+type Store interface {
+	// Get returns the value of key, and whether it was found.
+	Get(ctx context.Context, key string) (string, bool, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+}
+
+// LRU is an in-memory Store holding up to a number of values, evicting the
+// least recently used first.
+type LRU struct {
+	size int
+	now  func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+func NewLRU(size int) *LRU {
+	return &LRU{
+		size:    size,
+		now:     time.Now,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *LRU) Get(ctx context.Context, key string) (string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return "", false, nil
+	}
+
+	e := el.Value.(*entry)
+	if !c.now().Before(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return "", false, nil
+	}
+	c.order.MoveToFront(el)
+
+	return e.value, true, nil
+}
+
+func (c *LRU) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(ttl)
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*entry)
+		e.value, e.expires = value, expires
+		c.order.MoveToFront(el)
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(&entry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).key)
+	}
+
+	return nil
+}
+
+// Len returns the number of values held, expired or not.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Results caches the records returned by tools, keyed on the tool and its
+// arguments. The records are cached before redaction, so a shared Store
+// holds personal data and must be protected like the data sources.
+//
+// Invalidate makes the cached results of tools unreachable. It is local to
+// the process: with a shared Store, every instance must invalidate, or the
+// TTLs must be short enough to tolerate stale results.
+type Results struct {
+	store Store
+
+	mu          sync.Mutex
+	epoch       uint64
+	generations map[string]uint64
+}
+
+func New(store Store) *Results {
+	return &Results{
+		store:       store,
+		generations: make(map[string]uint64),
+	}
+}
+
+// Key returns the key of a call to tool. version identifies the definition of
+// the tool, so a redeployed tool whose query changed misses results kept in a
+// shared Store. Arguments are canonicalised, so the order of their fields
+// does not matter. constraints are the row-level constraints of the caller,
+// which change the records returned.
+func (r *Results) Key(tool, version string, arguments map[string]interface{}, constraints map[string]interface{}) (string, error) {
+	// Map keys are marshalled sorted, which makes the JSON canonical
+	args, err := json.Marshal(arguments)
+	if err != nil {
+		return "", err
+	}
+	cons, err := json.Marshal(constraints)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	epoch, generation := r.epoch, r.generations[tool]
+	r.mu.Unlock()
+
+	key := hash(tool, version, strconv.FormatUint(epoch, 10), strconv.FormatUint(generation, 10), string(args), string(cons))
+
+	return "doppelganger:tool:" + tool + ":" + key, nil
+}
+
+// Get returns the records cached under key. Errors of the store count as
+// misses, so an unavailable cache only makes calls slower.
+func (r *Results) Get(ctx context.Context, key string) ([]string, bool) {
+	value, ok, err := r.store.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, false
+	}
+
+	var records []string
+	if json.Unmarshal([]byte(value), &records) != nil {
+		return nil, false
+	}
+
+	return records, true
+}
+
+// Set caches records under key for ttl. Errors of the store are ignored.
+func (r *Results) Set(ctx context.Context, key string, records []string, ttl time.Duration) {
+	value, err := json.Marshal(records)
+	if err != nil {
+		return
+	}
+
+	_ = r.store.Set(ctx, key, string(value), ttl)
+}
+
+// Invalidate drops the cached results of the tools, or of every tool when
+// none is given.
+func (r *Results) Invalidate(tools ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(tools) == 0 {
+		r.epoch++
+		return
+	}
+	for _, tool := range tools {
+		r.generations[tool]++
+	}
+}
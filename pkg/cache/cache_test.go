@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(2)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	require.Nil(t, c.Set(ctx, "a", "1", time.Minute))
+	require.Nil(t, c.Set(ctx, "b", "2", time.Hour))
+
+	// Reading a makes b the least recently used
+	value, ok, err := c.Get(ctx, "a")
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, "1", value)
+
+	require.Nil(t, c.Set(ctx, "c", "3", time.Hour))
+	require.Equal(t, 2, c.Len())
+	_, ok, _ = c.Get(ctx, "b")
+	require.False(t, ok)
+
+	now = now.Add(2 * time.Minute)
+	_, ok, _ = c.Get(ctx, "a")
+	require.False(t, ok)
+	value, ok, _ = c.Get(ctx, "c")
+	require.True(t, ok)
+	require.Equal(t, "3", value)
+}
+
+type failingStore struct{}
+
+func (failingStore) Get(ctx context.Context, key string) (string, bool, error) {
+	return "", false, errors.New("connection refused")
+}
+
+func (failingStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return errors.New("connection refused")
+}
+
+func TestResults(t *testing.T) {
+	tt := []struct {
+		description string
+		store       Store
+		invalidate  func(r *Results)
+		expectedHit bool
+	}{
+		{
+			description: "when the result is cached should return it",
+			store:       NewLRU(10),
+			invalidate:  func(r *Results) {},
+			expectedHit: true,
+		},
+		{
+			description: "when another tool is invalidated should return the result",
+			store:       NewLRU(10),
+			invalidate:  func(r *Results) { r.Invalidate("get_account") },
+			expectedHit: true,
+		},
+		{
+			description: "when the tool is invalidated should miss",
+			store:       NewLRU(10),
+			invalidate:  func(r *Results) { r.Invalidate("validate_swift_code") },
+		},
+		{
+			description: "when every tool is invalidated should miss",
+			store:       NewLRU(10),
+			invalidate:  func(r *Results) { r.Invalidate() },
+		},
+		{
+			description: "when the store fails should miss",
+			store:       failingStore{},
+			invalidate:  func(r *Results) {},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			ctx := context.Background()
+			r := New(test.store)
+
+			key, err := r.Key("validate_swift_code", "v1", map[string]interface{}{"code": "UBSWCHZH80A"}, nil)
+			require.Nil(t, err)
+			r.Set(ctx, key, []string{`{"valid":true}`}, time.Minute)
+
+			test.invalidate(r)
+
+			key, err = r.Key("validate_swift_code", "v1", map[string]interface{}{"code": "UBSWCHZH80A"}, nil)
+			require.Nil(t, err)
+			records, hit := r.Get(ctx, key)
+			require.Equal(t, test.expectedHit, hit)
+			if hit {
+				require.Equal(t, []string{`{"valid":true}`}, records)
+			}
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	r := New(NewLRU(10))
+	key := func(tool string, arguments, constraints map[string]interface{}) string {
+		k, err := r.Key(tool, "v1", arguments, constraints)
+		require.Nil(t, err)
+		return k
+	}
+
+	base := key("get_account", map[string]interface{}{"id": "42", "fields": []interface{}{"iban"}}, nil)
+	require.Equal(t, base, key("get_account", map[string]interface{}{"fields": []interface{}{"iban"}, "id": "42"}, nil))
+	require.NotEqual(t, base, key("get_account", map[string]interface{}{"id": "43", "fields": []interface{}{"iban"}}, nil))
+	require.NotEqual(t, base, key("get_balance", map[string]interface{}{"id": "42", "fields": []interface{}{"iban"}}, nil))
+	require.NotEqual(t, base, key("get_account", map[string]interface{}{"id": "42", "fields": []interface{}{"iban"}}, map[string]interface{}{"branch_id": "b1"}))
+
+	redeployed, err := r.Key("get_account", "v2", map[string]interface{}{"id": "42", "fields": []interface{}{"iban"}}, nil)
+	require.Nil(t, err)
+	require.NotEqual(t, base, redeployed)
+}
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Embedder turns text into a vector whose distance to others measures how
+// similar the texts are. The embedders of langchaingo implement it.
+type Embedder interface {
+	EmbedQuery(ctx context.Context, text string) ([]float32, error)
+}
+
+// Index finds the stored prompts most similar to another. MemoryIndex keeps
+// them in memory; implement Index to use a vector database.
+type Index interface {
+	Add(ctx context.Context, scope, key string, vector []float32, ttl time.Duration) error
+	// Nearest returns the key of the vector of scope most similar to vector,
+	// with their cosine similarity, and whether scope holds any.
+	Nearest(ctx context.Context, scope string, vector []float32) (string, float64, bool, error)
+}
+
+// Decisions caches the answers of whole decisions, so that questions asked
+// again are answered without the model. Answers are cached for a scope,
+// which holds everything else the answer depends on, such as the system
+// instruction, the model, the tools and the caller.
+//
+// Prompts match when they are the same, ignoring surrounding and repeated
+// whitespace. WithSimilarity also matches prompts that mean the same.
+type Decisions struct {
+	store     Store
+	ttl       time.Duration
+	embedder  Embedder
+	threshold float64
+	index     Index
+
+	mu    sync.Mutex
+	epoch uint64
+}
+
+type DecisionOption func(*Decisions)
+
+// WithSimilarity answers prompts whose embedding has a cosine similarity of
+// at least threshold with a cached one. A threshold too low answers
+// different questions alike, 0.95 is a cautious start.
+func WithSimilarity(e Embedder, threshold float64) DecisionOption {
+	return func(c *Decisions) {
+		c.embedder = e
+		c.threshold = threshold
+	}
+}
+
+// WithIndex sets where the embeddings of the similarity mode are kept, in a
+// MemoryIndex of DefaultIndexSize by default.
+func WithIndex(i Index) DecisionOption {
+	return func(c *Decisions) {
+		c.index = i
+	}
+}
+
+const DefaultIndexSize = 10000
+
+// NewDecisions caches answers in store for ttl.
+func NewDecisions(store Store, ttl time.Duration, opts ...DecisionOption) *Decisions {
+	c := &Decisions{store: store, ttl: ttl}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.embedder != nil && c.index == nil {
+		c.index = NewMemoryIndex(DefaultIndexSize)
+	}
+
+	return c
+}
+
+// Lookup is a prompt looked up in the cache. When it was missed, pass it to
+// Set with the answer once made.
+type Lookup struct {
+	scope  string
+	key    string
+	vector []float32
+}
+
+// Get returns the answer cached for prompt in scope. Errors of the store,
+// the embedder and the index count as misses.
+func (c *Decisions) Get(ctx context.Context, scope, prompt string) (string, *Lookup, bool) {
+	c.mu.Lock()
+	epoch := c.epoch
+	c.mu.Unlock()
+
+	l := &Lookup{scope: hash(strconv.FormatUint(epoch, 10), scope)}
+	l.key = "doppelganger:decision:" + hash(l.scope, strings.Join(strings.Fields(prompt), " "))
+
+	answer, ok, err := c.store.Get(ctx, l.key)
+	if err == nil && ok {
+		return answer, l, true
+	}
+	if c.embedder == nil {
+		return "", l, false
+	}
+
+	vector, err := c.embedder.EmbedQuery(ctx, prompt)
+	if err != nil {
+		return "", l, false
+	}
+	l.vector = vector
+
+	key, similarity, ok, err := c.index.Nearest(ctx, l.scope, vector)
+	if err != nil || !ok || similarity < c.threshold {
+		return "", l, false
+	}
+
+	answer, ok, err = c.store.Get(ctx, key)
+	if err != nil || !ok {
+		return "", l, false
+	}
+
+	return answer, l, true
+}
+
+// Set caches the answer of a missed lookup. Errors are ignored.
+func (c *Decisions) Set(ctx context.Context, l *Lookup, answer string) {
+	if c.store.Set(ctx, l.key, answer, c.ttl) != nil {
+		return
+	}
+	if l.vector != nil {
+		_ = c.index.Add(ctx, l.scope, l.key, l.vector, c.ttl)
+	}
+}
+
+// Invalidate drops every cached answer. It is local to the process, like
+// Results.Invalidate.
+func (c *Decisions) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+}
+
+func hash(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
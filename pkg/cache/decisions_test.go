@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mockEmbedder map[string][]float32
+
+func (m mockEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	vector, ok := m[text]
+	if !ok {
+		return nil, errors.New("rate limited")
+	}
+	return vector, nil
+}
+
+func TestDecisions(t *testing.T) {
+	embedder := mockEmbedder{
+		"Is BARCGB22 a valid swift code?":        {1, 0, 0},
+		"Is BARCGB22 a valid SWIFT code?":        {0.99, 0.1, 0},
+		"What is the IBAN of account 42?":        {0, 1, 0},
+		"Is BARCGB22 a valid swift code please?": {0.9, 0.4, 0.2},
+	}
+
+	tt := []struct {
+		description string
+		opts        []DecisionOption
+		scope       string
+		prompt      string
+		invalidate  bool
+		expectedHit bool
+	}{
+		{
+			description: "when the prompt is the same should hit",
+			scope:       "banking",
+			prompt:      "Is BARCGB22 a valid swift code?",
+			expectedHit: true,
+		},
+		{
+			description: "when the prompt only differs in whitespace should hit",
+			scope:       "banking",
+			prompt:      "  Is BARCGB22   a valid swift code?\n",
+			expectedHit: true,
+		},
+		{
+			description: "when the prompt differs should miss without similarity",
+			scope:       "banking",
+			prompt:      "Is BARCGB22 a valid SWIFT code?",
+		},
+		{
+			description: "when the scope differs should miss",
+			scope:       "support",
+			prompt:      "Is BARCGB22 a valid swift code?",
+		},
+		{
+			description: "when the cache was invalidated should miss",
+			scope:       "banking",
+			prompt:      "Is BARCGB22 a valid swift code?",
+			invalidate:  true,
+		},
+		{
+			description: "when a similar prompt was cached should hit",
+			opts:        []DecisionOption{WithSimilarity(embedder, 0.95)},
+			scope:       "banking",
+			prompt:      "Is BARCGB22 a valid SWIFT code?",
+			expectedHit: true,
+		},
+		{
+			description: "when the nearest prompt is below the threshold should miss",
+			opts:        []DecisionOption{WithSimilarity(embedder, 0.95)},
+			scope:       "banking",
+			prompt:      "Is BARCGB22 a valid swift code please?",
+		},
+		{
+			description: "when a similar prompt was cached in another scope should miss",
+			opts:        []DecisionOption{WithSimilarity(embedder, 0.95)},
+			scope:       "support",
+			prompt:      "Is BARCGB22 a valid SWIFT code?",
+		},
+		{
+			description: "when the embedder fails should miss",
+			opts:        []DecisionOption{WithSimilarity(embedder, 0.95)},
+			scope:       "banking",
+			prompt:      "Is DEUTDEFF a valid swift code?",
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			ctx := context.Background()
+			c := NewDecisions(NewLRU(10), time.Minute, test.opts...)
+
+			_, lookup, hit := c.Get(ctx, "banking", "Is BARCGB22 a valid swift code?")
+			require.False(t, hit)
+			c.Set(ctx, lookup, "Yes, it is the swift code of Barclays.")
+			_, _, hit = c.Get(ctx, "banking", "What is the IBAN of account 42?")
+			require.False(t, hit)
+
+			if test.invalidate {
+				c.Invalidate()
+			}
+
+			answer, _, hit := c.Get(ctx, test.scope, test.prompt)
+			require.Equal(t, test.expectedHit, hit)
+			if hit {
+				require.Equal(t, "Yes, it is the swift code of Barclays.", answer)
+			}
+		})
+	}
+}
+
+func TestMemoryIndex(t *testing.T) {
+	ctx := context.Background()
+	i := NewMemoryIndex(2)
+	now := time.Now()
+	i.now = func() time.Time { return now }
+
+	require.Nil(t, i.Add(ctx, "banking", "a", []float32{1, 0}, time.Minute))
+	require.Nil(t, i.Add(ctx, "banking", "b", []float32{0, 1}, time.Hour))
+
+	key, similarity, ok, err := i.Nearest(ctx, "banking", []float32{0.9, 0.1})
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Equal(t, "a", key)
+	require.InDelta(t, 0.994, similarity, 0.001)
+
+	// a expires, then b is evicted by c
+	now = now.Add(2 * time.Minute)
+	key, _, _, _ = i.Nearest(ctx, "banking", []float32{0.9, 0.1})
+	require.Equal(t, "b", key)
+
+	require.Nil(t, i.Add(ctx, "banking", "c", []float32{1, 1}, time.Hour))
+	require.Nil(t, i.Add(ctx, "banking", "d", []float32{-1, 0}, time.Hour))
+	key, _, _, _ = i.Nearest(ctx, "banking", []float32{0, 1})
+	require.Equal(t, "c", key)
+
+	_, _, ok, _ = i.Nearest(ctx, "support", []float32{0, 1})
+	require.False(t, ok)
+}
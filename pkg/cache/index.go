@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// MemoryIndex is an in-memory Index holding up to a number of vectors,
+// evicting the oldest first. Nearest compares every vector of the scope, which
+// is fast enough for thousands of them.
+type MemoryIndex struct {
+	size int
+	now  func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type vectorEntry struct {
+	scope   string
+	key     string
+	vector  []float32
+	expires time.Time
+}
+
+func NewMemoryIndex(size int) *MemoryIndex {
+	return &MemoryIndex{
+		size:    size,
+		now:     time.Now,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (i *MemoryIndex) Add(ctx context.Context, scope, key string, vector []float32, ttl time.Duration) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if el, ok := i.entries[key]; ok {
+		i.order.Remove(el)
+	}
+	i.entries[key] = i.order.PushFront(&vectorEntry{scope: scope, key: key, vector: vector, expires: i.now().Add(ttl)})
+	for i.order.Len() > i.size {
+		oldest := i.order.Back()
+		i.order.Remove(oldest)
+		delete(i.entries, oldest.Value.(*vectorEntry).key)
+	}
+
+	return nil
+}
+
+func (i *MemoryIndex) Nearest(ctx context.Context, scope string, vector []float32) (string, float64, bool, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	var nearest string
+	best, found := -1.0, false
+	now := i.now()
+	for el := i.order.Front(); el != nil; {
+		next := el.Next()
+		e := el.Value.(*vectorEntry)
+		if !now.Before(e.expires) {
+			i.order.Remove(el)
+			delete(i.entries, e.key)
+		} else if e.scope == scope {
+			if similarity := cosine(vector, e.vector); similarity > best {
+				nearest, best, found = e.key, similarity, true
+			}
+		}
+		el = next
+	}
+
+	return nearest, best, found, nil
+}
+
+// cosine returns the cosine similarity of two vectors, or 0 when they cannot
+// be compared.
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
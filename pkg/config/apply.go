@@ -0,0 +1,313 @@
+package config
+
+import (
+	"context"
+	"doppelganger"
+	"doppelganger/pkg/cache"
+	"doppelganger/pkg/datasource"
+	"doppelganger/pkg/llm"
+	"doppelganger/pkg/mcp"
+	"doppelganger/pkg/secret"
+	"doppelganger/pkg/tool"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// Sources are the data sources connected by Apply, by name.
+type Sources map[string]datasource.DataSource
+
+func (s Sources) Close(ctx context.Context) error {
+	var errs []error
+	for name, source := range s {
+		err := source.Close(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("closing datasource %q: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+type ApplyOption func(*applyOptions)
+
+type applyOptions struct {
+	secrets *secret.Store
+}
+
+// WithSecrets resolves references in connections and headers with s,
+// instead of the store described by the config.
+func WithSecrets(s *secret.Store) ApplyOption {
+	return func(o *applyOptions) {
+		o.secrets = s
+	}
+}
+
+// Apply connects every data source and registers every tool on d. On error
+// the sources connected so far are closed.
+func (c *Config) Apply(ctx context.Context, d *doppelganger.Doppelganger, opts ...ApplyOption) (Sources, error) {
+	o := &applyOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.secrets == nil {
+		o.secrets = c.SecretStore()
+	}
+
+	sources := make(Sources)
+	// Tools query the sources through their limits, when they have some
+	limited := make(map[string]datasource.DataSource)
+
+	for _, dsc := range c.DataSources {
+		source, err := c.connect(ctx, dsc, o.secrets)
+		if err != nil {
+			sources.Close(ctx)
+			return nil, err
+		}
+
+		sources[dsc.Name] = source
+		limited[dsc.Name] = source
+		if dsc.Limits != nil {
+			limited[dsc.Name] = datasource.NewLimited(source, dsc.Limits.limiter())
+		}
+	}
+
+	for _, tc := range c.Tools {
+		redactor, err := tc.Redact.redactor()
+		if err != nil {
+			sources.Close(ctx)
+			return nil, fmt.Errorf("%s:%d: tool %q redact: %w", c.file, tc.lineOf("redact"), tc.Name, err)
+		}
+
+		err = d.RegisterTool(tool.DataSourceTool{
+			Source:           limited[tc.DataSource],
+			Name:             tc.Name,
+			Description:      tc.Description,
+			Parameters:       tc.parameters(),
+			Database:         tc.Database,
+			Collection:       tc.Collection,
+			Method:           tc.Method,
+			Query:            tc.Query,
+			Projection:       tc.Projection,
+			Sort:             tc.Sort,
+			Limit:            tc.Limit,
+			Skip:             tc.Skip,
+			MaxLimit:         tc.MaxLimit,
+			MaxSkip:          tc.MaxSkip,
+			Policy:           tc.Policy.policy(),
+			RequiresApproval: tc.RequiresApproval,
+			Redact:           redactor,
+			Roles:            tc.Roles,
+			Constraints:      tc.Constraints,
+			Untrusted:        tc.Untrusted,
+			CacheTTL:         tc.CacheTTL,
+			Invalidates:      tc.Invalidates,
+			Limiter:          tc.Limits.limiter(),
+			Timeout:          tc.Timeout,
+		})
+		if err != nil {
+			sources.Close(ctx)
+			return nil, fmt.Errorf("%s:%d: tool %q: %w", c.file, tc.line, tc.Name, err)
+		}
+	}
+
+	for _, dsc := range c.DataSources {
+		if dsc.Import == nil {
+			continue
+		}
+
+		opts := []mcp.ImportOption{mcp.WithPrefix(dsc.Import.Prefix), mcp.WithSource(limited[dsc.Name])}
+		if len(dsc.Import.Tools) > 0 {
+			opts = append(opts, mcp.WithToolNames(dsc.Import.Tools...))
+		}
+		if dsc.Import.RequiresApproval {
+			opts = append(opts, mcp.WithRequiresApproval())
+		}
+		if dsc.Import.Trusted {
+			opts = append(opts, mcp.WithTrusted())
+		}
+
+		client, ok := sources[dsc.Name].(*mcp.Client)
+		if !ok {
+			sources.Close(ctx)
+			return nil, fmt.Errorf("%s:%d: datasource %q import: %T is not an mcp client", c.file, dsc.lineOf("import"), dsc.Name, sources[dsc.Name])
+		}
+
+		_, err := mcp.Import(ctx, d, client, opts...)
+		if err != nil {
+			sources.Close(ctx)
+			return nil, fmt.Errorf("%s:%d: datasource %q import: %w", c.file, dsc.lineOf("import"), dsc.Name, err)
+		}
+	}
+
+	return sources, nil
+}
+
+func (c *Config) connect(ctx context.Context, dsc DataSourceConfig, secrets *secret.Store) (datasource.DataSource, error) {
+	var newSource func() datasource.DataSource
+
+	switch dsc.Type {
+	case "mongo":
+		opts := []datasource.MongoOption{}
+		if dsc.CanonicalExtJSON {
+			opts = append(opts, datasource.WithCanonicalExtJSON())
+		}
+		if dsc.Policy != nil {
+			opts = append(opts, datasource.WithDefaultPolicy(dsc.Policy.policy()))
+		}
+		newSource = func() datasource.DataSource { return datasource.NewMongoDataSource(opts...) }
+	case "gcs":
+		newSource = func() datasource.DataSource { return datasource.NewGCS(ctx) }
+	case "mcp":
+		opts := []mcp.ClientOption{}
+		for key, value := range dsc.Headers {
+			resolved, err := secrets.Resolve(ctx, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: datasource %q header %s: %w", c.file, dsc.lineOf("headers"), dsc.Name, key, err)
+			}
+			opts = append(opts, mcp.WithClientHeader(key, resolved))
+		}
+		newSource = func() datasource.DataSource { return mcp.NewClient(opts...) }
+	case "http":
+		opts := []datasource.HTTPOption{}
+		for key, value := range dsc.Headers {
+			resolved, err := secrets.Resolve(ctx, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: datasource %q header %s: %w", c.file, dsc.lineOf("headers"), dsc.Name, key, err)
+			}
+			opts = append(opts, datasource.WithHeader(key, resolved))
+		}
+		if dsc.MaxResponseBytes > 0 {
+			opts = append(opts, datasource.WithMaxResponseBytes(dsc.MaxResponseBytes))
+		}
+		newSource = func() datasource.DataSource { return datasource.NewHTTPDataSource(opts...) }
+	default:
+		return nil, fmt.Errorf("%s:%d: datasource %q has unsupported type %q", c.file, dsc.lineOf("type"), dsc.Name, dsc.Type)
+	}
+
+	// Imported tools need the mcp client itself, so mcp connections are
+	// only resolved once
+	if c.refresh() > 0 && dsc.Type != "mcp" && secrets.IsReference(dsc.Connection) {
+		source := datasource.NewRotating(newSource, secrets.Resolve, c.refresh())
+		err := source.Connect(ctx, dsc.Connection)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: connecting datasource %q: %w", c.file, dsc.line, dsc.Name, err)
+		}
+
+		return source, nil
+	}
+
+	connection, err := secrets.Resolve(ctx, dsc.Connection)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%d: datasource %q: %w", c.file, dsc.lineOf("connection"), dsc.Name, err)
+	}
+
+	source := newSource()
+	err = source.Connect(ctx, connection)
+	if err != nil {
+		err = secret.Redact(err, connection, dsc.Connection)
+		return nil, fmt.Errorf("%s:%d: connecting datasource %q: %w", c.file, dsc.line, dsc.Name, err)
+	}
+
+	return source, nil
+}
+
+// SecretStore returns the store resolving the references in the config:
+// env://, file:// relative to the config file, and secret:// from the
+// secrets directory when one is set.
+func (c *Config) SecretStore() *secret.Store {
+	base := filepath.Dir(c.file)
+	opts := []secret.Option{secret.WithResolver("file", secret.File{Base: base})}
+	if c.Secrets != nil && c.Secrets.Dir != "" {
+		dir := c.Secrets.Dir
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(base, dir)
+		}
+		opts = append(opts, secret.WithResolver("secret", secret.Dir{Path: dir}))
+	}
+
+	return secret.NewStore(opts...)
+}
+
+// ProviderGenerator creates models with the API keys of the providers
+// section, resolved with secrets for every decision so rotated keys are
+// picked up. Providers without an entry read their environment variable.
+// Calls are retried and fall back as the models section sets.
+func (c *Config) ProviderGenerator(secrets *secret.Store) doppelganger.ProviderGeneratorFunc {
+	var opts []llm.GeneratorOption
+	if m := c.Models; m != nil {
+		retries, backoff := llm.DefaultRetries, llm.DefaultBackoff
+		if m.Retries != nil {
+			retries = *m.Retries
+		}
+		if m.Backoff > 0 {
+			backoff = m.Backoff
+		}
+		opts = append(opts, llm.WithRetries(retries, backoff))
+		for model, fallback := range m.Fallbacks {
+			opts = append(opts, llm.WithFallback(model, fallback))
+		}
+	}
+
+	return llm.NewProviderGenerator(func(provider string) (string, error) {
+		return c.apiKey(context.Background(), secrets, provider)
+	}, opts...)
+}
+
+// apiKey returns the API key of provider, or "" when it has no entry.
+func (c *Config) apiKey(ctx context.Context, secrets *secret.Store, provider string) (string, error) {
+	for _, p := range c.Providers {
+		if p.Name != provider {
+			continue
+		}
+
+		key, err := secrets.Resolve(ctx, p.APIKey)
+		if err != nil {
+			return "", fmt.Errorf("%s:%d: provider %q api key: %w", c.file, p.lineOf("apiKey"), p.Name, err)
+		}
+		return key, nil
+	}
+
+	return "", nil
+}
+
+// Decisions returns the decision cache of the decisionCache section, or nil
+// when it is missing. The similar mode embeds prompts with OpenAI, using the
+// key of its provider entry.
+func (c *Config) Decisions(ctx context.Context, secrets *secret.Store) (*cache.Decisions, error) {
+	dc := c.DecisionCache
+	if dc == nil {
+		return nil, nil
+	}
+
+	size := dc.Size
+	if size == 0 {
+		size = DefaultCacheSize
+	}
+
+	var opts []cache.DecisionOption
+	if dc.Mode == "similar" {
+		key, err := c.apiKey(ctx, secrets, llm.OpenAI)
+		if err != nil {
+			return nil, err
+		}
+
+		embedder, err := llm.NewEmbedder(dc.EmbeddingModel, key)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: decisionCache: %w", c.file, dc.lineOf("embeddingModel"), err)
+		}
+		opts = append(opts, cache.WithSimilarity(embedder, dc.Threshold), cache.WithIndex(cache.NewMemoryIndex(size)))
+	}
+
+	return cache.NewDecisions(cache.NewLRU(size), dc.TTL, opts...), nil
+}
+
+// refresh is how often secret connection strings are checked for rotation.
+func (c *Config) refresh() time.Duration {
+	if c.Secrets == nil {
+		return 0
+	}
+
+	return c.Secrets.Refresh
+}
@@ -0,0 +1,828 @@
+package config
+
+import (
+	"context"
+	"doppelganger"
+	"doppelganger/pkg/cache"
+	"doppelganger/pkg/datasource"
+	"doppelganger/pkg/guard"
+	"doppelganger/pkg/llm"
+	"doppelganger/pkg/ratelimit"
+	"doppelganger/pkg/redact"
+	"doppelganger/pkg/tool"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/xeipuuv/gojsonschema"
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes data sources and the tools built on them. It is read from
+// YAML or JSON.
+type Config struct {
+	DataSources []DataSourceConfig `yaml:"datasources"`
+	Tools       []ToolConfig       `yaml:"tools"`
+	Agents      []AgentConfig      `yaml:"agents"`
+	// Providers set the API keys of the model providers.
+	Providers []ProviderConfig `yaml:"providers"`
+	// Models set how model calls are retried and fall back.
+	Models  *ModelsConfig  `yaml:"models"`
+	Secrets *SecretsConfig `yaml:"secrets"`
+	// Guard sets the defences against prompt injection in the results of
+	// untrusted tools.
+	Guard *GuardConfig `yaml:"guard"`
+	// Cache holds the results of tools with a cacheTTL in memory.
+	Cache *CacheConfig `yaml:"cache"`
+	// DecisionCache answers prompts asked before without the model.
+	DecisionCache *DecisionCacheConfig `yaml:"decisionCache"`
+
+	file string
+}
+
+// CacheConfig sizes the in-memory result cache.
+type CacheConfig struct {
+	// Size is the number of results kept. Zero keeps DefaultCacheSize.
+	Size int `yaml:"size"`
+
+	position
+}
+
+const DefaultCacheSize = 10000
+
+// DecisionCacheConfig sets up the decision cache, in memory.
+type DecisionCacheConfig struct {
+	TTL time.Duration `yaml:"ttl"`
+	// Size is the number of answers kept. Zero keeps DefaultCacheSize.
+	Size int `yaml:"size"`
+	// Mode is exact, the default, or similar, which also answers prompts
+	// whose embedding is at least Threshold similar to a cached one.
+	Mode           string  `yaml:"mode"`
+	Threshold      float64 `yaml:"threshold"`
+	EmbeddingModel string  `yaml:"embeddingModel"`
+
+	position
+}
+
+// SecretsConfig sets where secret:// references are read from and how
+// often they are checked for rotation.
+type SecretsConfig struct {
+	// Dir holds a file per secret, named after it, relative to the config
+	// file.
+	Dir string `yaml:"dir"`
+	// Refresh reconnects data sources whose connection is a reference when
+	// its value changes. Zero resolves it once.
+	Refresh time.Duration `yaml:"refresh"`
+
+	position
+}
+
+// GuardConfig mirrors guard.Policy.
+type GuardConfig struct {
+	Wrap                bool     `yaml:"wrap"`
+	Scan                bool     `yaml:"scan"`
+	OnDetect            string   `yaml:"onDetect"`
+	Restrict            bool     `yaml:"restrict"`
+	AllowAfterUntrusted []string `yaml:"allowAfterUntrusted"`
+
+	position
+}
+
+// ModelsConfig sets how model calls failed for a passing reason, such as
+// rate limiting, are retried, and which model a call is handed to after.
+type ModelsConfig struct {
+	// Retries nil keeps llm.DefaultRetries, and 0 turns retrying off.
+	Retries *int `yaml:"retries"`
+	// Backoff is the first wait between attempts. Zero keeps
+	// llm.DefaultBackoff.
+	Backoff time.Duration `yaml:"backoff"`
+	// Fallbacks map a model to the model its calls are handed to when its
+	// retries are used up.
+	Fallbacks map[string]string `yaml:"fallbacks"`
+
+	position
+}
+
+// ProviderConfig sets the API key of a model provider, usually as a
+// reference such as secret://openai-api-key.
+type ProviderConfig struct {
+	Name   string `yaml:"name"`
+	APIKey string `yaml:"apiKey"`
+
+	position
+}
+
+type DataSourceConfig struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	// Connection is the connection string, or a reference to it such as
+	// env://MONGO_URI, file://mongo.txt or secret://mongo-prod.
+	Connection string `yaml:"connection"`
+	// Headers are sent with every request by http and mcp data sources.
+	// Values may be references.
+	Headers map[string]string `yaml:"headers"`
+	// CanonicalExtJSON makes mongo data sources return canonical Extended
+	// JSON.
+	CanonicalExtJSON bool `yaml:"canonicalExtJSON"`
+	// MaxResponseBytes is the largest response body read by http data
+	// sources. Zero uses datasource.DefaultMaxResponseBytes.
+	MaxResponseBytes int64 `yaml:"maxResponseBytes"`
+	// Policy is the default query policy of mongo data sources.
+	Policy *PolicyConfig `yaml:"policy"`
+	// Import registers the tools of mcp data sources.
+	Import *ImportConfig `yaml:"import"`
+	// Limits are shared by every tool of the data source.
+	Limits *LimitsConfig `yaml:"limits"`
+
+	position
+}
+
+// ImportConfig selects the tools imported from an MCP server.
+type ImportConfig struct {
+	// Prefix is prepended to the names of the imported tools.
+	Prefix string `yaml:"prefix"`
+	// Tools are the names of the tools to import. Empty imports all of them.
+	Tools            []string `yaml:"tools"`
+	RequiresApproval bool     `yaml:"requiresApproval"`
+	// Trusted stops marking the results of the imported tools as outside
+	// content.
+	Trusted bool `yaml:"trusted"`
+
+	position
+}
+
+type ToolConfig struct {
+	Name             string                 `yaml:"name"`
+	Description      string                 `yaml:"description"`
+	DataSource       string                 `yaml:"datasource"`
+	Parameters       map[string]interface{} `yaml:"parameters"`
+	Database         string                 `yaml:"database"`
+	Collection       string                 `yaml:"collection"`
+	Method           string                 `yaml:"method"`
+	Query            string                 `yaml:"query"`
+	Projection       string                 `yaml:"projection"`
+	Sort             string                 `yaml:"sort"`
+	Limit            string                 `yaml:"limit"`
+	Skip             string                 `yaml:"skip"`
+	MaxLimit         int64                  `yaml:"maxLimit"`
+	MaxSkip          int64                  `yaml:"maxSkip"`
+	Policy           *PolicyConfig          `yaml:"policy"`
+	RequiresApproval bool                   `yaml:"requiresApproval"`
+	Redact           *RedactConfig          `yaml:"redact"`
+	Roles            []string               `yaml:"roles"`
+	Constraints      map[string]string      `yaml:"constraints"`
+	Untrusted        bool                   `yaml:"untrusted"`
+	CacheTTL         time.Duration          `yaml:"cacheTTL"`
+	Invalidates      []string               `yaml:"invalidates"`
+	Limits           *LimitsConfig          `yaml:"limits"`
+	Timeout          time.Duration          `yaml:"timeout"`
+
+	position
+}
+
+// AgentConfig describes an agent profile: the model it uses, its system
+// instruction and the tools it may call.
+type AgentConfig struct {
+	Name   string `yaml:"name"`
+	Model  string `yaml:"model"`
+	System string `yaml:"system"`
+	// SystemFile reads the system instruction from a file, relative to the
+	// config file.
+	SystemFile string `yaml:"systemFile"`
+	// Tools are the names of the tools the agent may call. Empty allows
+	// every tool.
+	Tools []string `yaml:"tools"`
+	// AllowSystemMessages lets clients add system messages to the system
+	// instruction.
+	AllowSystemMessages bool `yaml:"allowSystemMessages"`
+
+	position
+}
+
+// PolicyConfig mirrors datasource.Policy. Omitted deniedOperators and maxTime
+// fall back to the values of datasource.DefaultPolicy.
+type PolicyConfig struct {
+	AllowedOperators []string      `yaml:"allowedOperators"`
+	DeniedOperators  []string      `yaml:"deniedOperators"`
+	MaxRegexLength   int           `yaml:"maxRegexLength"`
+	MaxTime          time.Duration `yaml:"maxTime"`
+	AllowWrites      bool          `yaml:"allowWrites"`
+
+	position
+}
+
+// LimitsConfig mirrors ratelimit.Limits.
+type LimitsConfig struct {
+	Rate        float64       `yaml:"rate"`
+	Burst       int           `yaml:"burst"`
+	MaxInFlight int           `yaml:"maxInFlight"`
+	MaxWait     time.Duration `yaml:"maxWait"`
+
+	position
+}
+
+// RedactConfig mirrors redact.Rules.
+type RedactConfig struct {
+	Detect []string `yaml:"detect"`
+	Fields []string `yaml:"fields"`
+	Action string   `yaml:"action"`
+
+	position
+}
+
+// position records where an item and each of its keys appear in the file.
+type position struct {
+	line  int
+	lines map[string]int
+}
+
+func (p position) lineOf(key string) int {
+	if line, ok := p.lines[key]; ok {
+		return line
+	}
+
+	return p.line
+}
+
+var methods = map[string][]string{
+	"mongo": {"find", "findOne", "aggregate", "countDocuments", "distinct", "insertOne", "updateOne"},
+	"gcs":   {"list", "get"},
+	"http":  {"get", "post"},
+	// The methods of mcp data sources are the tools of the server
+	"mcp": nil,
+}
+
+// Load reads and validates the config file at path.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(data, path)
+}
+
+// Parse reads and validates a config. The file name is only used in errors.
+func Parse(data []byte, file string) (*Config, error) {
+	c := &Config{file: file}
+
+	err := yaml.Unmarshal(data, c)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Config) UnmarshalYAML(n *yaml.Node) error {
+	type plain Config
+	return decode(n, (*plain)(c), nil)
+}
+
+func (d *DataSourceConfig) UnmarshalYAML(n *yaml.Node) error {
+	type plain DataSourceConfig
+	return decode(n, (*plain)(d), &d.position)
+}
+
+func (t *ToolConfig) UnmarshalYAML(n *yaml.Node) error {
+	type plain ToolConfig
+	return decode(n, (*plain)(t), &t.position)
+}
+
+func (a *AgentConfig) UnmarshalYAML(n *yaml.Node) error {
+	type plain AgentConfig
+	return decode(n, (*plain)(a), &a.position)
+}
+
+func (i *ImportConfig) UnmarshalYAML(n *yaml.Node) error {
+	type plain ImportConfig
+	return decode(n, (*plain)(i), &i.position)
+}
+
+func (p *PolicyConfig) UnmarshalYAML(n *yaml.Node) error {
+	type plain PolicyConfig
+	return decode(n, (*plain)(p), &p.position)
+}
+
+func (l *LimitsConfig) UnmarshalYAML(n *yaml.Node) error {
+	type plain LimitsConfig
+	return decode(n, (*plain)(l), &l.position)
+}
+
+func (r *RedactConfig) UnmarshalYAML(n *yaml.Node) error {
+	type plain RedactConfig
+	return decode(n, (*plain)(r), &r.position)
+}
+
+func (s *SecretsConfig) UnmarshalYAML(n *yaml.Node) error {
+	type plain SecretsConfig
+	return decode(n, (*plain)(s), &s.position)
+}
+
+func (g *GuardConfig) UnmarshalYAML(n *yaml.Node) error {
+	type plain GuardConfig
+	return decode(n, (*plain)(g), &g.position)
+}
+
+func (m *ModelsConfig) UnmarshalYAML(n *yaml.Node) error {
+	type plain ModelsConfig
+	return decode(n, (*plain)(m), &m.position)
+}
+
+func (cc *CacheConfig) UnmarshalYAML(n *yaml.Node) error {
+	type plain CacheConfig
+	return decode(n, (*plain)(cc), &cc.position)
+}
+
+func (dc *DecisionCacheConfig) UnmarshalYAML(n *yaml.Node) error {
+	type plain DecisionCacheConfig
+	return decode(n, (*plain)(dc), &dc.position)
+}
+
+func (p *ProviderConfig) UnmarshalYAML(n *yaml.Node) error {
+	type plain ProviderConfig
+	return decode(n, (*plain)(p), &p.position)
+}
+
+// decode decodes a mapping node into v, rejecting keys v does not have and
+// recording the line of every key in pos.
+func decode(n *yaml.Node, v interface{}, pos *position) error {
+	if n.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping", n.Line)
+	}
+
+	known := make(map[string]bool)
+	t := reflect.TypeOf(v).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if tag != "" && tag != "-" {
+			known[tag] = true
+		}
+	}
+
+	lines := make(map[string]int)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := n.Content[i]
+		if !known[key.Value] {
+			return fmt.Errorf("line %d: unknown field %q", key.Line, key.Value)
+		}
+		lines[key.Value] = key.Line
+	}
+
+	err := n.Decode(v)
+	if err != nil {
+		return err
+	}
+
+	if pos != nil {
+		pos.line = n.Line
+		pos.lines = lines
+	}
+
+	return nil
+}
+
+// Validate checks the whole config and reports every problem found, each
+// pointing to its line.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(line int, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s:%d: %s", c.file, line, fmt.Sprintf(format, args...)))
+	}
+
+	sources := make(map[string]DataSourceConfig)
+	for _, ds := range c.DataSources {
+		if ds.Name == "" {
+			fail(ds.line, "datasource is missing a name")
+			continue
+		}
+		if _, exists := sources[ds.Name]; exists {
+			fail(ds.lineOf("name"), "datasource %q is defined more than once", ds.Name)
+			continue
+		}
+		sources[ds.Name] = ds
+
+		if _, ok := methods[ds.Type]; !ok {
+			fail(ds.lineOf("type"), "datasource %q has unsupported type %q", ds.Name, ds.Type)
+		}
+
+		if ds.Connection == "" {
+			fail(ds.line, "datasource %q is missing a connection", ds.Name)
+		} else if err := c.checkReference(ds.Connection); err != nil {
+			fail(ds.lineOf("connection"), "datasource %q: %v", ds.Name, err)
+		}
+
+		for key, value := range ds.Headers {
+			if err := c.checkReference(value); err != nil {
+				fail(ds.lineOf("headers"), "datasource %q header %s: %v", ds.Name, key, err)
+			}
+		}
+
+		if ds.MaxResponseBytes < 0 {
+			fail(ds.lineOf("maxResponseBytes"), "datasource %q: maxResponseBytes must not be negative", ds.Name)
+		} else if ds.MaxResponseBytes > 0 && ds.Type != "http" {
+			fail(ds.lineOf("maxResponseBytes"), "datasource %q: only http datasources have a maxResponseBytes", ds.Name)
+		}
+
+		if ds.Import != nil && ds.Type != "mcp" {
+			fail(ds.lineOf("import"), "datasource %q: only mcp datasources can import tools", ds.Name)
+		}
+
+		if ds.Policy != nil && ds.Policy.AllowWrites {
+			fail(ds.Policy.lineOf("allowWrites"), "datasource %q: writes can only be allowed by a tool policy", ds.Name)
+		}
+
+		checkLimits(ds.Limits, fmt.Sprintf("datasource %q", ds.Name), fail)
+	}
+
+	tools := make(map[string]bool)
+	for _, t := range c.Tools {
+		if t.Name == "" {
+			fail(t.line, "tool is missing a name")
+			continue
+		}
+		if tools[t.Name] {
+			fail(t.lineOf("name"), "tool %q is defined more than once", t.Name)
+			continue
+		}
+		tools[t.Name] = true
+
+		if t.Description == "" {
+			fail(t.line, "tool %q is missing a description", t.Name)
+		}
+
+		ds, ok := sources[t.DataSource]
+		if !ok {
+			fail(t.lineOf("datasource"), "tool %q references unknown datasource %q", t.Name, t.DataSource)
+		} else if ds.Type == "mcp" && t.Method == "" {
+			fail(t.line, "tool %q needs the name of an mcp tool as its method", t.Name)
+		} else if ds.Type != "mcp" && !contains(methods[ds.Type], t.Method) {
+			fail(t.lineOf("method"), "tool %q uses method %q, which %s datasources do not support", t.Name, t.Method, ds.Type)
+		} else if ds.Type == "mongo" && (t.Database == "" || t.Collection == "") {
+			fail(t.line, "tool %q needs a database and collection", t.Name)
+		}
+
+		writes := ok && datasource.Writes(ds.Type, t.Method)
+		if writes && !t.RequiresApproval {
+			fail(t.lineOf("method"), "tool %q uses write method %q but does not set requiresApproval", t.Name, t.Method)
+		}
+		if writes && ds.Type == "mongo" && (t.Policy == nil || !t.Policy.AllowWrites) {
+			fail(t.lineOf("method"), "tool %q uses write method %q but its policy does not set allowWrites", t.Name, t.Method)
+		}
+
+		templates := map[string]string{
+			"query":      t.Query,
+			"projection": t.Projection,
+			"sort":       t.Sort,
+			"limit":      t.Limit,
+			"skip":       t.Skip,
+		}
+		for key, text := range templates {
+			if _, err := template.New(key).Funcs(tool.Funcs).Parse(text); err != nil {
+				fail(t.lineOf(key), "tool %q has an invalid %s template: %v", t.Name, key, err)
+			}
+		}
+
+		if err := validateSchema(t.parameters()); err != nil {
+			fail(t.lineOf("parameters"), "tool %q has invalid parameters: %v", t.Name, err)
+		}
+
+		if _, err := t.Redact.redactor(); err != nil {
+			fail(t.lineOf("redact"), "tool %q redact: %v", t.Name, err)
+		}
+
+		if len(t.Constraints) > 0 && ok && ds.Type != "mongo" {
+			fail(t.lineOf("constraints"), "tool %q: only mongo datasources support constraints", t.Name)
+		}
+
+		checkLimits(t.Limits, fmt.Sprintf("tool %q", t.Name), fail)
+
+		if t.CacheTTL < 0 {
+			fail(t.lineOf("cacheTTL"), "tool %q: cacheTTL must not be negative", t.Name)
+		} else if t.CacheTTL > 0 && writes {
+			fail(t.lineOf("cacheTTL"), "tool %q: write method %q cannot be cached", t.Name, t.Method)
+		}
+
+		if t.Timeout < 0 {
+			fail(t.lineOf("timeout"), "tool %q: timeout must not be negative", t.Name)
+		}
+	}
+
+	for _, t := range c.Tools {
+		for _, name := range t.Invalidates {
+			if !tools[name] && !c.imports(name) {
+				fail(t.lineOf("invalidates"), "tool %q invalidates unknown tool %q", t.Name, name)
+			}
+		}
+	}
+
+	providers := make(map[string]bool)
+	for _, p := range c.Providers {
+		if p.Name != llm.OpenAI && p.Name != llm.Anthropic {
+			fail(p.lineOf("name"), "provider %q is not supported", p.Name)
+			continue
+		}
+		if providers[p.Name] {
+			fail(p.lineOf("name"), "provider %q is defined more than once", p.Name)
+			continue
+		}
+		providers[p.Name] = true
+
+		if p.APIKey == "" {
+			fail(p.line, "provider %q is missing an apiKey", p.Name)
+		} else if err := c.checkReference(p.APIKey); err != nil {
+			fail(p.lineOf("apiKey"), "provider %q api key: %v", p.Name, err)
+		}
+	}
+
+	if c.Guard != nil {
+		if err := c.GuardPolicy().Validate(); err != nil {
+			fail(c.Guard.lineOf("onDetect"), "guard: %v", err)
+		}
+		for _, name := range c.Guard.AllowAfterUntrusted {
+			if !tools[name] && !c.imports(name) {
+				fail(c.Guard.lineOf("allowAfterUntrusted"), "guard allows unknown tool %q", name)
+			}
+		}
+	}
+
+	if c.Cache != nil && c.Cache.Size < 0 {
+		fail(c.Cache.lineOf("size"), "cache size must not be negative")
+	}
+
+	if dc := c.DecisionCache; dc != nil {
+		if dc.TTL <= 0 {
+			fail(dc.line, "decisionCache needs a positive ttl")
+		}
+		if dc.Size < 0 {
+			fail(dc.lineOf("size"), "decisionCache size must not be negative")
+		}
+		switch dc.Mode {
+		case "", "exact":
+		case "similar":
+			if dc.EmbeddingModel == "" {
+				fail(dc.line, "decisionCache in similar mode needs an embeddingModel")
+			}
+			if dc.Threshold <= 0 || dc.Threshold > 1 {
+				fail(dc.lineOf("threshold"), "decisionCache threshold must be between 0 and 1")
+			}
+		default:
+			fail(dc.lineOf("mode"), "decisionCache has unsupported mode %q", dc.Mode)
+		}
+	}
+
+	if m := c.Models; m != nil {
+		if m.Retries != nil && *m.Retries < 0 {
+			fail(m.lineOf("retries"), "models retries must not be negative")
+		}
+		if m.Backoff < 0 {
+			fail(m.lineOf("backoff"), "models backoff must not be negative")
+		}
+		models := make([]string, 0, len(m.Fallbacks))
+		for model := range m.Fallbacks {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+		for _, model := range models {
+			fallback := m.Fallbacks[model]
+			switch {
+			case llm.ProviderOf(model) == "":
+				fail(m.lineOf("fallbacks"), "models fallback of %q: no provider serves %q", model, model)
+			case llm.ProviderOf(fallback) == "":
+				fail(m.lineOf("fallbacks"), "models fallback of %q: no provider serves %q", model, fallback)
+			case fallback == model:
+				fail(m.lineOf("fallbacks"), "models fallback of %q must be another model", model)
+			}
+		}
+	}
+
+	if c.Secrets != nil && c.Secrets.Refresh < 0 {
+		fail(c.Secrets.lineOf("refresh"), "secrets refresh must not be negative")
+	}
+
+	agents := make(map[string]bool)
+	for _, a := range c.Agents {
+		if a.Name == "" {
+			fail(a.line, "agent is missing a name")
+			continue
+		}
+		if agents[a.Name] {
+			fail(a.lineOf("name"), "agent %q is defined more than once", a.Name)
+			continue
+		}
+		agents[a.Name] = true
+
+		if a.Model == "" {
+			fail(a.line, "agent %q is missing a model", a.Name)
+		}
+
+		if a.System != "" && a.SystemFile != "" {
+			fail(a.lineOf("systemFile"), "agent %q sets both system and systemFile", a.Name)
+		}
+
+		for _, name := range a.Tools {
+			if !tools[name] && !c.imports(name) {
+				fail(a.lineOf("tools"), "agent %q references unknown tool %q", a.Name, name)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// imports tells whether a tool with the given name may be imported from an
+// MCP server, whose tools are only known once connected.
+func (c *Config) imports(name string) bool {
+	for _, ds := range c.DataSources {
+		if ds.Import == nil || !strings.HasPrefix(name, ds.Import.Prefix) {
+			continue
+		}
+		if len(ds.Import.Tools) == 0 || contains(ds.Import.Tools, strings.TrimPrefix(name, ds.Import.Prefix)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Profiles returns the agent profiles, reading system instructions from
+// their files.
+func (c *Config) Profiles() ([]doppelganger.Profile, error) {
+	profiles := make([]doppelganger.Profile, 0, len(c.Agents))
+	for _, a := range c.Agents {
+		system := a.System
+		if a.SystemFile != "" {
+			path := a.SystemFile
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(filepath.Dir(c.file), path)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: agent %q: %w", c.file, a.lineOf("systemFile"), a.Name, err)
+			}
+			system = string(data)
+		}
+
+		profiles = append(profiles, doppelganger.Profile{
+			Name:                a.Name,
+			Model:               a.Model,
+			SystemInstruction:   system,
+			Tools:               a.Tools,
+			AllowSystemMessages: a.AllowSystemMessages,
+		})
+	}
+
+	return profiles, nil
+}
+
+// GuardPolicy returns the defences of the guard section, none when it is
+// missing.
+func (c *Config) GuardPolicy() guard.Policy {
+	if c.Guard == nil {
+		return guard.Policy{}
+	}
+
+	return guard.Policy{
+		Wrap:                c.Guard.Wrap,
+		Scan:                c.Guard.Scan,
+		OnDetect:            guard.Action(c.Guard.OnDetect),
+		Restrict:            c.Guard.Restrict,
+		AllowAfterUntrusted: c.Guard.AllowAfterUntrusted,
+	}
+}
+
+// ResultCache returns an in-memory cache sized by the cache section, or nil
+// when it is missing.
+func (c *Config) ResultCache() *cache.Results {
+	if c.Cache == nil {
+		return nil
+	}
+
+	size := c.Cache.Size
+	if size == 0 {
+		size = DefaultCacheSize
+	}
+
+	return cache.New(cache.NewLRU(size))
+}
+
+func (t ToolConfig) parameters() map[string]interface{} {
+	if t.Parameters == nil {
+		return map[string]interface{}{}
+	}
+
+	return t.Parameters
+}
+
+// checkLimits reports the problems of the limits of owner.
+func checkLimits(l *LimitsConfig, owner string, fail func(line int, format string, args ...interface{})) {
+	if l == nil {
+		return
+	}
+
+	values := map[string]float64{"rate": l.Rate, "burst": float64(l.Burst), "maxInFlight": float64(l.MaxInFlight), "maxWait": float64(l.MaxWait)}
+	for key, value := range values {
+		if value < 0 {
+			fail(l.lineOf(key), "%s limits: %s must not be negative", owner, key)
+		}
+	}
+	if l.Burst > 0 && l.Rate == 0 {
+		fail(l.lineOf("burst"), "%s limits: burst needs a rate", owner)
+	}
+}
+
+// limiter returns the limiter of the limits, or nil when there are none.
+func (l *LimitsConfig) limiter() *ratelimit.Limiter {
+	if l == nil {
+		return nil
+	}
+
+	return ratelimit.New(ratelimit.Limits{
+		Rate:        l.Rate,
+		Burst:       l.Burst,
+		MaxInFlight: l.MaxInFlight,
+		MaxWait:     l.MaxWait,
+	})
+}
+
+func (p *PolicyConfig) policy() *datasource.Policy {
+	if p == nil {
+		return nil
+	}
+
+	defaults := datasource.DefaultPolicy()
+	policy := &datasource.Policy{
+		AllowedOperators: p.AllowedOperators,
+		DeniedOperators:  p.DeniedOperators,
+		MaxRegexLength:   p.MaxRegexLength,
+		MaxTime:          p.MaxTime,
+		AllowWrites:      p.AllowWrites,
+	}
+	if policy.DeniedOperators == nil {
+		policy.DeniedOperators = defaults.DeniedOperators
+	}
+	if policy.MaxTime == 0 {
+		policy.MaxTime = defaults.MaxTime
+	}
+
+	return policy
+}
+
+func (r *RedactConfig) redactor() (*redact.Redactor, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	rules := redact.Rules{
+		Fields: r.Fields,
+		Action: redact.Action(r.Action),
+	}
+	for _, kind := range r.Detect {
+		rules.Detect = append(rules.Detect, redact.Kind(kind))
+	}
+
+	return redact.New(rules)
+}
+
+func validateSchema(schema map[string]interface{}) error {
+	sl := gojsonschema.NewSchemaLoader()
+	sl.Validate = true
+	sl.Draft = gojsonschema.Draft7
+	sl.AutoDetect = false
+
+	return sl.AddSchemas(gojsonschema.NewGoLoader(schema))
+}
+
+// checkReference resolves env:// and file:// references, so that missing
+// ones are reported with the rest of the config. Other backends are only
+// reached by Apply.
+func (c *Config) checkReference(value string) error {
+	if !strings.HasPrefix(value, "env://") && !strings.HasPrefix(value, "file://") {
+		return nil
+	}
+
+	_, err := c.SecretStore().Resolve(context.Background(), value)
+	return err
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
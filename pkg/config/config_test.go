@@ -0,0 +1,516 @@
+package config
+
+import (
+	"doppelganger"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const validConfig = `
+datasources:
+  - name: bank
+    type: mongo
+    connection: env://TEST_MONGO_URI
+  - name: policies
+    type: gcs
+    connection: policy-bucket
+
+tools:
+  - name: validate_swift_code
+    description: Validates whether a swift code is valid
+    datasource: bank
+    database: my_database
+    collection: swift_codes
+    method: findOne
+    query: '{ "swift_code": "{{ .code }}" }'
+    parameters:
+      type: object
+      properties:
+        code:
+          type: string
+  - name: list_policies
+    description: List banking policies
+    datasource: policies
+    method: list
+`
+
+func TestParse(t *testing.T) {
+	tt := []struct {
+		description   string
+		config        string
+		expectedError []string
+	}{
+		{
+			description: "When the config is valid, it is parsed without error",
+			config:      validConfig,
+		},
+		{
+			description: "When the config is JSON, it is parsed without error",
+			config: `{
+	"datasources": [ { "name": "cases", "type": "http", "connection": "https://cases.internal" } ],
+	"tools": [ { "name": "get_case", "description": "Gets a case", "datasource": "cases", "method": "get", "query": "id={{ .id }}", "limit": 5 } ]
+}`,
+		},
+		{
+			description: "When a tool references an unknown datasource, the error points to its line",
+			config: `datasources:
+  - name: bank
+    type: mongo
+    connection: mongodb://localhost:27017
+tools:
+  - name: validate_swift_code
+    description: Validates whether a swift code is valid
+    datasource: banks
+    database: my_database
+    collection: swift_codes
+    method: findOne
+`,
+			expectedError: []string{"config.yaml:8: tool \"validate_swift_code\" references unknown datasource \"banks\""},
+		},
+		{
+			description: "When several problems exist, all of them are reported",
+			config: `datasources:
+  - name: bank
+    type: postgres
+    connection: env://TEST_UNSET_VARIABLE
+tools:
+  - name: list_policies
+    datasource: bank
+    method: list
+    query: "{{ .name }"
+`,
+			expectedError: []string{
+				"config.yaml:3: datasource \"bank\" has unsupported type \"postgres\"",
+				"config.yaml:4: datasource \"bank\": environment variable TEST_UNSET_VARIABLE is not set",
+				"config.yaml:6: tool \"list_policies\" is missing a description",
+				"config.yaml:9: tool \"list_policies\" has an invalid query template",
+			},
+		},
+		{
+			description: "When a tool uses a method its datasource does not support, the error points to the method",
+			config: `datasources:
+  - name: policies
+    type: gcs
+    connection: policy-bucket
+tools:
+  - name: find_policies
+    description: Finds policies
+    datasource: policies
+    method: find
+`,
+			expectedError: []string{"config.yaml:9: tool \"find_policies\" uses method \"find\", which gcs datasources do not support"},
+		},
+		{
+			description: "When a tool uses a write method without allowing writes, an error is returned",
+			config: `datasources:
+  - name: bank
+    type: mongo
+    connection: mongodb://localhost:27017
+tools:
+  - name: flag_account
+    description: Flags an account
+    datasource: bank
+    database: bank
+    collection: accounts
+    method: updateOne
+    policy:
+      maxTime: 2s
+`,
+			expectedError: []string{
+				"config.yaml:11: tool \"flag_account\" uses write method \"updateOne\" but does not set requiresApproval",
+				"config.yaml:11: tool \"flag_account\" uses write method \"updateOne\" but its policy does not set allowWrites",
+			},
+		},
+		{
+			description: "When a tool posts without requiring approval, an error is returned",
+			config: `datasources:
+  - name: cases
+    type: http
+    connection: https://cases.internal/api
+tools:
+  - name: create_case
+    description: Creates a case
+    datasource: cases
+    collection: cases
+    method: post
+`,
+			expectedError: []string{"config.yaml:10: tool \"create_case\" uses write method \"post\" but does not set requiresApproval"},
+		},
+		{
+			description: "When a field is misspelt, the error points to it",
+			config: `datasources:
+  - name: bank
+    type: mongo
+    conection: mongodb://localhost:27017
+`,
+			expectedError: []string{"line 4: unknown field \"conection\""},
+		},
+		{
+			description: "When the parameters are not a valid schema, an error is returned",
+			config: `datasources:
+  - name: policies
+    type: gcs
+    connection: policy-bucket
+tools:
+  - name: get_policy
+    description: Gets a policy
+    datasource: policies
+    method: get
+    parameters:
+      type: object
+      properties:
+        name: ""
+`,
+			expectedError: []string{"config.yaml:10: tool \"get_policy\" has invalid parameters"},
+		},
+		{
+			description: "When an agent references an unknown tool or has no model, every error is reported",
+			config: validConfig + `
+agents:
+  - name: support
+    tools: [validate_swift_code, delete_account]
+`,
+			expectedError: []string{
+				"config.yaml:29: agent \"support\" is missing a model",
+				"config.yaml:30: agent \"support\" references unknown tool \"delete_account\"",
+			},
+		},
+		{
+			description: "When an agent sets both system and systemFile, an error is returned",
+			config: validConfig + `
+agents:
+  - name: support
+    model: gpt-4.1
+    system: You are a support agent
+    systemFile: support.txt
+`,
+			expectedError: []string{"config.yaml:32: agent \"support\" sets both system and systemFile"},
+		},
+		{
+			description: "When an agent uses tools imported from an MCP server, it is parsed without error",
+			config: `datasources:
+  - name: crm
+    type: mcp
+    connection: https://crm.internal/mcp
+    import:
+      prefix: crm_
+tools:
+  - name: crm_get_owner
+    description: Gets the owner of an account
+    datasource: crm
+    method: get_owner
+    query: '{{ json . }}'
+agents:
+  - name: support
+    model: gpt-4.1
+    tools: [crm_get_owner, crm_search_accounts]
+`,
+		},
+		{
+			description: "When a non mcp datasource imports tools, an error is returned",
+			config: `datasources:
+  - name: cases
+    type: http
+    connection: https://cases.internal
+    import:
+      prefix: cases_
+`,
+			expectedError: []string{"config.yaml:5: datasource \"cases\": only mcp datasources can import tools"},
+		},
+		{
+			description: "When a datasource has an invalid maxResponseBytes, an error is returned",
+			config: `datasources:
+  - name: cases
+    type: http
+    connection: https://cases.internal
+    maxResponseBytes: -1
+  - name: files
+    type: gcs
+    connection: policies
+    maxResponseBytes: 1024
+`,
+			expectedError: []string{
+				"config.yaml:5: datasource \"cases\": maxResponseBytes must not be negative",
+				"config.yaml:9: datasource \"files\": only http datasources have a maxResponseBytes",
+			},
+		},
+		{
+			description: "When a tool redacts personal data, it is parsed without error",
+			config: validConfig + `    redact:
+      detect: [iban, card, email, phone]
+      fields: [customer.name]
+      action: tokenise
+`,
+		},
+		{
+			description: "When a tool redacts an unsupported kind, the error points to the redact section",
+			config: validConfig + `    redact:
+      detect: [passport]
+`,
+			expectedError: []string{"config.yaml:27: tool \"list_policies\" redact: unsupported kind \"passport\""},
+		},
+		{
+			description: "When a tool requires roles and constrains its queries, it is parsed without error",
+			config: validConfig + `  - name: list_accounts
+    description: Lists the accounts of a branch
+    datasource: bank
+    database: bank
+    collection: accounts
+    method: find
+    roles: [teller, auditor]
+    constraints:
+      branch_id: branch
+`,
+		},
+		{
+			description: "When a tool on a datasource other than mongo sets constraints, an error is returned",
+			config: validConfig + `    constraints:
+      branch_id: branch
+`,
+			expectedError: []string{"tool \"list_policies\": only mongo datasources support constraints"},
+		},
+		{
+			description: "When provider keys and the secrets directory are set, it is parsed without error",
+			config: validConfig + `
+secrets:
+  dir: /run/secrets
+  refresh: 5m
+providers:
+  - name: openai
+    apiKey: secret://openai-api-key
+  - name: anthropic
+    apiKey: env://TEST_MONGO_URI
+`,
+		},
+		{
+			description: "When a provider is unsupported or its key cannot be resolved, every error is reported",
+			config: validConfig + `
+providers:
+  - name: gemini
+    apiKey: secret://gemini
+  - name: openai
+    apiKey: env://TEST_UNSET_VARIABLE
+`,
+			expectedError: []string{
+				"config.yaml:29: provider \"gemini\" is not supported",
+				"config.yaml:32: provider \"openai\" api key: environment variable TEST_UNSET_VARIABLE is not set",
+			},
+		},
+		{
+			description: "When model calls are retried and fall back, it is parsed without error",
+			config: validConfig + `
+models:
+  retries: 3
+  backoff: 1s
+  fallbacks:
+    gpt-4.1: claude-sonnet-4-0
+`,
+		},
+		{
+			description: "When the retries are negative or a model falls back to itself, every error is reported",
+			config: validConfig + `
+models:
+  retries: -1
+  fallbacks:
+    gpt-4.1: gpt-4.1
+    gpt-4.1-mini: gemini-2.5-pro
+`,
+			expectedError: []string{
+				"config.yaml:29: models retries must not be negative",
+				"config.yaml:30: models fallback of \"gpt-4.1\" must be another model",
+				"config.yaml:30: models fallback of \"gpt-4.1-mini\": no provider serves \"gemini-2.5-pro\"",
+			},
+		},
+		{
+			description: "When untrusted tools are guarded, it is parsed without error",
+			config: validConfig + `    untrusted: true
+guard:
+  wrap: true
+  scan: true
+  onDetect: withhold
+  restrict: true
+  allowAfterUntrusted: [list_policies]
+`,
+		},
+		{
+			description: "When the guard has an unknown action or allows an unknown tool, every error is reported",
+			config: validConfig + `guard:
+  onDetect: ignore
+  restrict: true
+  allowAfterUntrusted: [send_payment]
+`,
+			expectedError: []string{
+				"guard: unsupported action \"ignore\"",
+				"guard allows unknown tool \"send_payment\"",
+			},
+		},
+		{
+			description: "When tools are cached and invalidated, it is parsed without error",
+			config: validConfig + `    cacheTTL: 10m
+    invalidates: [validate_swift_code]
+cache:
+  size: 500
+`,
+		},
+		{
+			description: "When a tool has a timeout, it is parsed without error",
+			config: validConfig + `    timeout: 2s
+`,
+		},
+		{
+			description: "When a timeout is negative, an error is reported",
+			config: validConfig + `    timeout: -2s
+`,
+			expectedError: []string{
+				"config.yaml:27: tool \"list_policies\": timeout must not be negative",
+			},
+		},
+		{
+			description: "When a cacheTTL is negative or a tool invalidates an unknown tool, every error is reported",
+			config: validConfig + `    cacheTTL: -1m
+    invalidates: [get_account]
+cache:
+  size: -1
+`,
+			expectedError: []string{
+				"config.yaml:27: tool \"list_policies\": cacheTTL must not be negative",
+				"config.yaml:28: tool \"list_policies\" invalidates unknown tool \"get_account\"",
+				"config.yaml:30: cache size must not be negative",
+			},
+		},
+		{
+			description: "When decisions are cached by similarity, it is parsed without error",
+			config: validConfig + `decisionCache:
+  ttl: 1h
+  mode: similar
+  threshold: 0.95
+  embeddingModel: text-embedding-3-small
+`,
+		},
+		{
+			description: "When the decision cache has no ttl or an unsupported mode, every error is reported",
+			config: validConfig + `decisionCache:
+  mode: fuzzy
+`,
+			expectedError: []string{
+				"config.yaml:28: decisionCache needs a positive ttl",
+				"config.yaml:28: decisionCache has unsupported mode \"fuzzy\"",
+			},
+		},
+		{
+			description: "When the decision cache is similar without a model or threshold, every error is reported",
+			config: validConfig + `decisionCache:
+  ttl: 1h
+  mode: similar
+  threshold: 1.5
+`,
+			expectedError: []string{
+				"decisionCache in similar mode needs an embeddingModel",
+				"config.yaml:30: decisionCache threshold must be between 0 and 1",
+			},
+		},
+		{
+			description: "When a tool sets limits, it is parsed without error",
+			config: validConfig + `    limits:
+      rate: 50
+      burst: 10
+      maxInFlight: 5
+      maxWait: 2s
+`,
+		},
+		{
+			description: "When limits are negative or set a burst without a rate, every error is reported",
+			config: validConfig + `    limits:
+      burst: 10
+      maxInFlight: -1
+`,
+			expectedError: []string{
+				"config.yaml:28: tool \"list_policies\" limits: burst needs a rate",
+				"config.yaml:29: tool \"list_policies\" limits: maxInFlight must not be negative",
+			},
+		},
+		{
+			description:   "When the file is not valid YAML, an error is returned",
+			config:        "datasources: [",
+			expectedError: []string{"config.yaml"},
+		},
+	}
+
+	t.Setenv("TEST_MONGO_URI", "mongodb://localhost:27017")
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			c, err := Parse([]byte(test.config), "config.yaml")
+			if len(test.expectedError) > 0 {
+				require.NotNil(t, err)
+				for _, expected := range test.expectedError {
+					require.Contains(t, err.Error(), expected)
+				}
+				return
+			}
+
+			require.Nil(t, err)
+			require.NotNil(t, c)
+		})
+	}
+}
+
+func TestPolicyConfig(t *testing.T) {
+	c, err := Parse([]byte(`datasources:
+  - name: bank
+    type: mongo
+    connection: mongodb://localhost:27017
+tools:
+  - name: flag_account
+    description: Flags an account
+    datasource: bank
+    database: bank
+    collection: accounts
+    method: updateOne
+    requiresApproval: true
+    policy:
+      allowedOperators: [$set]
+      allowWrites: true
+`), "config.yaml")
+	require.Nil(t, err)
+
+	policy := c.Tools[0].Policy.policy()
+	require.Equal(t, []string{"$set"}, policy.AllowedOperators)
+	require.True(t, policy.AllowWrites)
+	require.NotEmpty(t, policy.DeniedOperators)
+	require.NotZero(t, policy.MaxTime)
+}
+
+func TestProfiles(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "analyst.txt"), []byte("You are an analyst"), 0o600)
+	require.Nil(t, err)
+
+	t.Setenv("TEST_MONGO_URI", "mongodb://localhost:27017")
+	c, err := Parse([]byte(validConfig+`
+agents:
+  - name: support
+    model: claude-sonnet-4-0
+    system: You are a support agent
+    tools: [validate_swift_code]
+    allowSystemMessages: true
+  - name: analyst
+    model: gpt-4.1
+    systemFile: analyst.txt
+`), filepath.Join(dir, "config.yaml"))
+	require.Nil(t, err)
+
+	profiles, err := c.Profiles()
+	require.Nil(t, err)
+	require.Equal(t, []doppelganger.Profile{
+		{Name: "support", Model: "claude-sonnet-4-0", SystemInstruction: "You are a support agent", Tools: []string{"validate_swift_code"}, AllowSystemMessages: true},
+		{Name: "analyst", Model: "gpt-4.1", SystemInstruction: "You are an analyst"},
+	}, profiles)
+
+	c.Agents[1].SystemFile = "missing.txt"
+	_, err = c.Profiles()
+	require.NotNil(t, err)
+}
@@ -1,10 +1,108 @@
 package datasource
 
-import "context"
+import (
+	"context"
+	"errors"
+	"net/url"
+)
+
+// ErrConstraintsNotSupported is returned by data sources that cannot enforce
+// query constraints, so that a constrained query never runs unconstrained.
+var ErrConstraintsNotSupported = errors.New("query constraints are not supported by this data source")
+
+// WriteMethods are the methods that change data, by data source type. Tools
+// using them must require approval.
+var WriteMethods = map[string][]string{
+	"mongo": {"insertOne", "updateOne"},
+	"http":  {"post"},
+}
+
+// Writes tells whether method changes data on a data source of sourceType.
+func Writes(sourceType, method string) bool {
+	for _, m := range WriteMethods[sourceType] {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ArgumentEscapers escape the string arguments rendered into the queries of
+// a method, by data source type, so that an argument cannot change the query
+// around it, such as adding parameters to a URL query string.
+var ArgumentEscapers = map[string]map[string]func(string) string{
+	"http": {"get": url.QueryEscape},
+}
 
 type DataSource interface {
 	Connect(ctx context.Context, connectionString string) error
 	Close(ctx context.Context) error
-	Query(ctx context.Context, database, method, collection, query string) ([]string, error)
+	Query(ctx context.Context, database, method, collection, query string, opts ...QueryOption) ([]string, error)
 	Type() string
 }
+
+// QueryOptions holds the optional settings a tool can pass along with a query.
+// Data sources ignore the settings that do not apply to them.
+type QueryOptions struct {
+	Projection string
+	Sort       string
+	Limit      int64
+	Skip       int64
+	Policy     *Policy
+	// Constraints are field values the results must have, whatever the
+	// query asks for. Unlike the other settings they may not be ignored.
+	Constraints map[string]interface{}
+}
+
+type QueryOption func(*QueryOptions)
+
+func NewQueryOptions(opts ...QueryOption) *QueryOptions {
+	qo := &QueryOptions{}
+	for _, opt := range opts {
+		opt(qo)
+	}
+
+	return qo
+}
+
+// WithProjection sets the JSON document selecting the fields to return.
+func WithProjection(projection string) QueryOption {
+	return func(qo *QueryOptions) {
+		qo.Projection = projection
+	}
+}
+
+// WithSort sets the JSON document describing the sort order.
+func WithSort(sort string) QueryOption {
+	return func(qo *QueryOptions) {
+		qo.Sort = sort
+	}
+}
+
+func WithLimit(limit int64) QueryOption {
+	return func(qo *QueryOptions) {
+		qo.Limit = limit
+	}
+}
+
+func WithSkip(skip int64) QueryOption {
+	return func(qo *QueryOptions) {
+		qo.Skip = skip
+	}
+}
+
+// WithPolicy overrides the data source's default policy for this query.
+func WithPolicy(p *Policy) QueryOption {
+	return func(qo *QueryOptions) {
+		qo.Policy = p
+	}
+}
+
+// WithConstraints restricts the query to documents whose fields have the
+// given values, such as the branch of the caller.
+func WithConstraints(c map[string]interface{}) QueryOption {
+	return func(qo *QueryOptions) {
+		qo.Constraints = c
+	}
+}
@@ -2,19 +2,64 @@ package datasource
 
 import (
 	"context"
+	"doppelganger/pkg/extract"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
 )
 
 type GCS struct {
-	bucket *storage.BucketHandle
-	client *storage.Client
+	bucket     *storage.BucketHandle
+	client     *storage.Client
+	extractors *extract.Registry
 }
 
-func NewGCS(ctx context.Context) *GCS {
-	return &GCS{}
+type GCSOption func(*GCS)
+
+// WithExtractors sets the registry used to turn objects returned by get into
+// text. By default extract.Default is used.
+func WithExtractors(r *extract.Registry) GCSOption {
+	return func(g *GCS) {
+		g.extractors = r
+	}
+}
+
+// gcsListQuery is the JSON shape accepted by the list method. An empty query
+// lists the whole bucket.
+type gcsListQuery struct {
+	Prefix     string `json:"prefix"`
+	Delimiter  string `json:"delimiter"`
+	Glob       string `json:"glob"`
+	MaxResults int    `json:"maxResults"`
+}
+
+// gcsObject is the record returned for every object, or common prefix when a
+// delimiter is used, matched by list.
+type gcsObject struct {
+	Name        string            `json:"name,omitempty"`
+	Prefix      string            `json:"prefix,omitempty"`
+	Size        int64             `json:"size"`
+	Updated     *time.Time        `json:"updated,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+var gcsListAttrs = []string{"Name", "Size", "Updated", "ContentType", "Metadata"}
+
+func NewGCS(ctx context.Context, opts ...GCSOption) *GCS {
+	g := &GCS{
+		extractors: extract.Default(),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	return g
 }
 
 func (g *GCS) Connect(ctx context.Context, bucketName string) error {
@@ -32,6 +77,10 @@ func (g *GCS) Connect(ctx context.Context, bucketName string) error {
 }
 
 func (g *GCS) Close(ctx context.Context) error {
+	if g.client == nil {
+		return nil
+	}
+
 	err := g.client.Close()
 	return err
 }
@@ -40,21 +89,14 @@ func (g *GCS) Type() string {
 	return "gcs"
 }
 
-func (g *GCS) Query(ctx context.Context, database, method, collection, query string) ([]string, error) {
+func (g *GCS) Query(ctx context.Context, database, method, collection, query string, opts ...QueryOption) ([]string, error) {
+	if len(NewQueryOptions(opts...).Constraints) > 0 {
+		return nil, ErrConstraintsNotSupported
+	}
+
 	switch method {
 	case "list":
-		objectNames := []string{}
-		iterator := g.bucket.Objects(ctx, nil)
-		for {
-			attr, err := iterator.Next()
-			if err != nil {
-				break
-			}
-
-			objectNames = append(objectNames, attr.Name)
-		}
-
-		return objectNames, nil
+		return g.list(ctx, query)
 	case "get":
 		objectHandle := g.bucket.Object(query)
 		reader, err := objectHandle.NewReader(ctx)
@@ -68,9 +110,111 @@ func (g *GCS) Query(ctx context.Context, database, method, collection, query str
 			return nil, err
 		}
 
-		return []string{string(objectBytes)}, nil
+		text, err := g.extract(ctx, reader.Attrs.ContentType, query, objectBytes)
+		if err != nil {
+			return nil, fmt.Errorf("extracting %s: %w", query, err)
+		}
+
+		return []string{text}, nil
 
 	}
 
 	return nil, fmt.Errorf("method not supported")
 }
+
+// extract turns an object into text. Extractors do not take a context and
+// parsing a large PDF can outlast the tool timeout, so extraction runs in its
+// own goroutine and is abandoned when ctx is done.
+func (g *GCS) extract(ctx context.Context, contentType, name string, data []byte) (string, error) {
+	type extracted struct {
+		text string
+		err  error
+	}
+
+	done := make(chan extracted, 1)
+	go func() {
+		text, err := g.extractors.Extract(contentType, name, data)
+		done <- extracted{text: text, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.text, res.err
+	case <-ctx.Done():
+		return "", context.Cause(ctx)
+	}
+}
+
+func (g *GCS) list(ctx context.Context, query string) ([]string, error) {
+	q, maxResults, err := parseListQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []string{}
+	it := g.bucket.Objects(ctx, q)
+	for maxResults == 0 || len(objects) < maxResults {
+		attr, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		objBytes, err := json.Marshal(newGCSObject(attr))
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, string(objBytes))
+	}
+
+	return objects, nil
+}
+
+func parseListQuery(query string) (*storage.Query, int, error) {
+	var lq gcsListQuery
+	if strings.TrimSpace(query) != "" {
+		err := json.Unmarshal([]byte(query), &lq)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid list query: %w", err)
+		}
+	}
+
+	if lq.MaxResults < 0 {
+		return nil, 0, fmt.Errorf("invalid list query: maxResults must not be negative")
+	}
+
+	q := &storage.Query{
+		Prefix:    lq.Prefix,
+		Delimiter: lq.Delimiter,
+		MatchGlob: lq.Glob,
+	}
+	err := q.SetAttrSelection(gcsListAttrs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return q, lq.MaxResults, nil
+}
+
+func newGCSObject(attr *storage.ObjectAttrs) gcsObject {
+	// Synthetic directory entries only carry a prefix
+	if attr.Name == "" {
+		return gcsObject{Prefix: attr.Prefix}
+	}
+
+	obj := gcsObject{
+		Name:        attr.Name,
+		Size:        attr.Size,
+		ContentType: attr.ContentType,
+		Metadata:    attr.Metadata,
+	}
+	if !attr.Updated.IsZero() {
+		updated := attr.Updated
+		obj.Updated = &updated
+	}
+
+	return obj
+}
@@ -0,0 +1,273 @@
+package datasource
+
+import (
+	"context"
+	"doppelganger/pkg/extract"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseListQuery(t *testing.T) {
+	tt := []struct {
+		description        string
+		query              string
+		expectError        bool
+		expectedPrefix     string
+		expectedDelimiter  string
+		expectedGlob       string
+		expectedMaxResults int
+	}{
+		{
+			description: "When the query is empty, the whole bucket is listed without limits",
+			query:       "",
+			expectError: false,
+		},
+		{
+			description:        "When prefix, delimiter, glob and maxResults are passed, they are carried on the storage query",
+			query:              "{ \"prefix\": \"policies/\", \"delimiter\": \"/\", \"glob\": \"**.pdf\", \"maxResults\": 10 }",
+			expectError:        false,
+			expectedPrefix:     "policies/",
+			expectedDelimiter:  "/",
+			expectedGlob:       "**.pdf",
+			expectedMaxResults: 10,
+		},
+		{
+			description: "When the query is not valid JSON, an error is returned",
+			query:       "{ \"prefix: \"policies/\" }",
+			expectError: true,
+		},
+		{
+			description: "When maxResults is negative, an error is returned",
+			query:       "{ \"maxResults\": -1 }",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			q, maxResults, err := parseListQuery(test.query)
+			if test.expectError {
+				require.NotNil(t, err)
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, test.expectedPrefix, q.Prefix)
+			require.Equal(t, test.expectedDelimiter, q.Delimiter)
+			require.Equal(t, test.expectedGlob, q.MatchGlob)
+			require.Equal(t, test.expectedMaxResults, maxResults)
+		})
+	}
+}
+
+func TestNewGCSObject(t *testing.T) {
+	updated := time.Date(2025, time.March, 1, 10, 0, 0, 0, time.UTC)
+
+	tt := []struct {
+		description    string
+		attr           *storage.ObjectAttrs
+		expectedRecord string
+	}{
+		{
+			description: "When an object is listed, its metadata is returned",
+			attr: &storage.ObjectAttrs{
+				Name:        "policies/deposits.pdf",
+				Size:        2048,
+				Updated:     updated,
+				ContentType: "application/pdf",
+				Metadata:    map[string]string{"owner": "compliance"},
+			},
+			expectedRecord: "{\"name\":\"policies/deposits.pdf\",\"size\":2048,\"updated\":\"2025-03-01T10:00:00Z\",\"contentType\":\"application/pdf\",\"metadata\":{\"owner\":\"compliance\"}}",
+		},
+		{
+			description: "When an empty object is listed, its size is returned",
+			attr: &storage.ObjectAttrs{
+				Name:        "policies/draft.txt",
+				ContentType: "text/plain",
+			},
+			expectedRecord: "{\"name\":\"policies/draft.txt\",\"size\":0,\"contentType\":\"text/plain\"}",
+		},
+		{
+			description: "When a common prefix is listed, only the prefix is returned",
+			attr: &storage.ObjectAttrs{
+				Prefix: "policies/",
+			},
+			expectedRecord: "{\"prefix\":\"policies/\",\"size\":0}",
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			record, err := json.Marshal(newGCSObject(test.attr))
+			require.Nil(t, err)
+			require.Equal(t, test.expectedRecord, string(record))
+		})
+	}
+}
+
+func TestGCSExtract(t *testing.T) {
+	errDeadline := errors.New("deadline")
+	release := make(chan struct{})
+	defer close(release)
+
+	extractors := extract.NewRegistry()
+	extractors.RegisterExtension(".txt", extract.ExtractorFunc(func(data []byte) (string, error) {
+		return string(data), nil
+	}))
+	extractors.RegisterExtension(".pdf", extract.ExtractorFunc(func(data []byte) (string, error) {
+		<-release
+		return string(data), nil
+	}))
+	g := NewGCS(context.Background(), WithExtractors(extractors))
+
+	tt := []struct {
+		description   string
+		name          string
+		timeout       time.Duration
+		expectedText  string
+		expectedError error
+	}{
+		{
+			description:  "When extraction finishes in time, the text is returned",
+			name:         "notes.txt",
+			timeout:      time.Second,
+			expectedText: "content",
+		},
+		{
+			description:   "When extraction outlasts the context, the cause of the cancellation is returned",
+			name:          "large.pdf",
+			timeout:       10 * time.Millisecond,
+			expectedError: errDeadline,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			ctx, cancel := context.WithTimeoutCause(context.Background(), test.timeout, errDeadline)
+			defer cancel()
+
+			text, err := g.extract(ctx, "", test.name, []byte("content"))
+			require.Equal(t, test.expectedError, err)
+			require.Equal(t, test.expectedText, text)
+		})
+	}
+}
+
+func TestGCSQuery(t *testing.T) {
+	objects := map[string]string{
+		"policies/deposits.txt": "Deposits are insured up to 100000 EUR",
+		"policies/draft.txt":    "",
+		"notes/branch.txt":      "Opening hours",
+	}
+
+	// A fake of the JSON API for listing and of the XML API for reading, as
+	// reached by the client through STORAGE_EMULATOR_HOST
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/storage/v1/b/bank-docs/o" {
+			names := []string{}
+			for name := range objects {
+				if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+
+			var items []string
+			for _, name := range names {
+				items = append(items, fmt.Sprintf(`{"name":%q,"size":"%d","contentType":"text/plain"}`, name, len(objects[name])))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"kind":"storage#objects","items":[%s]}`, strings.Join(items, ","))
+			return
+		}
+
+		content, ok := objects[strings.TrimPrefix(r.URL.Path, "/bank-docs/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+	t.Setenv("STORAGE_EMULATOR_HOST", server.URL)
+
+	ctx := context.Background()
+	g := NewGCS(ctx)
+	err := g.Connect(ctx, "bank-docs")
+	require.Nil(t, err)
+	defer g.Close(ctx)
+
+	tt := []struct {
+		description     string
+		method          string
+		query           string
+		opts            []QueryOption
+		expectedError   bool
+		expectedRecords []string
+	}{
+		{
+			description: "When listing a prefix, every object under it is returned with its size",
+			method:      "list",
+			query:       `{"prefix": "policies/"}`,
+			expectedRecords: []string{
+				`{"name":"policies/deposits.txt","size":37,"contentType":"text/plain"}`,
+				`{"name":"policies/draft.txt","size":0,"contentType":"text/plain"}`,
+			},
+		},
+		{
+			description: "When listing with maxResults, no more objects are returned",
+			method:      "list",
+			query:       `{"maxResults": 1}`,
+			expectedRecords: []string{
+				`{"name":"notes/branch.txt","size":13,"contentType":"text/plain"}`,
+			},
+		},
+		{
+			description:     "When getting an object, its text is returned",
+			method:          "get",
+			query:           "policies/deposits.txt",
+			expectedRecords: []string{"Deposits are insured up to 100000 EUR"},
+		},
+		{
+			description:   "When getting an object that does not exist, an error is returned",
+			method:        "get",
+			query:         "policies/loans.txt",
+			expectedError: true,
+		},
+		{
+			description:   "When the method is not supported, an error is returned",
+			method:        "delete",
+			query:         "policies/deposits.txt",
+			expectedError: true,
+		},
+		{
+			description:   "When the query is constrained, an error is returned",
+			method:        "list",
+			opts:          []QueryOption{WithConstraints(map[string]interface{}{"branch_id": "b1"})},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			records, err := g.Query(ctx, "", test.method, "", test.query, test.opts...)
+			if test.expectedError {
+				require.NotNil(t, err)
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, test.expectedRecords, records)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package datasource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultMaxResponseBytes is the largest response body read by default.
+const DefaultMaxResponseBytes = 1 << 20
+
+// maxErrorBody is how much of the body of an error response is kept in the
+// error.
+const maxErrorBody = 512
+
+// ErrResponseTooLarge is returned when a response body is larger than the
+// data source reads.
+var ErrResponseTooLarge = errors.New("response body is too large")
+
+// HTTPDataSource calls a JSON API. The connection string is the base URL and
+// a tool's Collection is the path of the endpoint it calls.
+type HTTPDataSource struct {
+	baseURL *url.URL
+	client  *http.Client
+	headers http.Header
+
+	maxResponseBytes int64
+	ownsClient       bool
+}
+
+type HTTPOption func(*HTTPDataSource)
+
+// WithHeader adds a header, such as an API key, to every request.
+func WithHeader(key, value string) HTTPOption {
+	return func(h *HTTPDataSource) {
+		h.headers.Add(key, value)
+	}
+}
+
+// WithHTTPClient sets the client used for requests. The data source does not
+// close its connections, as the client may be shared.
+func WithHTTPClient(c *http.Client) HTTPOption {
+	return func(h *HTTPDataSource) {
+		h.client = c
+	}
+}
+
+// WithMaxResponseBytes sets the largest response body read, so that a large
+// response fills neither memory nor the prompt. Larger responses return
+// ErrResponseTooLarge.
+func WithMaxResponseBytes(n int64) HTTPOption {
+	return func(h *HTTPDataSource) {
+		h.maxResponseBytes = n
+	}
+}
+
+func NewHTTPDataSource(opts ...HTTPOption) *HTTPDataSource {
+	h := &HTTPDataSource{
+		headers:          make(http.Header),
+		maxResponseBytes: DefaultMaxResponseBytes,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	// A client of its own, with a transport of its own, so that closing the
+	// data source leaves the connections of other clients alone
+	if h.client == nil {
+		h.client = &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+		h.ownsClient = true
+	}
+
+	return h
+}
+
+func (h *HTTPDataSource) Type() string {
+	return "http"
+}
+
+func (h *HTTPDataSource) Connect(ctx context.Context, connectionString string) error {
+	baseURL, err := url.Parse(connectionString)
+	if err != nil {
+		return err
+	}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return fmt.Errorf("invalid base url %q: scheme must be http or https", connectionString)
+	}
+
+	h.baseURL = baseURL
+	return nil
+}
+
+func (h *HTTPDataSource) Close(ctx context.Context) error {
+	if h.ownsClient {
+		h.client.CloseIdleConnections()
+	}
+	return nil
+}
+
+// Query supports get, where the query is an optional URL query string, and
+// post, where the query is the JSON request body. The response body is
+// returned as a single record.
+func (h *HTTPDataSource) Query(ctx context.Context, database, method, collection, query string, opts ...QueryOption) ([]string, error) {
+	if len(NewQueryOptions(opts...).Constraints) > 0 {
+		return nil, ErrConstraintsNotSupported
+	}
+
+	endpoint := h.baseURL.JoinPath(collection)
+
+	var req *http.Request
+	var err error
+	switch method {
+	case "get":
+		endpoint.RawQuery = query
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	case "post":
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), strings.NewReader(query))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	default:
+		return nil, fmt.Errorf("method not supported")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range h.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// One byte more than allowed tells a body of the largest size from a
+	// larger one
+	body, err := io.ReadAll(io.LimitReader(res.Body, h.maxResponseBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("%s %s returned %s: %s", req.Method, endpoint.Path, res.Status, errorBody(body))
+	}
+	if int64(len(body)) > h.maxResponseBytes {
+		return nil, fmt.Errorf("%s %s: %w, the limit is %d bytes", req.Method, endpoint.Path, ErrResponseTooLarge, h.maxResponseBytes)
+	}
+
+	return []string{string(body)}, nil
+}
+
+// errorBody returns the start of the body of an error response, which can be
+// a whole page of HTML, for its error.
+func errorBody(body []byte) string {
+	text := strings.TrimSpace(string(body))
+	if len(text) <= maxErrorBody {
+		return text
+	}
+
+	return strings.ToValidUTF8(text[:maxErrorBody], "") + "..."
+}
@@ -0,0 +1,164 @@
+package datasource
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/export":
+			w.Write([]byte(strings.Repeat("x", 2048)))
+		case r.Method == http.MethodGet && r.URL.Path == "/api/cases":
+			w.Write([]byte("{\"cases\":[],\"status\":\"" + r.URL.Query().Get("status") + "\"}"))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/cases":
+			body, _ := io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+			w.Write(body)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("not found"))
+		}
+	}))
+	defer server.Close()
+
+	tt := []struct {
+		description    string
+		method         string
+		collection     string
+		query          string
+		opts           []QueryOption
+		expectError    bool
+		expectedResult []string
+	}{
+		{
+			description:    "When get is called, the query is sent as the URL query string and the body is returned",
+			method:         "get",
+			collection:     "cases",
+			query:          "status=open",
+			expectError:    false,
+			expectedResult: []string{"{\"cases\":[],\"status\":\"open\"}"},
+		},
+		{
+			description:    "When post is called, the query is sent as the body and the response is returned",
+			method:         "post",
+			collection:     "/cases",
+			query:          "{ \"account\": \"123\" }",
+			expectError:    false,
+			expectedResult: []string{"{ \"account\": \"123\" }"},
+		},
+		{
+			description: "When the endpoint returns an error status, an error is returned",
+			method:      "get",
+			collection:  "unknown",
+			query:       "",
+			expectError: true,
+		},
+		{
+			description: "When the response is larger than the data source reads, an error is returned",
+			method:      "get",
+			collection:  "export",
+			query:       "",
+			expectError: true,
+		},
+		{
+			description: "When the query is constrained, an error is returned as constraints cannot be enforced",
+			method:      "get",
+			collection:  "cases",
+			query:       "status=open",
+			opts:        []QueryOption{WithConstraints(map[string]interface{}{"branch_id": 12})},
+			expectError: true,
+		},
+		{
+			description: "When an unsupported method is called, an error is returned",
+			method:      "delete",
+			collection:  "cases",
+			query:       "",
+			expectError: true,
+		},
+	}
+
+	ctx := context.Background()
+	h := NewHTTPDataSource(WithHeader("X-Api-Key", "secret"), WithMaxResponseBytes(1024))
+	err := h.Connect(ctx, server.URL+"/api")
+	require.Nil(t, err)
+	defer h.Close(ctx)
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			res, err := h.Query(ctx, "", test.method, test.collection, test.query, test.opts...)
+			if test.expectError {
+				require.NotNil(t, err)
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, test.expectedResult, res)
+		})
+	}
+}
+
+func TestErrorBody(t *testing.T) {
+	require.Equal(t, "not found", errorBody([]byte("not found\n")))
+
+	text := errorBody([]byte(strings.Repeat("é", maxErrorBody)))
+	require.True(t, strings.HasSuffix(text, "..."))
+	require.LessOrEqual(t, len(text), maxErrorBody+len("..."))
+	require.True(t, utf8.ValidString(text))
+}
+
+func TestHTTPClose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	// get makes a request with the shared default client, and tells whether
+	// it reused an idle connection
+	get := func() bool {
+		var reused bool
+		trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused }}
+		req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, server.URL, nil)
+		require.Nil(t, err)
+
+		res, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+
+		return reused
+	}
+	get()
+
+	ctx := context.Background()
+	h := NewHTTPDataSource()
+	err := h.Connect(ctx, server.URL)
+	require.Nil(t, err)
+	_, err = h.Query(ctx, "", "get", "", "")
+	require.Nil(t, err)
+
+	err = h.Close(ctx)
+	require.Nil(t, err)
+
+	require.True(t, get(), "closing the data source closed the connections of the default client")
+}
+
+func TestHTTPConnect(t *testing.T) {
+	h := NewHTTPDataSource()
+	err := h.Connect(context.Background(), "ftp://example.com")
+	require.NotNil(t, err)
+}
@@ -0,0 +1,30 @@
+package datasource
+
+import (
+	"context"
+	"doppelganger/pkg/ratelimit"
+)
+
+// Limited runs the queries of a data source within the limits of a
+// ratelimit.Limiter, shared by every tool using it, to protect the source
+// from bursts of decisions.
+type Limited struct {
+	DataSource
+	limiter *ratelimit.Limiter
+}
+
+func NewLimited(source DataSource, limiter *ratelimit.Limiter) *Limited {
+	return &Limited{DataSource: source, limiter: limiter}
+}
+
+// Query waits for the turn of the query, and fails with
+// ratelimit.ErrRateLimited when it does not come in time.
+func (l *Limited) Query(ctx context.Context, database, method, collection, query string, opts ...QueryOption) ([]string, error) {
+	release, err := l.limiter.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return l.DataSource.Query(ctx, database, method, collection, query, opts...)
+}
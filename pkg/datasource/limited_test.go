@@ -0,0 +1,51 @@
+package datasource
+
+import (
+	"context"
+	"doppelganger/pkg/ratelimit"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type blockingSource struct {
+	recordingSource
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (b *blockingSource) Query(ctx context.Context, database, method, collection, query string, opts ...QueryOption) ([]string, error) {
+	b.started <- struct{}{}
+	<-b.unblock
+	return []string{query}, nil
+}
+
+func TestLimited(t *testing.T) {
+	ctx := context.Background()
+	source := &blockingSource{started: make(chan struct{}), unblock: make(chan struct{})}
+	limited := NewLimited(source, ratelimit.New(ratelimit.Limits{MaxInFlight: 1, MaxWait: 10 * time.Millisecond}))
+	require.Equal(t, "recording", limited.Type())
+
+	done := make(chan error)
+	go func() {
+		_, err := limited.Query(ctx, "", "find", "accounts", "first")
+		done <- err
+	}()
+	<-source.started
+
+	// The second query does not get a turn while the first runs
+	_, err := limited.Query(ctx, "", "find", "accounts", "second")
+	require.ErrorIs(t, err, ratelimit.ErrRateLimited)
+
+	close(source.unblock)
+	require.Nil(t, <-done)
+
+	go func() { <-source.started }()
+	records, err := limited.Query(ctx, "", "find", "accounts", "third")
+	require.Nil(t, err)
+	require.Equal(t, []string{"third"}, records)
+
+	require.Nil(t, limited.Close(ctx))
+	require.True(t, source.closed)
+}
@@ -3,6 +3,8 @@ package datasource
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
@@ -14,12 +16,43 @@ import (
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
+// cursorCloseTimeout caps how long closing a cursor of a query whose context
+// expired may take.
+const cursorCloseTimeout = 5 * time.Second
+
 type MongoDataSource struct {
-	client *mongo.Client
+	client    *mongo.Client
+	canonical bool
+	policy    *Policy
+}
+
+type MongoOption func(*MongoDataSource)
+
+// WithCanonicalExtJSON makes query results use canonical Extended JSON, which
+// keeps every BSON type, instead of the default relaxed form.
+func WithCanonicalExtJSON() MongoOption {
+	return func(m *MongoDataSource) {
+		m.canonical = true
+	}
 }
 
-func NewMongoDataSource() *MongoDataSource {
-	return &MongoDataSource{}
+// WithDefaultPolicy sets the policy applied to queries from tools that do not
+// carry their own. A nil policy disables the checks.
+func WithDefaultPolicy(p *Policy) MongoOption {
+	return func(m *MongoDataSource) {
+		m.policy = p
+	}
+}
+
+func NewMongoDataSource(opts ...MongoOption) *MongoDataSource {
+	m := &MongoDataSource{
+		policy: DefaultPolicy(),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
 }
 
 func (m *MongoDataSource) Type() string {
@@ -40,6 +73,10 @@ func (m *MongoDataSource) Connect(ctx context.Context, connectionString string)
 }
 
 func (m *MongoDataSource) Close(ctx context.Context) error {
+	if m.client == nil {
+		return nil
+	}
+
 	err := m.client.Disconnect(ctx)
 	if err != nil {
 		return err
@@ -47,42 +84,62 @@ func (m *MongoDataSource) Close(ctx context.Context) error {
 	return nil
 }
 
-func (m *MongoDataSource) Query(ctx context.Context, database, method, collection, query string) ([]string, error) {
+// distinctQuery is the JSON shape accepted by the distinct method.
+type distinctQuery struct {
+	Field  string `bson:"field"`
+	Filter bson.D `bson:"filter"`
+}
+
+// updateQuery is the JSON shape accepted by the updateOne method.
+type updateQuery struct {
+	Filter bson.D      `bson:"filter"`
+	Update interface{} `bson:"update"`
+}
+
+func (m *MongoDataSource) Query(ctx context.Context, database, method, collection, query string, opts ...QueryOption) ([]string, error) {
 	mc := m.client.Database(database).Collection(collection)
+	qo := NewQueryOptions(opts...)
 
-	var bsonObject bson.D
-	err := json.Unmarshal([]byte(query), &bsonObject)
-	if err != nil {
-		return nil, err
+	policy := m.policy
+	if qo.Policy != nil {
+		policy = qo.Policy
 	}
 
+	ctx, cancel := policy.WithTimeout(ctx)
+	defer cancel()
+
 	switch method {
 	case "find":
-		cursor, err := mc.Find(ctx, bsonObject)
+		filter, err := parseFilter(query, policy)
 		if err != nil {
 			return nil, err
 		}
+		filter = constrain(filter, qo)
 
-		defer cursor.Close(ctx)
+		findOpts, err := findOptions(qo, policy)
+		if err != nil {
+			return nil, err
+		}
 
-		var records []string
-		for cursor.Next(ctx) {
-			var result bson.D
-			if err := cursor.Decode(&result); err != nil {
-				return nil, err
-			}
-			resBytes, err := json.Marshal(result)
-			if err != nil {
-				return nil, err
-			}
+		cursor, err := mc.Find(ctx, filter, findOpts)
+		if err != nil {
+			return nil, err
+		}
 
-			records = append(records, string(resBytes))
+		return m.readCursor(ctx, cursor)
+	case "findOne":
+		filter, err := parseFilter(query, policy)
+		if err != nil {
+			return nil, err
+		}
+		filter = constrain(filter, qo)
 
+		findOneOpts, err := findOneOptions(qo, policy)
+		if err != nil {
+			return nil, err
 		}
 
-		return records, nil
-	case "findOne":
-		res := mc.FindOne(ctx, bsonObject)
+		res := mc.FindOne(ctx, filter, findOneOpts)
 		if res.Err() != nil {
 			return nil, res.Err()
 		}
@@ -92,14 +149,376 @@ func (m *MongoDataSource) Query(ctx context.Context, database, method, collectio
 			return nil, err
 		}
 
-		resBytes, err := json.Marshal(result)
+		record, err := m.marshalRecord(result)
+		if err != nil {
+			return nil, err
+		}
+
+		return []string{record}, nil
+	case "aggregate":
+		var pipeline bson.A
+		err := bson.UnmarshalExtJSON([]byte(query), false, &pipeline)
+		if err != nil {
+			return nil, err
+		}
+
+		err = policy.Check(pipeline)
+		if err != nil {
+			return nil, err
+		}
+		err = checkConstrainedPipeline(pipeline, qo)
+		if err != nil {
+			return nil, err
+		}
+		if len(qo.Constraints) > 0 {
+			pipeline = append(bson.A{bson.D{{Key: "$match", Value: constraintFilter(qo)}}}, pipeline...)
+		}
+
+		cursor, err := mc.Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, err
+		}
+
+		return m.readCursor(ctx, cursor)
+	case "countDocuments":
+		filter, err := parseFilter(query, policy)
+		if err != nil {
+			return nil, err
+		}
+		filter = constrain(filter, qo)
+
+		count, err := mc.CountDocuments(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := m.marshalRecord(bson.D{{Key: "count", Value: count}})
+		if err != nil {
+			return nil, err
+		}
+
+		return []string{record}, nil
+	case "distinct":
+		var dq distinctQuery
+		err := bson.UnmarshalExtJSON([]byte(query), false, &dq)
+		if err != nil {
+			return nil, err
+		}
+		if dq.Field == "" {
+			return nil, fmt.Errorf("distinct query requires a field")
+		}
+		if dq.Filter == nil {
+			dq.Filter = bson.D{}
+		}
+
+		err = policy.Check(dq.Filter)
+		if err != nil {
+			return nil, err
+		}
+		dq.Filter = constrain(dq.Filter, qo)
+
+		var values bson.A
+		err = mc.Distinct(ctx, dq.Field, dq.Filter).Decode(&values)
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := m.marshalRecord(bson.D{{Key: "values", Value: values}})
+		if err != nil {
+			return nil, err
+		}
+
+		return []string{record}, nil
+	case "insertOne":
+		err := policy.CheckWrite(method)
+		if err != nil {
+			return nil, err
+		}
+
+		doc, err := parseFilter(query, policy)
+		if err != nil {
+			return nil, err
+		}
+		doc = constrainDocument(doc, qo)
+
+		res, err := mc.InsertOne(ctx, doc)
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := m.marshalRecord(bson.D{{Key: "insertedId", Value: res.InsertedID}})
+		if err != nil {
+			return nil, err
+		}
+
+		return []string{record}, nil
+	case "updateOne":
+		err := policy.CheckWrite(method)
+		if err != nil {
+			return nil, err
+		}
+
+		var uq updateQuery
+		err = bson.UnmarshalExtJSON([]byte(query), false, &uq)
+		if err != nil {
+			return nil, err
+		}
+		if uq.Filter == nil || uq.Update == nil {
+			return nil, fmt.Errorf("updateOne query requires a filter and an update")
+		}
+
+		err = policy.Check(uq.Filter, uq.Update)
+		if err != nil {
+			return nil, err
+		}
+		err = checkConstrainedUpdate(uq.Update, qo)
+		if err != nil {
+			return nil, err
+		}
+		uq.Filter = constrain(uq.Filter, qo)
+
+		res, err := mc.UpdateOne(ctx, uq.Filter, uq.Update)
 		if err != nil {
 			return nil, err
 		}
 
-		return []string{string(resBytes)}, nil
+		record, err := m.marshalRecord(bson.D{
+			{Key: "matchedCount", Value: res.MatchedCount},
+			{Key: "modifiedCount", Value: res.ModifiedCount},
+		})
+		if err != nil {
+			return nil, err
+		}
 
+		return []string{record}, nil
 	}
 
 	return nil, fmt.Errorf("method not supported")
 }
+
+// parseFilter reads a filter in relaxed or canonical Extended JSON, so
+// templates can use types such as {"$oid": "..."} and {"$date": "..."}.
+func parseFilter(query string, policy *Policy) (bson.D, error) {
+	var filter bson.D
+	err := bson.UnmarshalExtJSON([]byte(query), false, &filter)
+	if err != nil {
+		return nil, err
+	}
+
+	err = policy.Check(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// constrain combines the filter with the constraints of the query, so the
+// filter cannot widen them, for example with $or.
+func constrain(filter bson.D, qo *QueryOptions) bson.D {
+	if len(qo.Constraints) == 0 {
+		return filter
+	}
+
+	return bson.D{{Key: "$and", Value: bson.A{filter, constraintFilter(qo)}}}
+}
+
+func constraintFilter(qo *QueryOptions) bson.D {
+	fields := make([]string, 0, len(qo.Constraints))
+	for field := range qo.Constraints {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	filter := make(bson.D, 0, len(fields))
+	for _, field := range fields {
+		filter = append(filter, bson.E{Key: field, Value: qo.Constraints[field]})
+	}
+
+	return filter
+}
+
+// checkConstrainedPipeline rejects the stages of a constrained pipeline that
+// read documents the leading $match does not filter.
+func checkConstrainedPipeline(pipeline bson.A, qo *QueryOptions) error {
+	if len(qo.Constraints) == 0 {
+		return nil
+	}
+
+	err := (&Policy{DeniedOperators: CrossCollectionStages}).Check(pipeline)
+	if err != nil {
+		return fmt.Errorf("constrained query: %w", err)
+	}
+
+	return nil
+}
+
+// checkConstrainedUpdate rejects updates that change a constrained field,
+// which would move the document out of the scope of the caller or into the
+// scope of another.
+func checkConstrainedUpdate(update interface{}, qo *QueryOptions) error {
+	if len(qo.Constraints) == 0 {
+		return nil
+	}
+
+	doc, ok := update.(bson.D)
+	if !ok {
+		return fmt.Errorf("%w: constrained updates must be update documents", ErrPolicyViolation)
+	}
+
+	for _, op := range doc {
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			return fmt.Errorf("%w: update operator %s must take a document", ErrPolicyViolation, op.Key)
+		}
+
+		for _, f := range fields {
+			names := []string{f.Key}
+			// $rename also writes the field it renames to
+			if to, ok := f.Value.(string); ok && op.Key == "$rename" {
+				names = append(names, to)
+			}
+
+			for _, name := range names {
+				if constrained(name, qo) {
+					return fmt.Errorf("%w: update changes constrained field %s", ErrPolicyViolation, name)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// constrained tells whether writing the field path changes a constrained
+// field, or a document containing one.
+func constrained(path string, qo *QueryOptions) bool {
+	for field := range qo.Constraints {
+		if path == field || strings.HasPrefix(path, field+".") || strings.HasPrefix(field, path+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// constrainDocument sets the constrained fields of a document to insert.
+func constrainDocument(doc bson.D, qo *QueryOptions) bson.D {
+	for _, c := range constraintFilter(qo) {
+		replaced := false
+		for i := range doc {
+			if doc[i].Key == c.Key {
+				doc[i].Value = c.Value
+				replaced = true
+			}
+		}
+		if !replaced {
+			doc = append(doc, c)
+		}
+	}
+
+	return doc
+}
+
+func findOptions(qo *QueryOptions, policy *Policy) (*options.FindOptionsBuilder, error) {
+	opts := options.Find()
+
+	projection, sort, err := parseProjectionAndSort(qo, policy)
+	if err != nil {
+		return nil, err
+	}
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+	if sort != nil {
+		opts.SetSort(sort)
+	}
+	if qo.Limit > 0 {
+		opts.SetLimit(qo.Limit)
+	}
+	if qo.Skip > 0 {
+		opts.SetSkip(qo.Skip)
+	}
+
+	return opts, nil
+}
+
+func findOneOptions(qo *QueryOptions, policy *Policy) (*options.FindOneOptionsBuilder, error) {
+	opts := options.FindOne()
+
+	projection, sort, err := parseProjectionAndSort(qo, policy)
+	if err != nil {
+		return nil, err
+	}
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+	if sort != nil {
+		opts.SetSort(sort)
+	}
+	if qo.Skip > 0 {
+		opts.SetSkip(qo.Skip)
+	}
+
+	return opts, nil
+}
+
+func parseProjectionAndSort(qo *QueryOptions, policy *Policy) (bson.D, bson.D, error) {
+	var projection, sort bson.D
+
+	if strings.TrimSpace(qo.Projection) != "" {
+		err := bson.UnmarshalExtJSON([]byte(qo.Projection), false, &projection)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid projection: %w", err)
+		}
+	}
+
+	if strings.TrimSpace(qo.Sort) != "" {
+		err := bson.UnmarshalExtJSON([]byte(qo.Sort), false, &sort)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid sort: %w", err)
+		}
+	}
+
+	err := policy.Check(projection, sort)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return projection, sort, nil
+}
+
+func (m *MongoDataSource) readCursor(ctx context.Context, cursor *mongo.Cursor) ([]string, error) {
+	// Kill the cursor on the server even when ctx has expired
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cursorCloseTimeout)
+		defer cancel()
+		cursor.Close(closeCtx)
+	}()
+
+	var records []string
+	for cursor.Next(ctx) {
+		var result bson.D
+		if err := cursor.Decode(&result); err != nil {
+			return nil, err
+		}
+
+		record, err := m.marshalRecord(result)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	return records, cursor.Err()
+}
+
+func (m *MongoDataSource) marshalRecord(doc bson.D) (string, error) {
+	resBytes, err := bson.MarshalExtJSON(doc, m.canonical, false)
+	if err != nil {
+		return "", err
+	}
+
+	return string(resBytes), nil
+}
@@ -3,6 +3,7 @@ package datasource
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
@@ -28,8 +29,10 @@ func TestQuery(t *testing.T) {
 		recordsToInsert []bson.M
 		query           string
 		method          string
+		opts            []QueryOption
 		expectError     bool
 		recordsReturned int
+		expectedRecords []string
 	}{
 		{
 			description: "When find is called and matching records are present, returns records without any error",
@@ -103,6 +106,324 @@ func TestQuery(t *testing.T) {
 			expectError:     true,
 			recordsReturned: 0,
 		},
+		{
+			description: "When aggregate is called with a pipeline, returns the records produced by the pipeline",
+			collection:  "test",
+			recordsToInsert: []bson.M{
+				{
+					"name":   "one",
+					"type":   "record",
+					"branch": "madrid",
+				},
+				{
+					"name":   "two",
+					"type":   "record",
+					"branch": "madrid",
+				},
+				{
+					"name":   "three",
+					"type":   "flagged",
+					"branch": "zurich",
+				},
+			},
+			query:           "[ { \"$match\": { \"type\": \"record\" } }, { \"$sort\": { \"name\": 1 } }, { \"$project\": { \"_id\": 0, \"name\": 1 } } ]",
+			method:          "aggregate",
+			expectError:     false,
+			recordsReturned: 2,
+			expectedRecords: []string{"{\"name\":\"one\"}", "{\"name\":\"two\"}"},
+		},
+		{
+			description: "When aggregate is called with a pipeline that is not an array, an error is returned",
+			collection:  "test",
+			recordsToInsert: []bson.M{
+				{
+					"name":   "one",
+					"type":   "record",
+					"branch": "madrid",
+				},
+				{
+					"name":   "two",
+					"type":   "record",
+					"branch": "madrid",
+				},
+				{
+					"name":   "three",
+					"type":   "flagged",
+					"branch": "zurich",
+				},
+			},
+			query:           "{ \"$match\": { \"type\": \"record\" } }",
+			method:          "aggregate",
+			expectError:     true,
+			recordsReturned: 0,
+		},
+		{
+			description: "When countDocuments is called, returns a single record holding the count",
+			collection:  "test",
+			recordsToInsert: []bson.M{
+				{
+					"name":   "one",
+					"type":   "record",
+					"branch": "madrid",
+				},
+				{
+					"name":   "two",
+					"type":   "record",
+					"branch": "madrid",
+				},
+				{
+					"name":   "three",
+					"type":   "flagged",
+					"branch": "zurich",
+				},
+			},
+			query:           "{ \"type\": \"flagged\" }",
+			method:          "countDocuments",
+			expectError:     false,
+			recordsReturned: 1,
+			expectedRecords: []string{"{\"count\":1}"},
+		},
+		{
+			description: "When distinct is called with a field and filter, returns a single record holding the values",
+			collection:  "test",
+			recordsToInsert: []bson.M{
+				{
+					"name":   "one",
+					"type":   "record",
+					"branch": "madrid",
+				},
+				{
+					"name":   "two",
+					"type":   "record",
+					"branch": "madrid",
+				},
+				{
+					"name":   "three",
+					"type":   "flagged",
+					"branch": "zurich",
+				},
+			},
+			query:           "{ \"field\": \"branch\", \"filter\": { \"type\": \"record\" } }",
+			method:          "distinct",
+			expectError:     false,
+			recordsReturned: 1,
+			expectedRecords: []string{"{\"values\":[\"madrid\"]}"},
+		},
+		{
+			description: "When distinct is called without a field, an error is returned",
+			collection:  "test",
+			recordsToInsert: []bson.M{
+				{
+					"name":   "one",
+					"type":   "record",
+					"branch": "madrid",
+				},
+				{
+					"name":   "two",
+					"type":   "record",
+					"branch": "madrid",
+				},
+				{
+					"name":   "three",
+					"type":   "flagged",
+					"branch": "zurich",
+				},
+			},
+			query:           "{ \"filter\": { \"type\": \"record\" } }",
+			method:          "distinct",
+			expectError:     true,
+			recordsReturned: 0,
+		},
+		{
+			description: "When find is called with a limit and skip, returns the requested page of records",
+			collection:  "test",
+			recordsToInsert: []bson.M{
+				{
+					"name": "one",
+					"type": "record",
+				},
+				{
+					"name": "two",
+					"type": "record",
+				},
+				{
+					"name": "three",
+					"type": "record",
+				},
+			},
+			query:           "{ \"type\": \"record\" }",
+			method:          "find",
+			opts:            []QueryOption{WithSort("{ \"name\": 1 }"), WithSkip(1), WithLimit(1)},
+			expectError:     false,
+			recordsReturned: 1,
+		},
+		{
+			description: "When find is called with a projection, returns records without error",
+			collection:  "test",
+			recordsToInsert: []bson.M{
+				{
+					"name": "one",
+					"type": "record",
+				},
+				{
+					"name": "two",
+					"type": "record",
+				},
+				{
+					"name": "three",
+					"type": "record",
+				},
+			},
+			query:           "{ \"type\": \"record\" }",
+			method:          "find",
+			opts:            []QueryOption{WithProjection("{ \"name\": 1, \"_id\": 0 }")},
+			expectError:     false,
+			recordsReturned: 3,
+		},
+		{
+			description: "When findOne is called with a sort that is not formed correctly, an error is returned",
+			collection:  "test",
+			recordsToInsert: []bson.M{
+				{
+					"name": "one",
+					"type": "record",
+				},
+				{
+					"name": "two",
+					"type": "record",
+				},
+				{
+					"name": "three",
+					"type": "record",
+				},
+			},
+			query:           "{ \"type\": \"record\" }",
+			method:          "findOne",
+			opts:            []QueryOption{WithSort("{ \"name\": }")},
+			expectError:     true,
+			recordsReturned: 0,
+		},
+		{
+			description: "When find is called with an Extended JSON date range, returns the records in range",
+			collection:  "test",
+			recordsToInsert: []bson.M{
+				{
+					"name":    "one",
+					"created": time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC),
+				},
+				{
+					"name":    "two",
+					"created": time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+			query:           "{ \"created\": { \"$gte\": { \"$date\": \"2024-01-01T00:00:00Z\" } } }",
+			method:          "find",
+			expectError:     false,
+			recordsReturned: 1,
+		},
+		{
+			description: "When find is called with a JavaScript operator, the default policy rejects it",
+			collection:  "test",
+			recordsToInsert: []bson.M{
+				{
+					"name": "one",
+					"type": "record",
+				},
+			},
+			query:           "{ \"$where\": \"sleep(10000) || true\" }",
+			method:          "find",
+			expectError:     true,
+			recordsReturned: 0,
+		},
+		{
+			description: "When aggregate is called with a write stage, the default policy rejects it",
+			collection:  "test",
+			recordsToInsert: []bson.M{
+				{
+					"name": "one",
+					"type": "record",
+				},
+			},
+			query:           "[ { \"$match\": {} }, { \"$out\": \"stolen\" } ]",
+			method:          "aggregate",
+			expectError:     true,
+			recordsReturned: 0,
+		},
+		{
+			description: "When find is called with an operator outside the tool's whitelist, an error is returned",
+			collection:  "test",
+			recordsToInsert: []bson.M{
+				{
+					"name": "one",
+					"type": "record",
+				},
+			},
+			query:           "{ \"name\": { \"$regex\": \"^o\" } }",
+			method:          "find",
+			opts:            []QueryOption{WithPolicy(&Policy{AllowedOperators: []string{"$eq", "$in"}})},
+			expectError:     true,
+			recordsReturned: 0,
+		},
+		{
+			description: "When insertOne is called and the policy does not allow writes, an error is returned",
+			collection:  "test",
+			recordsToInsert: []bson.M{
+				{
+					"name":    "one",
+					"flagged": false,
+				},
+			},
+			query:           "{ \"name\": \"two\" }",
+			method:          "insertOne",
+			expectError:     true,
+			recordsReturned: 0,
+		},
+		{
+			description: "When insertOne is called and the policy allows writes, returns the inserted id",
+			collection:  "test",
+			recordsToInsert: []bson.M{
+				{
+					"name":    "one",
+					"flagged": false,
+				},
+			},
+			query:           "{ \"name\": \"two\" }",
+			method:          "insertOne",
+			opts:            []QueryOption{WithPolicy(&Policy{AllowWrites: true})},
+			expectError:     false,
+			recordsReturned: 1,
+		},
+		{
+			description: "When updateOne is called and the policy allows writes, returns the matched and modified counts",
+			collection:  "test",
+			recordsToInsert: []bson.M{
+				{
+					"name":    "one",
+					"flagged": false,
+				},
+			},
+			query:           "{ \"filter\": { \"name\": \"one\" }, \"update\": { \"$set\": { \"flagged\": true } } }",
+			method:          "updateOne",
+			opts:            []QueryOption{WithPolicy(&Policy{AllowWrites: true})},
+			expectError:     false,
+			recordsReturned: 1,
+			expectedRecords: []string{"{\"matchedCount\":1,\"modifiedCount\":1}"},
+		},
+		{
+			description: "When updateOne is called without an update, an error is returned",
+			collection:  "test",
+			recordsToInsert: []bson.M{
+				{
+					"name":    "one",
+					"flagged": false,
+				},
+			},
+			query:           "{ \"filter\": { \"name\": \"one\" } }",
+			method:          "updateOne",
+			opts:            []QueryOption{WithPolicy(&Policy{AllowWrites: true})},
+			expectError:     true,
+			recordsReturned: 0,
+		},
 	}
 
 	m := NewMongoDataSource()
@@ -117,10 +438,13 @@ func TestQuery(t *testing.T) {
 		_, err := m.client.Database("test").Collection(collection).InsertMany(ctx, test.recordsToInsert)
 		require.Nil(t, err)
 
-		records, err := m.Query(ctx, "test", test.method, collection, test.query)
+		records, err := m.Query(ctx, "test", test.method, collection, test.query, test.opts...)
 		if !test.expectError {
 			require.Nil(t, err)
 			require.Equal(t, test.recordsReturned, len(records))
+			if test.expectedRecords != nil {
+				require.Equal(t, test.expectedRecords, records)
+			}
 		} else {
 			require.NotNil(t, err)
 		}
@@ -129,3 +453,252 @@ func TestQuery(t *testing.T) {
 		require.Nil(t, err)
 	}
 }
+
+func TestParseFilter(t *testing.T) {
+	oid, err := bson.ObjectIDFromHex("5f1b2c3d4e5f6a7b8c9d0e1f")
+	require.Nil(t, err)
+
+	tt := []struct {
+		description    string
+		query          string
+		expectError    bool
+		expectedFilter bson.D
+	}{
+		{
+			description:    "When the filter uses a relaxed ObjectId, it is parsed into an ObjectID",
+			query:          "{ \"_id\": { \"$oid\": \"5f1b2c3d4e5f6a7b8c9d0e1f\" } }",
+			expectError:    false,
+			expectedFilter: bson.D{{Key: "_id", Value: oid}},
+		},
+		{
+			description:    "When the filter uses a relaxed date, it is parsed into a DateTime",
+			query:          "{ \"created\": { \"$lt\": { \"$date\": \"2024-01-01T00:00:00Z\" } } }",
+			expectError:    false,
+			expectedFilter: bson.D{{Key: "created", Value: bson.D{{Key: "$lt", Value: bson.NewDateTimeFromTime(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC))}}}},
+		},
+		{
+			description:    "When the filter uses canonical numbers, they keep their type",
+			query:          "{ \"count\": { \"$numberLong\": \"5\" } }",
+			expectError:    false,
+			expectedFilter: bson.D{{Key: "count", Value: int64(5)}},
+		},
+		{
+			description: "When the filter is not valid Extended JSON, an error is returned",
+			query:       "{ \"_id\": { \"$oid\": \"not-an-id\" } }",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			filter, err := parseFilter(test.query, nil)
+			if test.expectError {
+				require.NotNil(t, err)
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, test.expectedFilter, filter)
+		})
+	}
+}
+
+func TestConstrain(t *testing.T) {
+	qo := NewQueryOptions(WithConstraints(map[string]interface{}{"region": "north", "branch_id": 12}))
+
+	tt := []struct {
+		description      string
+		qo               *QueryOptions
+		filter           bson.D
+		expectedFilter   bson.D
+		expectedDocument bson.D
+	}{
+		{
+			description:      "When there are no constraints, the filter is unchanged",
+			qo:               NewQueryOptions(),
+			filter:           bson.D{{Key: "status", Value: "open"}},
+			expectedFilter:   bson.D{{Key: "status", Value: "open"}},
+			expectedDocument: bson.D{{Key: "status", Value: "open"}},
+		},
+		{
+			description: "When there are constraints, they are combined with the filter so it cannot widen them",
+			qo:          qo,
+			filter:      bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "branch_id", Value: 7}}, bson.D{}}}},
+			expectedFilter: bson.D{{Key: "$and", Value: bson.A{
+				bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "branch_id", Value: 7}}, bson.D{}}}},
+				bson.D{{Key: "branch_id", Value: 12}, {Key: "region", Value: "north"}},
+			}}},
+		},
+		{
+			description:      "When a document is inserted, the constrained fields are set",
+			qo:               qo,
+			filter:           bson.D{{Key: "branch_id", Value: 7}, {Key: "amount", Value: 10}},
+			expectedDocument: bson.D{{Key: "branch_id", Value: 12}, {Key: "amount", Value: 10}, {Key: "region", Value: "north"}},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			if test.expectedFilter != nil {
+				require.Equal(t, test.expectedFilter, constrain(test.filter, test.qo))
+			}
+			if test.expectedDocument != nil {
+				require.Equal(t, test.expectedDocument, constrainDocument(test.filter, test.qo))
+			}
+		})
+	}
+}
+
+func TestCheckConstrained(t *testing.T) {
+	qo := NewQueryOptions(WithConstraints(map[string]interface{}{"branch_id": 12, "owner.region": "north"}))
+
+	tt := []struct {
+		description string
+		qo          *QueryOptions
+		method      string
+		query       string
+		expectError bool
+	}{
+		{
+			description: "When a constrained pipeline only reads the collection, no error is returned",
+			qo:          qo,
+			method:      "aggregate",
+			query:       `[{"$group": {"_id": "$status", "total": {"$sum": "$amount"}}}]`,
+		},
+		{
+			description: "When a constrained pipeline unions another collection, an error is returned",
+			qo:          qo,
+			method:      "aggregate",
+			query:       `[{"$unionWith": "accounts"}]`,
+			expectError: true,
+		},
+		{
+			description: "When a constrained pipeline looks up another collection in a facet, an error is returned",
+			qo:          qo,
+			method:      "aggregate",
+			query:       `[{"$facet": {"all": [{"$lookup": {"from": "accounts", "pipeline": [], "as": "rows"}}]}}]`,
+			expectError: true,
+		},
+		{
+			description: "When a constrained pipeline walks a graph, an error is returned",
+			qo:          qo,
+			method:      "aggregate",
+			query:       `[{"$graphLookup": {"from": "accounts", "startWith": "$parent", "connectFromField": "parent", "connectToField": "_id", "as": "rows"}}]`,
+			expectError: true,
+		},
+		{
+			description: "When a pipeline is not constrained, every stage is left to the policy",
+			qo:          NewQueryOptions(),
+			method:      "aggregate",
+			query:       `[{"$unionWith": "accounts"}]`,
+		},
+		{
+			description: "When a constrained update changes other fields, no error is returned",
+			qo:          qo,
+			method:      "updateOne",
+			query:       `{"filter": {"_id": 1}, "update": {"$set": {"status": "closed", "owner.name": "Ada"}, "$inc": {"amount": 5}}}`,
+		},
+		{
+			description: "When a constrained update sets a constrained field, an error is returned",
+			qo:          qo,
+			method:      "updateOne",
+			query:       `{"filter": {"_id": 1}, "update": {"$set": {"branch_id": 7}}}`,
+			expectError: true,
+		},
+		{
+			description: "When a constrained update replaces the document holding a constrained field, an error is returned",
+			qo:          qo,
+			method:      "updateOne",
+			query:       `{"filter": {"_id": 1}, "update": {"$set": {"owner": {"region": "south"}}}}`,
+			expectError: true,
+		},
+		{
+			description: "When a constrained update unsets a constrained field, an error is returned",
+			qo:          qo,
+			method:      "updateOne",
+			query:       `{"filter": {"_id": 1}, "update": {"$unset": {"owner.region": ""}}}`,
+			expectError: true,
+		},
+		{
+			description: "When a constrained update renames a field to a constrained one, an error is returned",
+			qo:          qo,
+			method:      "updateOne",
+			query:       `{"filter": {"_id": 1}, "update": {"$rename": {"old_branch": "branch_id"}}}`,
+			expectError: true,
+		},
+		{
+			description: "When a constrained update is a pipeline, an error is returned",
+			qo:          qo,
+			method:      "updateOne",
+			query:       `{"filter": {"_id": 1}, "update": [{"$set": {"branch_id": 7}}]}`,
+			expectError: true,
+		},
+		{
+			description: "When an update is not constrained, it may set any field",
+			qo:          NewQueryOptions(),
+			method:      "updateOne",
+			query:       `{"filter": {"_id": 1}, "update": {"$set": {"branch_id": 7}}}`,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			var err error
+			if test.method == "aggregate" {
+				var pipeline bson.A
+				require.Nil(t, bson.UnmarshalExtJSON([]byte(test.query), false, &pipeline))
+				err = checkConstrainedPipeline(pipeline, test.qo)
+			} else {
+				var uq updateQuery
+				require.Nil(t, bson.UnmarshalExtJSON([]byte(test.query), false, &uq))
+				err = checkConstrainedUpdate(uq.Update, test.qo)
+			}
+
+			if test.expectError {
+				require.ErrorIs(t, err, ErrPolicyViolation)
+				return
+			}
+			require.Nil(t, err)
+		})
+	}
+}
+
+func TestMarshalRecord(t *testing.T) {
+	oid, err := bson.ObjectIDFromHex("5f1b2c3d4e5f6a7b8c9d0e1f")
+	require.Nil(t, err)
+	amount, err := bson.ParseDecimal128("1050.25")
+	require.Nil(t, err)
+
+	doc := bson.D{
+		{Key: "_id", Value: oid},
+		{Key: "created", Value: bson.NewDateTimeFromTime(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC))},
+		{Key: "amount", Value: amount},
+		{Key: "count", Value: int32(3)},
+	}
+
+	tt := []struct {
+		description    string
+		opts           []MongoOption
+		expectedRecord string
+	}{
+		{
+			description:    "When the default relaxed form is used, values are readable",
+			opts:           nil,
+			expectedRecord: "{\"_id\":{\"$oid\":\"5f1b2c3d4e5f6a7b8c9d0e1f\"},\"created\":{\"$date\":\"2024-01-01T00:00:00Z\"},\"amount\":{\"$numberDecimal\":\"1050.25\"},\"count\":3}",
+		},
+		{
+			description:    "When the canonical form is requested, every type is kept",
+			opts:           []MongoOption{WithCanonicalExtJSON()},
+			expectedRecord: "{\"_id\":{\"$oid\":\"5f1b2c3d4e5f6a7b8c9d0e1f\"},\"created\":{\"$date\":{\"$numberLong\":\"1704067200000\"}},\"amount\":{\"$numberDecimal\":\"1050.25\"},\"count\":{\"$numberInt\":\"3\"}}",
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			m := NewMongoDataSource(test.opts...)
+			record, err := m.marshalRecord(doc)
+			require.Nil(t, err)
+			require.Equal(t, test.expectedRecord, record)
+		})
+	}
+}
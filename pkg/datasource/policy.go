@@ -0,0 +1,174 @@
+package datasource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var ErrPolicyViolation = errors.New("policy violation")
+
+// JavaScriptOperators run server-side JavaScript and are denied by the
+// default policy.
+var JavaScriptOperators = []string{"$where", "$function", "$accumulator"}
+
+// WriteStages write the output of an aggregation and are denied by the
+// default policy to keep aggregate read-only.
+var WriteStages = []string{"$out", "$merge"}
+
+// CrossCollectionStages read documents from other collections, or again from
+// the same one, and are denied in pipelines of constrained queries.
+var CrossCollectionStages = []string{"$unionWith", "$lookup", "$graphLookup"}
+
+// Policy restricts what a model controlled query may do. It is checked
+// against filters, pipelines, projections and sorts before they are sent to
+// the server.
+type Policy struct {
+	// AllowedOperators is a whitelist of the $-prefixed operators and stages
+	// a query may use. Empty allows every operator that is not denied.
+	AllowedOperators []string
+	// DeniedOperators are never allowed, even when whitelisted.
+	DeniedOperators []string
+	// MaxRegexLength limits the length of regular expression patterns. Zero
+	// means unlimited.
+	MaxRegexLength int
+	// MaxTime bounds the server-side execution time of every query, sent as
+	// maxTimeMS. Zero means no limit beyond the context deadline.
+	MaxTime time.Duration
+	// AllowWrites permits methods that change data, such as insertOne and
+	// updateOne. Tools using them must also require approval.
+	AllowWrites bool
+}
+
+// DefaultPolicy denies server-side JavaScript and aggregation write stages,
+// and bounds every query to 30 seconds.
+func DefaultPolicy() *Policy {
+	denied := append([]string{}, JavaScriptOperators...)
+	denied = append(denied, WriteStages...)
+
+	return &Policy{
+		DeniedOperators: denied,
+		MaxTime:         30 * time.Second,
+	}
+}
+
+// Check walks the documents and returns an error wrapping ErrPolicyViolation
+// for the first operator or regular expression the policy does not allow.
+func (p *Policy) Check(docs ...interface{}) error {
+	if p == nil {
+		return nil
+	}
+
+	for _, doc := range docs {
+		err := p.check(doc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckWrite returns an error wrapping ErrPolicyViolation unless the policy
+// allows writes.
+func (p *Policy) CheckWrite(method string) error {
+	if p == nil || p.AllowWrites {
+		return nil
+	}
+
+	return fmt.Errorf("%w: method %s writes data and writes are not allowed", ErrPolicyViolation, method)
+}
+
+// WithTimeout bounds ctx by MaxTime. The driver turns the deadline into
+// maxTimeMS on the command sent to the server.
+func (p *Policy) WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p == nil || p.MaxTime <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, p.MaxTime)
+}
+
+func (p *Policy) check(value interface{}) error {
+	switch v := value.(type) {
+	case bson.D:
+		for _, e := range v {
+			err := p.checkElement(e.Key, e.Value)
+			if err != nil {
+				return err
+			}
+		}
+	case bson.M:
+		for key, val := range v {
+			err := p.checkElement(key, val)
+			if err != nil {
+				return err
+			}
+		}
+	case bson.A:
+		for _, val := range v {
+			err := p.check(val)
+			if err != nil {
+				return err
+			}
+		}
+	case []bson.D:
+		for _, val := range v {
+			err := p.check(val)
+			if err != nil {
+				return err
+			}
+		}
+	case bson.Regex:
+		return p.checkRegex(v.Pattern)
+	}
+
+	return nil
+}
+
+func (p *Policy) checkElement(key string, value interface{}) error {
+	if strings.HasPrefix(key, "$") {
+		err := p.checkOperator(key)
+		if err != nil {
+			return err
+		}
+
+		if pattern, ok := value.(string); ok && key == "$regex" {
+			return p.checkRegex(pattern)
+		}
+	}
+
+	return p.check(value)
+}
+
+func (p *Policy) checkOperator(op string) error {
+	for _, denied := range p.DeniedOperators {
+		if op == denied {
+			return fmt.Errorf("%w: operator %s is not allowed", ErrPolicyViolation, op)
+		}
+	}
+
+	if len(p.AllowedOperators) == 0 {
+		return nil
+	}
+
+	for _, allowed := range p.AllowedOperators {
+		if op == allowed {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: operator %s is not in the allowed operators", ErrPolicyViolation, op)
+}
+
+func (p *Policy) checkRegex(pattern string) error {
+	if p.MaxRegexLength > 0 && len(pattern) > p.MaxRegexLength {
+		return fmt.Errorf("%w: regular expression of length %d exceeds the maximum of %d", ErrPolicyViolation, len(pattern), p.MaxRegexLength)
+	}
+
+	return nil
+}
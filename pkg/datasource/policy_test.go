@@ -0,0 +1,130 @@
+package datasource
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	tt := []struct {
+		description string
+		policy      *Policy
+		query       string
+		expectError bool
+	}{
+		{
+			description: "When the filter only uses plain fields, it is allowed",
+			policy:      DefaultPolicy(),
+			query:       "{ \"branch\": \"madrid\" }",
+			expectError: false,
+		},
+		{
+			description: "When the filter uses $where, it is denied",
+			policy:      DefaultPolicy(),
+			query:       "{ \"$where\": \"this.balance > 0\" }",
+			expectError: true,
+		},
+		{
+			description: "When $function is nested inside $expr, it is denied",
+			policy:      DefaultPolicy(),
+			query:       "{ \"$expr\": { \"$and\": [ { \"$function\": { \"body\": \"function() { return true }\", \"args\": [], \"lang\": \"js\" } } ] } }",
+			expectError: true,
+		},
+		{
+			description: "When an operator is in the whitelist, it is allowed",
+			policy:      &Policy{AllowedOperators: []string{"$in", "$gte"}},
+			query:       "{ \"branch\": { \"$in\": [ \"madrid\", \"zurich\" ] }, \"balance\": { \"$gte\": 10 } }",
+			expectError: false,
+		},
+		{
+			description: "When an operator is not in the whitelist, it is denied",
+			policy:      &Policy{AllowedOperators: []string{"$in"}},
+			query:       "{ \"balance\": { \"$gte\": 10 } }",
+			expectError: true,
+		},
+		{
+			description: "When an operator is both whitelisted and denied, it is denied",
+			policy:      &Policy{AllowedOperators: []string{"$where"}, DeniedOperators: []string{"$where"}},
+			query:       "{ \"$where\": \"true\" }",
+			expectError: true,
+		},
+		{
+			description: "When a $regex pattern exceeds the maximum length, it is denied",
+			policy:      &Policy{MaxRegexLength: 5},
+			query:       "{ \"name\": { \"$regex\": \"(a+)+$b\" } }",
+			expectError: true,
+		},
+		{
+			description: "When a regular expression literal exceeds the maximum length, it is denied",
+			policy:      &Policy{MaxRegexLength: 5},
+			query:       "{ \"name\": { \"$regularExpression\": { \"pattern\": \"(a+)+$b\", \"options\": \"\" } } }",
+			expectError: true,
+		},
+		{
+			description: "When a regular expression is within the maximum length, it is allowed",
+			policy:      &Policy{MaxRegexLength: 5},
+			query:       "{ \"name\": { \"$regex\": \"^ab\" } }",
+			expectError: false,
+		},
+		{
+			description: "When there is no policy, everything is allowed",
+			policy:      nil,
+			query:       "{ \"$where\": \"true\" }",
+			expectError: false,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			var filter bson.D
+			err := bson.UnmarshalExtJSON([]byte(test.query), false, &filter)
+			require.Nil(t, err)
+
+			err = test.policy.Check(filter)
+			if test.expectError {
+				require.ErrorIs(t, err, ErrPolicyViolation)
+				return
+			}
+
+			require.Nil(t, err)
+		})
+	}
+}
+
+func TestPolicyWithTimeout(t *testing.T) {
+	tt := []struct {
+		description      string
+		policy           *Policy
+		expectedDeadline bool
+	}{
+		{
+			description:      "When MaxTime is set, the context gets a deadline",
+			policy:           &Policy{MaxTime: time.Second},
+			expectedDeadline: true,
+		},
+		{
+			description:      "When MaxTime is not set, the context has no deadline",
+			policy:           &Policy{},
+			expectedDeadline: false,
+		},
+		{
+			description:      "When there is no policy, the context has no deadline",
+			policy:           nil,
+			expectedDeadline: false,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			ctx, cancel := test.policy.WithTimeout(context.Background())
+			defer cancel()
+
+			_, ok := ctx.Deadline()
+			require.Equal(t, test.expectedDeadline, ok)
+		})
+	}
+}
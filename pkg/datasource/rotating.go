@@ -0,0 +1,195 @@
+package datasource
+
+import (
+	"context"
+	"doppelganger/pkg/secret"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var ErrNotConnected = errors.New("data source is not connected")
+
+// Rotating is connected with a reference to a secret connection string,
+// such as secret://mongo-prod, and reconnects whenever the value it
+// resolves to changes, so rotated credentials are picked up without a
+// restart. Queries running on the old connection finish before it closes.
+type Rotating struct {
+	newSource func() DataSource
+	resolve   func(ctx context.Context, ref string) (string, error)
+	interval  time.Duration
+	logger    *slog.Logger
+	typ       string
+	// unused is the source made to learn the type, connected first
+	unused DataSource
+
+	// refreshing makes concurrent refreshes connect once
+	refreshing sync.Mutex
+	mu         sync.RWMutex
+	ref        string
+	current    *connection
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+type connection struct {
+	source  DataSource
+	value   string
+	queries sync.WaitGroup
+}
+
+type RotatingOption func(*Rotating)
+
+// WithRefreshLogger logs the failures of the checks made every interval to
+// l instead of slog.Default().
+func WithRefreshLogger(l *slog.Logger) RotatingOption {
+	return func(r *Rotating) {
+		r.logger = l
+	}
+}
+
+// NewRotating returns a data source connecting the sources made by
+// newSource with the value resolve returns for the connection string,
+// checking it again every interval. An interval of zero only resolves it
+// when connecting and on Refresh.
+func NewRotating(newSource func() DataSource, resolve func(ctx context.Context, ref string) (string, error), interval time.Duration, opts ...RotatingOption) *Rotating {
+	source := newSource()
+	r := &Rotating{
+		newSource: newSource,
+		resolve:   resolve,
+		interval:  interval,
+		logger:    slog.Default(),
+		typ:       source.Type(),
+		unused:    source,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+func (r *Rotating) Connect(ctx context.Context, connectionString string) error {
+	r.mu.Lock()
+	r.ref = connectionString
+	r.mu.Unlock()
+
+	err := r.Refresh(ctx)
+	if err != nil {
+		return err
+	}
+
+	if r.interval > 0 {
+		loopCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+		r.stop = stop
+		r.done = make(chan struct{})
+		go r.watch(loopCtx)
+	}
+
+	return nil
+}
+
+func (r *Rotating) watch(ctx context.Context) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// The last good connection is kept until the secret resolves
+			// and connects again
+			err := r.Refresh(ctx)
+			if err != nil {
+				r.mu.RLock()
+				ref := r.ref
+				r.mu.RUnlock()
+				r.logger.WarnContext(ctx, "refreshing datasource connection failed", "type", r.typ, "ref", ref, "error", err)
+			}
+		}
+	}
+}
+
+// Refresh resolves the connection string again and, when it changed,
+// connects a new source and closes the old one once its queries finish.
+// Errors show the reference rather than the connection string.
+func (r *Rotating) Refresh(ctx context.Context) error {
+	r.refreshing.Lock()
+	defer r.refreshing.Unlock()
+
+	r.mu.RLock()
+	ref, current := r.ref, r.current
+	r.mu.RUnlock()
+
+	value, err := r.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if current != nil && current.value == value {
+		return nil
+	}
+
+	next := &connection{source: r.unused, value: value}
+	if next.source == nil {
+		next.source = r.newSource()
+	}
+	r.unused = nil
+	err = next.source.Connect(ctx, value)
+	if err != nil {
+		// Sources may hold resources even when they failed to connect
+		next.source.Close(ctx)
+		return secret.Redact(err, value, ref)
+	}
+
+	r.mu.Lock()
+	previous := r.current
+	r.current = next
+	r.mu.Unlock()
+
+	if previous == nil {
+		return nil
+	}
+	previous.queries.Wait()
+	return secret.Redact(previous.source.Close(ctx), previous.value, ref)
+}
+
+func (r *Rotating) Close(ctx context.Context) error {
+	if r.stop != nil {
+		r.stop()
+		<-r.done
+	}
+
+	r.mu.Lock()
+	current := r.current
+	r.current = nil
+	r.mu.Unlock()
+
+	if current == nil {
+		return nil
+	}
+	current.queries.Wait()
+	return current.source.Close(ctx)
+}
+
+func (r *Rotating) Query(ctx context.Context, database, method, collection, query string, opts ...QueryOption) ([]string, error) {
+	r.mu.RLock()
+	current := r.current
+	if current == nil {
+		r.mu.RUnlock()
+		return nil, ErrNotConnected
+	}
+	current.queries.Add(1)
+	r.mu.RUnlock()
+	defer current.queries.Done()
+
+	return current.source.Query(ctx, database, method, collection, query, opts...)
+}
+
+func (r *Rotating) Type() string {
+	return r.typ
+}
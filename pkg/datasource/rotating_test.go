@@ -0,0 +1,172 @@
+package datasource
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSource struct {
+	mu         sync.Mutex
+	connection string
+	closed     bool
+	// connectError fails Connect, quoting the connection string like drivers do
+	connectError bool
+}
+
+func (r *recordingSource) Connect(ctx context.Context, connectionString string) error {
+	if r.connectError {
+		return fmt.Errorf("error parsing uri %q", connectionString)
+	}
+	r.connection = connectionString
+	return nil
+}
+
+func (r *recordingSource) Close(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+func (r *recordingSource) Query(ctx context.Context, database, method, collection, query string, opts ...QueryOption) ([]string, error) {
+	return []string{r.connection}, nil
+}
+
+func (r *recordingSource) Type() string {
+	return "recording"
+}
+
+func TestRotating(t *testing.T) {
+	ctx := context.Background()
+
+	var sources []*recordingSource
+	value := "mongodb://app:v1@db"
+	var resolveError error
+	r := NewRotating(func() DataSource {
+		s := &recordingSource{}
+		sources = append(sources, s)
+		return s
+	}, func(ctx context.Context, ref string) (string, error) {
+		require.Equal(t, "secret://mongo", ref)
+		return value, resolveError
+	}, 0)
+	require.Equal(t, "recording", r.Type())
+
+	_, err := r.Query(ctx, "", "find", "", "{}")
+	require.ErrorIs(t, err, ErrNotConnected)
+
+	err = r.Connect(ctx, "secret://mongo")
+	require.Nil(t, err)
+	res, err := r.Query(ctx, "", "find", "", "{}")
+	require.Nil(t, err)
+	require.Equal(t, []string{"mongodb://app:v1@db"}, res)
+
+	// Unchanged secrets keep the connection
+	err = r.Refresh(ctx)
+	require.Nil(t, err)
+	require.Len(t, sources, 1)
+
+	value = "mongodb://app:v2@db"
+	err = r.Refresh(ctx)
+	require.Nil(t, err)
+	res, err = r.Query(ctx, "", "find", "", "{}")
+	require.Nil(t, err)
+	require.Equal(t, []string{"mongodb://app:v2@db"}, res)
+	require.True(t, sources[0].closed)
+
+	// A failing secret keeps the last good connection
+	resolveError = errors.New("vault unavailable")
+	err = r.Refresh(ctx)
+	require.ErrorIs(t, err, resolveError)
+	res, err = r.Query(ctx, "", "find", "", "{}")
+	require.Nil(t, err)
+	require.Equal(t, []string{"mongodb://app:v2@db"}, res)
+
+	err = r.Close(ctx)
+	require.Nil(t, err)
+	require.True(t, sources[1].closed)
+}
+
+func TestRotatingConnectError(t *testing.T) {
+	ctx := context.Background()
+
+	var sources []*recordingSource
+	value := "mongodb://app:v1@db"
+	r := NewRotating(func() DataSource {
+		s := &recordingSource{connectError: value != "mongodb://app:v1@db"}
+		sources = append(sources, s)
+		return s
+	}, func(ctx context.Context, ref string) (string, error) {
+		return value, nil
+	}, 0)
+
+	err := r.Connect(ctx, "secret://mongo")
+	require.Nil(t, err)
+
+	// The source that did not connect is closed, and the error shows the
+	// reference instead of the password
+	value = "mongodb://app:hunter2@db"
+	err = r.Refresh(ctx)
+	require.EqualError(t, err, `error parsing uri "secret://mongo"`)
+	require.True(t, sources[1].closed)
+
+	res, err := r.Query(ctx, "", "find", "", "{}")
+	require.Nil(t, err)
+	require.Equal(t, []string{"mongodb://app:v1@db"}, res)
+
+	require.Nil(t, r.Close(ctx))
+}
+
+func TestRotatingLogsRefreshErrors(t *testing.T) {
+	var mu sync.Mutex
+	fail := false
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&syncWriter{mu: &mu, w: &logs}, nil))
+
+	r := NewRotating(func() DataSource {
+		return &recordingSource{}
+	}, func(ctx context.Context, ref string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			return "", errors.New("vault unavailable")
+		}
+		return "mongodb://app:v1@db", nil
+	}, 5*time.Millisecond, WithRefreshLogger(logger))
+
+	err := r.Connect(context.Background(), "secret://mongo")
+	require.Nil(t, err)
+
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return bytes.Contains(logs.Bytes(), []byte("refreshing datasource connection failed"))
+	}, time.Second, 5*time.Millisecond)
+	require.Nil(t, r.Close(context.Background()))
+
+	require.Contains(t, logs.String(), "ref=secret://mongo")
+	require.Contains(t, logs.String(), `error="vault unavailable"`)
+}
+
+type syncWriter struct {
+	mu *sync.Mutex
+	w  *bytes.Buffer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
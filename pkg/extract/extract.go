@@ -0,0 +1,161 @@
+package extract
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrUnsupportedContent = errors.New("unsupported content")
+
+// Extractor turns the raw bytes of a document into text that can be handed to
+// a model.
+type Extractor interface {
+	Extract(data []byte) (string, error)
+}
+
+type ExtractorFunc func(data []byte) (string, error)
+
+func (f ExtractorFunc) Extract(data []byte) (string, error) {
+	return f(data)
+}
+
+// FallbackFunc turns content that no extractor matches into text, knowing its
+// content type.
+type FallbackFunc func(contentType string, data []byte) (string, error)
+
+// Registry picks an Extractor for an object, first by content type and then
+// by file extension, falling back to a default when neither matches.
+type Registry struct {
+	contentTypes map[string]Extractor
+	extensions   map[string]Extractor
+	fallback     FallbackFunc
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		contentTypes: make(map[string]Extractor),
+		extensions:   make(map[string]Extractor),
+	}
+}
+
+// Default returns a registry that handles plain text, Markdown, HTML, PDF and
+// DOCX documents, and passes other text through with TextFallback.
+func Default() *Registry {
+	r := NewRegistry()
+
+	r.RegisterContentType("text/plain", PlainText)
+	r.RegisterContentType("text/markdown", Markdown)
+	r.RegisterContentType("text/x-markdown", Markdown)
+	r.RegisterContentType("text/html", HTML)
+	r.RegisterContentType("application/xhtml+xml", HTML)
+	r.RegisterContentType("application/pdf", PDF)
+	r.RegisterContentType("application/vnd.openxmlformats-officedocument.wordprocessingml.document", DOCX)
+
+	r.RegisterExtension(".txt", PlainText)
+	r.RegisterExtension(".md", Markdown)
+	r.RegisterExtension(".markdown", Markdown)
+	r.RegisterExtension(".html", HTML)
+	r.RegisterExtension(".htm", HTML)
+	r.RegisterExtension(".pdf", PDF)
+	r.RegisterExtension(".docx", DOCX)
+
+	r.SetFallbackFunc(TextFallback)
+
+	return r
+}
+
+func (r *Registry) RegisterContentType(contentType string, e Extractor) {
+	r.contentTypes[strings.ToLower(contentType)] = e
+}
+
+func (r *Registry) RegisterExtension(ext string, e Extractor) {
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	r.extensions[strings.ToLower(ext)] = e
+}
+
+// SetFallback sets the extractor used when nothing else matches. A nil
+// fallback makes unknown content an error.
+func (r *Registry) SetFallback(e Extractor) {
+	if e == nil {
+		r.fallback = nil
+		return
+	}
+
+	r.fallback = func(contentType string, data []byte) (string, error) {
+		return e.Extract(data)
+	}
+}
+
+// SetFallbackFunc sets the fallback to f, which is given the content type.
+func (r *Registry) SetFallbackFunc(f FallbackFunc) {
+	r.fallback = f
+}
+
+// Extract converts data to text using the extractor registered for the
+// content type or, failing that, the extension of name.
+func (r *Registry) Extract(contentType, name string, data []byte) (string, error) {
+	e := r.lookup(contentType, name)
+	if e != nil {
+		return e.Extract(data)
+	}
+	if r.fallback == nil {
+		return "", ErrUnsupportedContent
+	}
+
+	return r.fallback(contentType, data)
+}
+
+func (r *Registry) lookup(contentType, name string) Extractor {
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err == nil {
+			if e, ok := r.contentTypes[strings.ToLower(mediaType)]; ok {
+				return e
+			}
+		}
+	}
+
+	if e, ok := r.extensions[strings.ToLower(path.Ext(name))]; ok {
+		return e
+	}
+
+	return nil
+}
+
+var PlainText = ExtractorFunc(func(data []byte) (string, error) {
+	return strings.ToValidUTF8(string(data), "�"), nil
+})
+
+// TextFallback passes text through like PlainText: content of a text/*, JSON
+// or XML type, and content of no or a generic type that is valid UTF-8. Other
+// content, such as images and archives, returns ErrUnsupportedContent rather
+// than reaching the model as noise.
+var TextFallback = FallbackFunc(func(contentType string, data []byte) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "application/octet-stream" {
+		mediaType = ""
+	}
+	mediaType = strings.ToLower(mediaType)
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"), mediaType == "application/json", mediaType == "application/xml",
+		strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return PlainText(data)
+	case mediaType == "" && utf8.Valid(data):
+		return PlainText(data)
+	case mediaType == "":
+		return "", fmt.Errorf("%w: binary data", ErrUnsupportedContent)
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedContent, mediaType)
+})
+
+// Markdown is already readable by models, so it is only normalised like plain
+// text.
+var Markdown = PlainText
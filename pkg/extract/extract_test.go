@@ -0,0 +1,190 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryExtract(t *testing.T) {
+	plainFallback := NewRegistry()
+	plainFallback.SetFallback(PlainText)
+
+	tt := []struct {
+		description  string
+		registry     *Registry
+		contentType  string
+		name         string
+		data         []byte
+		expectError  bool
+		expectedText string
+	}{
+		{
+			description:  "When the content type is plain text, the content is returned as is",
+			registry:     Default(),
+			contentType:  "text/plain; charset=utf-8",
+			name:         "notes",
+			data:         []byte("Large deposits need approval"),
+			expectedText: "Large deposits need approval",
+		},
+		{
+			description:  "When the content type is HTML, the visible text is returned",
+			registry:     Default(),
+			contentType:  "text/html",
+			name:         "policy",
+			data:         []byte("<html><head><title>x</title><style>p{}</style></head><body><h1>Deposits</h1><p>Over   <b>10k</b> needs approval.</p><script>alert(1)</script></body></html>"),
+			expectedText: "Deposits\nOver 10k needs approval.",
+		},
+		{
+			description:  "When no content type is set, the extension picks the extractor",
+			registry:     Default(),
+			contentType:  "",
+			name:         "policies/deposits.HTML",
+			data:         []byte("<p>one</p><p>two</p>"),
+			expectedText: "one\ntwo",
+		},
+		{
+			description:  "When the content type is generic, the extension picks the extractor",
+			registry:     Default(),
+			contentType:  "application/octet-stream",
+			name:         "policies/deposits.docx",
+			data:         buildDOCX(t, "Deposits", "Over 10k needs approval."),
+			expectedText: "Deposits\nOver 10k needs approval.",
+		},
+		{
+			description:  "When the content is a PDF, the text is extracted",
+			registry:     Default(),
+			contentType:  "application/pdf",
+			name:         "deposits.pdf",
+			data:         buildPDF("Deposits need approval"),
+			expectedText: "Deposits need approval",
+		},
+		{
+			description:  "When nothing matches, the fallback extractor is used",
+			registry:     Default(),
+			contentType:  "application/octet-stream",
+			name:         "policy",
+			data:         []byte("raw"),
+			expectedText: "raw",
+		},
+		{
+			description:  "When nothing matches and the content is of another text type, it is returned as is",
+			registry:     Default(),
+			contentType:  "text/csv",
+			name:         "rates",
+			data:         []byte("currency,rate\nEUR,1"),
+			expectedText: "currency,rate\nEUR,1",
+		},
+		{
+			description:  "When nothing matches and the content is JSON, it is returned as is",
+			registry:     Default(),
+			contentType:  "application/json",
+			name:         "limits",
+			data:         []byte(`{"daily":1000}`),
+			expectedText: `{"daily":1000}`,
+		},
+		{
+			description: "When nothing matches and the content is of a binary type, an error is returned",
+			registry:    Default(),
+			contentType: "image/png",
+			name:        "signature",
+			data:        []byte("\x89PNG\r\n"),
+			expectError: true,
+		},
+		{
+			description: "When nothing matches and generic content is not valid UTF-8, an error is returned",
+			registry:    Default(),
+			contentType: "application/octet-stream",
+			name:        "archive",
+			data:        []byte{0x50, 0x4b, 0x03, 0x04, 0xff, 0xfe},
+			expectError: true,
+		},
+		{
+			description:  "When the fallback is plain text, content of any type is returned as text",
+			registry:     plainFallback,
+			contentType:  "image/png",
+			name:         "signature",
+			data:         []byte("raw"),
+			expectedText: "raw",
+		},
+		{
+			description: "When nothing matches and there is no fallback, an error is returned",
+			registry:    NewRegistry(),
+			contentType: "application/octet-stream",
+			name:        "policy",
+			data:        []byte("raw"),
+			expectError: true,
+		},
+		{
+			description: "When a PDF is malformed, an error is returned",
+			registry:    Default(),
+			contentType: "application/pdf",
+			name:        "broken.pdf",
+			data:        []byte("%PDF-1.4 not really"),
+			expectError: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			text, err := test.registry.Extract(test.contentType, test.name, test.data)
+			if test.expectError {
+				require.NotNil(t, err)
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, test.expectedText, text)
+		})
+	}
+}
+
+func buildDOCX(t *testing.T, paragraphs ...string) []byte {
+	var body bytes.Buffer
+	for _, p := range paragraphs {
+		fmt.Fprintf(&body, "<w:p><w:r><w:t>%s</w:t></w:r></w:p>", p)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("word/document.xml")
+	require.Nil(t, err)
+	_, err = fmt.Fprintf(w, "<?xml version=\"1.0\"?><w:document xmlns:w=\"http://schemas.openxmlformats.org/wordprocessingml/2006/main\"><w:body>%s</w:body></w:document>", body.String())
+	require.Nil(t, err)
+	require.Nil(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+// buildPDF writes a single page PDF showing text, computing the xref offsets
+// as it goes.
+func buildPDF(text string) []byte {
+	content := fmt.Sprintf("BT /F1 12 Tf 72 712 Td (%s) Tj ET", text)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,37 @@
+package extract
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/ledongthuc/pdf"
+)
+
+var PDF = ExtractorFunc(extractPDF)
+
+func extractPDF(data []byte) (text string, err error) {
+	// The PDF reader panics on some malformed documents
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid pdf: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+
+	textReader, err := reader.GetPlainText()
+	if err != nil {
+		return "", err
+	}
+
+	textBytes, err := io.ReadAll(textReader)
+	if err != nil {
+		return "", err
+	}
+
+	return tidyLines(string(textBytes)), nil
+}
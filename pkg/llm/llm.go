@@ -2,8 +2,10 @@ package llm
 
 import (
 	"errors"
+	"net/http"
 	"strings"
 
+	"github.com/tmc/langchaingo/embeddings"
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/llms/anthropic"
 	"github.com/tmc/langchaingo/llms/openai"
@@ -11,12 +13,116 @@ import (
 
 var ErrModelNotFound = errors.New("model not found")
 
+// Provider names, as passed to a KeyFunc.
+const (
+	OpenAI    = "openai"
+	Anthropic = "anthropic"
+)
+
+// KeyFunc returns the API key of a provider. An empty key falls back to the
+// environment variable of the provider.
+type KeyFunc func(provider string) (string, error)
+
+// GetProvider creates model, reading the API key of its provider from the
+// environment. Calls are retried with DefaultRetries and DefaultBackoff.
 func GetProvider(model string) (llms.Model, error) {
+	return NewProviderGenerator(func(provider string) (string, error) {
+		return "", nil
+	})(model)
+}
+
+// NewProviderGenerator creates models like GetProvider, with the API keys
+// returned by keys. Keys are looked up for every model created, so rotated
+// keys are used by the next decision.
+func NewProviderGenerator(keys KeyFunc, opts ...GeneratorOption) func(model string) (llms.Model, error) {
+	o := newGeneratorOptions(opts)
+
+	var generate func(model string, fallback string) (llms.Model, error)
+	generate = func(model string, fallback string) (llms.Model, error) {
+		provider := ProviderOf(model)
+		if provider == "" {
+			return nil, ErrModelNotFound
+		}
+
+		key, err := keys(provider)
+		if err != nil {
+			return nil, err
+		}
+
+		m, err := newProvider(model, key)
+		if err != nil {
+			return nil, err
+		}
+
+		return &retryingModel{
+			name:     model,
+			model:    m,
+			retries:  o.retries,
+			backoff:  o.backoff,
+			fallback: fallback,
+			newFallback: func(model string) (llms.Model, error) {
+				return generate(model, "")
+			},
+		}, nil
+	}
+
+	return func(model string) (llms.Model, error) {
+		return generate(model, o.fallbacks[model])
+	}
+}
+
+// ProviderOf returns the name of the provider serving model, or "" when no
+// provider does.
+func ProviderOf(model string) string {
 	if strings.HasPrefix(model, "gpt") {
-		return openai.New(openai.WithModel(model))
+		return OpenAI
 	} else if strings.HasPrefix(model, "claude") {
-		return anthropic.New(anthropic.WithModel(model))
+		return Anthropic
+	}
+
+	return ""
+}
+
+func newProvider(model, key string) (reportingModel, error) {
+	client := reportingClient{client: http.DefaultClient}
+
+	var provider llms.Model
+	var err error
+	switch ProviderOf(model) {
+	case OpenAI:
+		opts := []openai.Option{openai.WithModel(model), openai.WithHTTPClient(client)}
+		if key != "" {
+			opts = append(opts, openai.WithToken(key))
+		}
+		provider, err = openai.New(opts...)
+	case Anthropic:
+		opts := []anthropic.Option{anthropic.WithModel(model), anthropic.WithHTTPClient(client)}
+		if key != "" {
+			opts = append(opts, anthropic.WithToken(key))
+		}
+		provider, err = anthropic.New(opts...)
+	default:
+		return reportingModel{}, ErrModelNotFound
+	}
+	if err != nil {
+		return reportingModel{}, err
+	}
+
+	return reportingModel{Model: provider}, nil
+}
+
+// NewEmbedder creates an embedder for an OpenAI embedding model, such as
+// text-embedding-3-small. An empty key falls back to OPENAI_API_KEY.
+func NewEmbedder(model, key string) (embeddings.Embedder, error) {
+	opts := []openai.Option{openai.WithEmbeddingModel(model)}
+	if key != "" {
+		opts = append(opts, openai.WithToken(key))
+	}
+
+	client, err := openai.New(opts...)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, ErrModelNotFound
+	return embeddings.NewEmbedder(client)
 }
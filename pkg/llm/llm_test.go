@@ -1,11 +1,14 @@
 package llm
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
+var errNoKey = errors.New("no key")
+
 func TestGetProvider(t *testing.T) {
 	tt := []struct {
 		description   string
@@ -36,3 +39,53 @@ func TestGetProvider(t *testing.T) {
 		})
 	}
 }
+
+func TestNewProviderGenerator(t *testing.T) {
+	tt := []struct {
+		description      string
+		model            string
+		keyError         error
+		expectedProvider string
+		expectedError    error
+	}{
+		{
+			description:      "When an OpenAI model is created, the key of openai is used",
+			model:            "gpt-4.1",
+			expectedProvider: OpenAI,
+		},
+		{
+			description:      "When an Anthropic model is created, the key of anthropic is used",
+			model:            "claude-sonnet-4-20250514",
+			expectedProvider: Anthropic,
+		},
+		{
+			description:      "When the key cannot be resolved, its error is returned",
+			model:            "gpt-4.1",
+			keyError:         errNoKey,
+			expectedProvider: OpenAI,
+			expectedError:    errNoKey,
+		},
+		{
+			description:   "When no provider serves the model, no key is looked up",
+			model:         "gemini2.5-pro",
+			expectedError: ErrModelNotFound,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			var requested string
+			generate := NewProviderGenerator(func(provider string) (string, error) {
+				requested = provider
+				return "test-key", test.keyError
+			})
+
+			model, err := generate(test.model)
+			require.Equal(t, test.expectedProvider, requested)
+			require.ErrorIs(t, err, test.expectedError)
+			if test.expectedError == nil {
+				require.NotNil(t, model)
+			}
+		})
+	}
+}
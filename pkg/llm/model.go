@@ -0,0 +1,125 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/tmc/langchaingo/llms"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// ResponseModelInfo is the key of the GenerationInfo of a choice holding the
+// model that answered, as named by the API. It is the dated version, such as
+// gpt-4.1-2025-04-14, when an alias such as gpt-4.1 was requested.
+const ResponseModelInfo = "ResponseModel"
+
+// ResponseModel returns the model that answered res, or "" when its provider
+// does not report it.
+func ResponseModel(res *llms.ContentResponse) string {
+	if res == nil {
+		return ""
+	}
+
+	for _, choice := range res.Choices {
+		if model, ok := choice.GenerationInfo[ResponseModelInfo].(string); ok && model != "" {
+			return model
+		}
+	}
+
+	return ""
+}
+
+// exchange is what the client of a provider saw of its last request, which
+// the providers do not return.
+type exchange struct {
+	// sent is true once a request was made, and failed when it got no
+	// response.
+	sent   bool
+	failed bool
+	status int
+	// retryAfter is how long the provider asked to wait before trying again.
+	retryAfter time.Duration
+	// model is the model named by a JSON response.
+	model string
+}
+
+type exchangeKey struct{}
+
+// reportingClient sends the requests of a provider, and keeps what it sees of
+// the response in the exchange of the request context.
+type reportingClient struct {
+	client *http.Client
+}
+
+func (c reportingClient) Do(req *http.Request) (*http.Response, error) {
+	res, err := c.client.Do(req)
+	ex, ok := req.Context().Value(exchangeKey{}).(*exchange)
+	if !ok {
+		return res, err
+	}
+
+	*ex = exchange{sent: true, failed: err != nil}
+	if err != nil {
+		return res, err
+	}
+
+	ex.status = res.StatusCode
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		ex.retryAfter = time.Duration(seconds) * time.Second
+	}
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+		return res, nil
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	var payload struct {
+		Model string `json:"model"`
+	}
+	if json.Unmarshal(body, &payload) == nil {
+		ex.model = payload.Model
+	}
+
+	return res, nil
+}
+
+// reportingModel adds the model that answered to the GenerationInfo of every
+// choice, under ResponseModelInfo.
+type reportingModel struct {
+	llms.Model
+}
+
+func (m reportingModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	res, _, err := m.generate(ctx, messages, options...)
+	return res, err
+}
+
+// generate calls the model, and returns what the client saw of the call.
+func (m reportingModel) generate(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, exchange, error) {
+	var ex exchange
+	res, err := m.Model.GenerateContent(context.WithValue(ctx, exchangeKey{}, &ex), messages, options...)
+	if err != nil || ex.model == "" {
+		return res, ex, err
+	}
+
+	for _, choice := range res.Choices {
+		if choice.GenerationInfo == nil {
+			choice.GenerationInfo = make(map[string]any)
+		}
+		choice.GenerationInfo[ResponseModelInfo] = ex.model
+	}
+
+	return res, ex, nil
+}
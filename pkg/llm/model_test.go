@@ -0,0 +1,65 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/anthropic"
+	"github.com/tmc/langchaingo/llms/openai"
+)
+
+func TestResponseModel(t *testing.T) {
+	tt := []struct {
+		description   string
+		response      string
+		newProvider   func(url string, client reportingClient) (llms.Model, error)
+		expectedModel string
+	}{
+		{
+			description: "When OpenAI answers, the dated model it names is reported",
+			response:    `{"id":"1","object":"chat.completion","model":"gpt-4.1-2025-04-14","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`,
+			newProvider: func(url string, client reportingClient) (llms.Model, error) {
+				return openai.New(openai.WithModel("gpt-4.1"), openai.WithToken("test-key"), openai.WithBaseURL(url), openai.WithHTTPClient(client))
+			},
+			expectedModel: "gpt-4.1-2025-04-14",
+		},
+		{
+			description: "When Anthropic answers, the dated model it names is reported",
+			response:    `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`,
+			newProvider: func(url string, client reportingClient) (llms.Model, error) {
+				return anthropic.New(anthropic.WithModel("claude-sonnet-4-0"), anthropic.WithToken("test-key"), anthropic.WithBaseURL(url), anthropic.WithHTTPClient(client))
+			},
+			expectedModel: "claude-sonnet-4-20250514",
+		},
+		{
+			description: "When the response does not name a model, none is reported",
+			response:    `{"id":"1","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`,
+			newProvider: func(url string, client reportingClient) (llms.Model, error) {
+				return openai.New(openai.WithModel("gpt-4.1"), openai.WithToken("test-key"), openai.WithBaseURL(url), openai.WithHTTPClient(client))
+			},
+			expectedModel: "",
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(test.response))
+			}))
+			defer server.Close()
+
+			provider, err := test.newProvider(server.URL, reportingClient{client: server.Client()})
+			require.Nil(t, err)
+
+			res, err := reportingModel{Model: provider}.GenerateContent(context.Background(), []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "hi")})
+			require.Nil(t, err)
+			require.Equal(t, "hi", res.Choices[0].Content)
+			require.Equal(t, test.expectedModel, ResponseModel(res))
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package llm
+
+import (
+	"context"
+	"doppelganger/pkg/metrics"
+	"net/http"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Defaults of the models created by GetProvider and NewProviderGenerator.
+const (
+	DefaultRetries = 2
+	DefaultBackoff = 500 * time.Millisecond
+	// maxBackoff bounds the wait between attempts, including the wait asked
+	// for by a provider.
+	maxBackoff = 30 * time.Second
+)
+
+// GeneratorOption configures the models created by NewProviderGenerator.
+type GeneratorOption func(*generatorOptions)
+
+type generatorOptions struct {
+	retries   int
+	backoff   time.Duration
+	fallbacks map[string]string
+}
+
+// WithRetries makes models try a call again up to retries times when the
+// provider fails it for a passing reason: it is rate limited, overloaded or
+// unreachable. The wait doubles from backoff after every attempt, unless the
+// provider asks for a longer one. Zero retries turns retrying off.
+func WithRetries(retries int, backoff time.Duration) GeneratorOption {
+	return func(o *generatorOptions) {
+		o.retries = retries
+		o.backoff = backoff
+	}
+}
+
+// WithFallback makes model hand a call over to fallback when its retries
+// are used up. The fallback model does not fall back itself.
+func WithFallback(model, fallback string) GeneratorOption {
+	return func(o *generatorOptions) {
+		o.fallbacks[model] = fallback
+	}
+}
+
+func newGeneratorOptions(opts []GeneratorOption) *generatorOptions {
+	o := &generatorOptions{
+		retries:   DefaultRetries,
+		backoff:   DefaultBackoff,
+		fallbacks: make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// retryingModel retries the calls of a model, then falls back to another.
+// Both are recorded with the metrics.Recorder of the call's context.
+type retryingModel struct {
+	name     string
+	model    reportingModel
+	retries  int
+	backoff  time.Duration
+	fallback string
+	// newFallback creates the fallback model.
+	newFallback func(model string) (llms.Model, error)
+}
+
+func (m *retryingModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	recorder := metrics.FromContext(ctx)
+
+	for attempt := 0; ; attempt++ {
+		res, ex, err := m.model.generate(ctx, messages, options...)
+		if err == nil || !transient(ctx, ex) {
+			return res, err
+		}
+
+		if attempt == m.retries {
+			if m.fallback == "" {
+				return nil, err
+			}
+
+			fallback, fallbackErr := m.newFallback(m.fallback)
+			if fallbackErr != nil {
+				return nil, err
+			}
+
+			recorder.Fallback(m.name, m.fallback)
+			return fallback.GenerateContent(ctx, messages, options...)
+		}
+
+		wait := min(m.backoff<<attempt, maxBackoff)
+		if ex.retryAfter > wait {
+			wait = min(ex.retryAfter, maxBackoff)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+
+		recorder.Retry(m.name)
+	}
+}
+
+func (m *retryingModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+// transient tells whether a failed call may succeed when made again: the
+// provider did not answer, is rate limited or overloaded. Calls the caller
+// gave up on are not made again.
+func transient(ctx context.Context, ex exchange) bool {
+	if ctx.Err() != nil || !ex.sent {
+		return false
+	}
+	if ex.failed {
+		return true
+	}
+
+	switch ex.status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case 529:
+		// Anthropic is overloaded
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,138 @@
+package llm
+
+import (
+	"context"
+	"doppelganger/pkg/metrics"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/openai"
+)
+
+// countingRecorder counts the retries and fallbacks recorded.
+type countingRecorder struct {
+	metrics.Nop
+	mu        sync.Mutex
+	retries   []string
+	fallbacks []string
+}
+
+func (r *countingRecorder) Retry(model string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retries = append(r.retries, model)
+}
+
+func (r *countingRecorder) Fallback(from, to string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallbacks = append(r.fallbacks, from+" -> "+to)
+}
+
+func TestRetryingModel(t *testing.T) {
+	tt := []struct {
+		description       string
+		statuses          []int
+		fallback          string
+		expectedError     bool
+		expectedAnswer    string
+		expectedRetries   []string
+		expectedFallbacks []string
+	}{
+		{
+			description:     "When the provider is rate limited once, the call is retried",
+			statuses:        []int{http.StatusTooManyRequests, http.StatusOK},
+			expectedAnswer:  "answered by gpt-4.1",
+			expectedRetries: []string{"gpt-4.1"},
+		},
+		{
+			description:       "When the retries are used up, the call falls back to the other model",
+			statuses:          []int{http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusBadGateway},
+			fallback:          "gpt-4.1-mini",
+			expectedAnswer:    "answered by gpt-4.1-mini",
+			expectedRetries:   []string{"gpt-4.1", "gpt-4.1"},
+			expectedFallbacks: []string{"gpt-4.1 -> gpt-4.1-mini"},
+		},
+		{
+			description:     "When the retries are used up and there is no fallback, the error is returned",
+			statuses:        []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			expectedError:   true,
+			expectedRetries: []string{"gpt-4.1", "gpt-4.1"},
+		},
+		{
+			description:   "When the request is refused, the call is neither retried nor falls back",
+			statuses:      []int{http.StatusBadRequest},
+			fallback:      "gpt-4.1-mini",
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			var mu sync.Mutex
+			statuses := test.statuses
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req struct {
+					Model string `json:"model"`
+				}
+				json.NewDecoder(r.Body).Decode(&req)
+
+				// The primary model answers with the statuses in turn, the
+				// fallback always answers
+				status := http.StatusOK
+				if req.Model == "gpt-4.1" {
+					mu.Lock()
+					status, statuses = statuses[0], statuses[1:]
+					mu.Unlock()
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(status)
+				if status != http.StatusOK {
+					w.Write([]byte(`{"error":{"message":"failed"}}`))
+					return
+				}
+				fmt.Fprintf(w, `{"id":"1","object":"chat.completion","model":%q,"choices":[{"index":0,"message":{"role":"assistant","content":"answered by %s"},"finish_reason":"stop"}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`, req.Model, req.Model)
+			}))
+			defer server.Close()
+
+			newModel := func(model string) (reportingModel, error) {
+				provider, err := openai.New(openai.WithModel(model), openai.WithToken("test-key"), openai.WithBaseURL(server.URL), openai.WithHTTPClient(reportingClient{client: server.Client()}))
+				return reportingModel{Model: provider}, err
+			}
+
+			primary, err := newModel("gpt-4.1")
+			require.Nil(t, err)
+
+			m := &retryingModel{
+				name:     "gpt-4.1",
+				model:    primary,
+				retries:  2,
+				backoff:  time.Millisecond,
+				fallback: test.fallback,
+				newFallback: func(model string) (llms.Model, error) {
+					return newModel(model)
+				},
+			}
+
+			recorder := &countingRecorder{}
+			ctx := metrics.WithRecorder(context.Background(), recorder)
+			res, err := m.GenerateContent(ctx, []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "hi")})
+			if test.expectedError {
+				require.NotNil(t, err)
+			} else {
+				require.Nil(t, err)
+				require.Equal(t, test.expectedAnswer, res.Choices[0].Content)
+			}
+			require.Empty(t, statuses)
+			require.Equal(t, test.expectedRetries, recorder.retries)
+			require.Equal(t, test.expectedFallbacks, recorder.fallbacks)
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package mcp
+
+import (
+	"context"
+	"doppelganger/pkg/datasource"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var ErrToolFailed = errors.New("mcp tool failed")
+
+// Client connects to an MCP server and is a data source whose methods are the
+// tools of the server. The query is the JSON object of arguments, and every
+// content item of the result is a record.
+type Client struct {
+	transport  transport
+	httpClient *http.Client
+	headers    http.Header
+	env        []string
+	info       implementation
+	nextID     atomic.Int64
+
+	// ServerName and ServerVersion describe the server once connected
+	ServerName    string
+	ServerVersion string
+}
+
+type ClientOption func(*Client)
+
+// WithClientHeader adds a header, such as an API key, to every request sent to
+// servers reached by URL.
+func WithClientHeader(key, value string) ClientOption {
+	return func(c *Client) {
+		c.headers.Add(key, value)
+	}
+}
+
+func WithClientHTTPClient(hc *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithEnv adds KEY=value variables to the environment of servers started as
+// a command.
+func WithEnv(env ...string) ClientOption {
+	return func(c *Client) {
+		c.env = append(c.env, env...)
+	}
+}
+
+func NewClient(opts ...ClientOption) *Client {
+	c := &Client{
+		httpClient: http.DefaultClient,
+		headers:    make(http.Header),
+		info:       implementation{Name: "doppelganger", Version: "1.0.0"},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+func (c *Client) Type() string {
+	return "mcp"
+}
+
+// Connect reaches the server at an http or https URL, or otherwise starts the
+// connection string as a command and talks to it over stdio, and then
+// initializes the session.
+func (c *Client) Connect(ctx context.Context, connectionString string) error {
+	if strings.HasPrefix(connectionString, "http://") || strings.HasPrefix(connectionString, "https://") {
+		c.transport = &httpTransport{
+			url:     connectionString,
+			client:  c.httpClient,
+			headers: c.headers,
+		}
+	} else {
+		t, err := c.startCommand(connectionString)
+		if err != nil {
+			return err
+		}
+		c.transport = t
+	}
+
+	return c.connect(ctx)
+}
+
+func (c *Client) connect(ctx context.Context) error {
+	err := c.initialize(ctx)
+	if err != nil {
+		c.transport.close()
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) startCommand(commandLine string) (transport, error) {
+	fields := strings.Fields(commandLine)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("mcp: missing command")
+	}
+
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.Env = append(os.Environ(), c.env...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("mcp: starting %s: %w", fields[0], err)
+	}
+
+	// Closing stdin asks the server to exit, which it is given a few
+	// seconds to do
+	closer := func() error {
+		stdin.Close()
+
+		done := make(chan error, 1)
+		go func() {
+			done <- cmd.Wait()
+		}()
+
+		select {
+		case <-done:
+			return nil
+		case <-time.After(5 * time.Second):
+			cmd.Process.Kill()
+			return <-done
+		}
+	}
+
+	return newStdioTransport(stdout, stdin, closer), nil
+}
+
+func (c *Client) initialize(ctx context.Context) error {
+	var result initializeResult
+	err := c.call(ctx, "initialize", initializeParams{
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    map[string]interface{}{},
+		ClientInfo:      c.info,
+	}, &result)
+	if err != nil {
+		return err
+	}
+
+	if !supportedVersions[result.ProtocolVersion] {
+		return fmt.Errorf("mcp: unsupported protocol version %q", result.ProtocolVersion)
+	}
+	if _, ok := result.Capabilities["tools"]; !ok {
+		return fmt.Errorf("mcp: server %s does not offer tools", result.ServerInfo.Name)
+	}
+
+	c.ServerName = result.ServerInfo.Name
+	c.ServerVersion = result.ServerInfo.Version
+	c.transport.setProtocolVersion(result.ProtocolVersion)
+
+	return c.notify(ctx, "notifications/initialized")
+}
+
+func (c *Client) Close(ctx context.Context) error {
+	if c.transport == nil {
+		return nil
+	}
+
+	return c.transport.close()
+}
+
+// ListTools returns every tool offered by the server.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var tools []Tool
+
+	params := listToolsParams{}
+	for {
+		var result listToolsResult
+		err := c.call(ctx, "tools/list", params, &result)
+		if err != nil {
+			return nil, err
+		}
+
+		tools = append(tools, result.Tools...)
+		if result.NextCursor == "" {
+			return tools, nil
+		}
+		params.Cursor = result.NextCursor
+	}
+}
+
+// Query calls the tool named by method with the JSON arguments in query.
+// Text content is returned as is, other content as its JSON. A result marked
+// as an error is returned as an error wrapping ErrToolFailed.
+func (c *Client) Query(ctx context.Context, database, method, collection, query string, opts ...datasource.QueryOption) ([]string, error) {
+	if c.transport == nil {
+		return nil, fmt.Errorf("mcp: not connected")
+	}
+	if len(datasource.NewQueryOptions(opts...).Constraints) > 0 {
+		return nil, datasource.ErrConstraintsNotSupported
+	}
+
+	var arguments map[string]interface{}
+	if strings.TrimSpace(query) != "" {
+		err := json.Unmarshal([]byte(query), &arguments)
+		if err != nil {
+			return nil, fmt.Errorf("invalid arguments for %s: %w", method, err)
+		}
+	}
+
+	var result toolResult
+	err := c.call(ctx, "tools/call", callToolParams{Name: method, Arguments: arguments}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]string, 0, len(result.Content))
+	for _, raw := range result.Content {
+		var item content
+		if json.Unmarshal(raw, &item) == nil && item.Type == "text" {
+			records = append(records, item.Text)
+			continue
+		}
+		records = append(records, string(raw))
+	}
+
+	if result.IsError {
+		return nil, fmt.Errorf("%w: %s: %s", ErrToolFailed, method, strings.Join(records, "\n"))
+	}
+
+	return records, nil
+}
+
+func (c *Client) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	n := c.nextID.Add(1)
+	id := strconv.FormatInt(n, 10)
+
+	msg, err := json.Marshal(struct {
+		JSONRPC string      `json:"jsonrpc"`
+		ID      int64       `json:"id"`
+		Method  string      `json:"method"`
+		Params  interface{} `json:"params,omitempty"`
+	}{"2.0", n, method, params})
+	if err != nil {
+		return err
+	}
+
+	raw, err := c.transport.roundTrip(ctx, id, msg)
+	if err != nil {
+		return err
+	}
+
+	var res message
+	err = json.Unmarshal(raw, &res)
+	if err != nil {
+		return fmt.Errorf("mcp: invalid response to %s: %w", method, err)
+	}
+	if res.Error != nil {
+		return fmt.Errorf("mcp: %s: %w", method, res.Error)
+	}
+
+	return json.Unmarshal(res.Result, result)
+}
+
+func (c *Client) notify(ctx context.Context, method string) error {
+	msg, err := json.Marshal(request{JSONRPC: "2.0", Method: method})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.transport.roundTrip(ctx, "", msg)
+	return err
+}
@@ -0,0 +1,295 @@
+package mcp
+
+import (
+	"context"
+	"doppelganger"
+	"doppelganger/pkg/tool"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// connectInProcess connects a client to a server running in the same process
+// over the stdio transport.
+func connectInProcess(t *testing.T, s *Server) *Client {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.ServeStdio(ctx, serverReader, serverWriter)
+		serverWriter.Close()
+		close(done)
+	}()
+
+	c := NewClient()
+	c.transport = newStdioTransport(clientReader, clientWriter, func() error {
+		clientWriter.Close()
+		<-done
+		return nil
+	})
+	t.Cleanup(cancel)
+
+	err := c.connect(context.Background())
+	require.Nil(t, err)
+
+	return c
+}
+
+func TestClientQuery(t *testing.T) {
+	tt := []struct {
+		description     string
+		method          string
+		query           string
+		expectedError   bool
+		expectedRecords []string
+	}{
+		{
+			description:     "when the tool succeeds should return its content",
+			method:          "get_user",
+			query:           `{"id":"42"}`,
+			expectedError:   false,
+			expectedRecords: []string{`["{\"id\":\"42\"}"]`},
+		},
+		{
+			description:   "when the tool reports an error should return error",
+			method:        "get_account",
+			query:         `{}`,
+			expectedError: true,
+		},
+		{
+			description:   "when the tool does not exist should return error",
+			method:        "delete_user",
+			query:         `{}`,
+			expectedError: true,
+		},
+		{
+			description:   "when the arguments are not JSON should return error",
+			method:        "get_user",
+			query:         `id=42`,
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			c := connectInProcess(t, NewServer(newTestAgent(t), WithServerInfo("fake", "0.1.0")))
+			defer c.Close(context.Background())
+
+			require.Equal(t, "fake", c.ServerName)
+			require.Equal(t, "mcp", c.Type())
+
+			records, err := c.Query(context.Background(), "", test.method, "", test.query)
+			if test.expectedError {
+				require.NotNil(t, err)
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, test.expectedRecords, records)
+		})
+	}
+}
+
+func TestClientOverHTTP(t *testing.T) {
+	server := httptest.NewServer(NewServer(newTestAgent(t)))
+	defer server.Close()
+
+	c := NewClient()
+	err := c.Connect(context.Background(), server.URL)
+	require.Nil(t, err)
+	defer c.Close(context.Background())
+
+	tools, err := c.ListTools(context.Background())
+	require.Nil(t, err)
+	require.Len(t, tools, 2)
+
+	records, err := c.Query(context.Background(), "", "get_user", "", `{"id":"7"}`)
+	require.Nil(t, err)
+	require.Equal(t, []string{`["{\"id\":\"7\"}"]`}, records)
+}
+
+// TestClientEventStream uses a fake server answering with server-sent events
+// and a session, as servers implementing the Streamable HTTP transport may.
+func TestClientEventStream(t *testing.T) {
+	var sessions []string
+	var deleted bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deleted = r.Header.Get("Mcp-Session-Id") == "session-1"
+			return
+		}
+
+		sessions = append(sessions, r.Header.Get("Mcp-Session-Id"))
+		body, _ := io.ReadAll(r.Body)
+
+		var req request
+		json.Unmarshal(body, &req)
+
+		var result string
+		switch req.Method {
+		case "initialize":
+			w.Header().Set("Mcp-Session-Id", "session-1")
+			result = `{"protocolVersion":"2025-03-26","capabilities":{"tools":{}},"serverInfo":{"name":"events","version":"1"}}`
+		case "tools/list":
+			result = `{"tools":[{"name":"search","description":"Searches","inputSchema":{"type":"object"}}]}`
+		case "tools/call":
+			result = `{"content":[{"type":"text","text":"found"},{"type":"image","data":"aGk=","mimeType":"image/png"}]}`
+		default:
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\",\"params\":{}}\n\n")
+		fmt.Fprintf(w, "event: message\ndata: {\"jsonrpc\":\"2.0\",\"id\":%s,\"result\":%s}\n\n", req.ID, result)
+	}))
+	defer server.Close()
+
+	c := NewClient()
+	err := c.Connect(context.Background(), server.URL)
+	require.Nil(t, err)
+
+	records, err := c.Query(context.Background(), "", "search", "", "")
+	require.Nil(t, err)
+	require.Equal(t, "found", records[0])
+	require.Contains(t, records[1], `"type":"image"`)
+
+	err = c.Close(context.Background())
+	require.Nil(t, err)
+	require.Equal(t, []string{"", "session-1", "session-1"}, sessions)
+	require.True(t, deleted)
+}
+
+func TestImport(t *testing.T) {
+	tt := []struct {
+		description     string
+		registered      []string
+		opts            []ImportOption
+		expectedError   string
+		expectedNames   []string
+		expectedTrusted bool
+	}{
+		{
+			description:   "when importing every tool should register them all",
+			expectedNames: []string{"get_user", "get_account"},
+		},
+		{
+			description:   "when a prefix and names are given should register the named tools with the prefix",
+			opts:          []ImportOption{WithPrefix("crm_"), WithToolNames("get_user")},
+			expectedNames: []string{"crm_get_user"},
+		},
+		{
+			description:     "when the tools are trusted should not mark them",
+			opts:            []ImportOption{WithTrusted()},
+			expectedNames:   []string{"get_user", "get_account"},
+			expectedTrusted: true,
+		},
+		{
+			description:   "when a named tool does not exist should return error",
+			opts:          []ImportOption{WithToolNames("delete_user")},
+			expectedError: "has no tool delete_user",
+		},
+		{
+			description:   "when a tool of the agent has the same name should return error",
+			registered:    []string{"get_account"},
+			expectedError: "registering get_account: a tool with this name is already registered",
+		},
+		{
+			description:   "when a prefix keeps the names apart should register them",
+			registered:    []string{"get_account"},
+			opts:          []ImportOption{WithPrefix("crm_")},
+			expectedNames: []string{"crm_get_user", "crm_get_account"},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			c := connectInProcess(t, NewServer(newTestAgent(t)))
+			defer c.Close(context.Background())
+
+			d := doppelganger.New()
+			for _, name := range test.registered {
+				err := d.RegisterTool(tool.DataSourceTool{Name: name, Description: "Defined in the config", Parameters: map[string]any{"type": "object"}})
+				require.Nil(t, err)
+			}
+
+			names, err := Import(context.Background(), d, c, test.opts...)
+			if test.expectedError != "" {
+				require.ErrorContains(t, err, test.expectedError)
+				// The tools of the agent are kept
+				for i := range test.registered {
+					require.Equal(t, "Defined in the config", d.Tools[i].Description)
+				}
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, test.expectedNames, names)
+			require.Len(t, d.Tools, len(test.registered)+len(test.expectedNames))
+			for _, tl := range d.Tools[len(test.registered):] {
+				require.Equal(t, !test.expectedTrusted, tl.Untrusted)
+			}
+		})
+	}
+}
+
+type mockProvider struct {
+	responses []*llms.ContentResponse
+	counter   int
+	messages  []llms.MessageContent
+}
+
+func (m *mockProvider) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	m.messages = messages
+	response := m.responses[m.counter]
+	m.counter += 1
+	return response, nil
+}
+
+func (m *mockProvider) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return "", fmt.Errorf("not supported")
+}
+
+func TestImportedToolsInDecisions(t *testing.T) {
+	c := connectInProcess(t, NewServer(newTestAgent(t)))
+	defer c.Close(context.Background())
+
+	provider := &mockProvider{
+		responses: []*llms.ContentResponse{
+			{
+				Choices: []*llms.ContentChoice{
+					{
+						ToolCalls: []llms.ToolCall{
+							{ID: "1", FunctionCall: &llms.FunctionCall{Name: "crm_get_user", Arguments: `{"id":"42"}`}},
+						},
+					},
+				},
+			},
+			{Choices: []*llms.ContentChoice{{Content: "user 42 found"}}},
+		},
+	}
+	d := doppelganger.New(doppelganger.WithProviderGenerator(func(model string) (llms.Model, error) {
+		return provider, nil
+	}))
+
+	_, err := Import(context.Background(), d, c, WithPrefix("crm_"))
+	require.Nil(t, err)
+
+	res, err := d.Decide(context.Background(), doppelganger.DecisionRequest{
+		SystemInstruction: "abc",
+		UserInstruction:   "who is 42?",
+		Model:             "mock",
+	})
+	require.Nil(t, err)
+	require.Equal(t, "user 42 found", res.Answer)
+	require.Len(t, res.ToolCalls, 1)
+	require.True(t, strings.Contains(res.ToolCalls[0].Result, `{\\\"id\\\":\\\"42\\\"}`), res.ToolCalls[0].Result)
+}
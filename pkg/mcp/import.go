@@ -0,0 +1,112 @@
+package mcp
+
+import (
+	"context"
+	"doppelganger"
+	"doppelganger/pkg/datasource"
+	"doppelganger/pkg/tool"
+	"fmt"
+)
+
+type importOptions struct {
+	prefix           string
+	names            map[string]bool
+	requiresApproval bool
+	trusted          bool
+	source           datasource.DataSource
+}
+
+type ImportOption func(*importOptions)
+
+// WithPrefix prepends prefix to the names of the imported tools, to keep them
+// apart from tools of other servers.
+func WithPrefix(prefix string) ImportOption {
+	return func(o *importOptions) {
+		o.prefix = prefix
+	}
+}
+
+// WithToolNames imports only the named tools.
+func WithToolNames(names ...string) ImportOption {
+	return func(o *importOptions) {
+		o.names = make(map[string]bool)
+		for _, name := range names {
+			o.names[name] = true
+		}
+	}
+}
+
+// WithRequiresApproval makes every imported tool require approval.
+func WithRequiresApproval() ImportOption {
+	return func(o *importOptions) {
+		o.requiresApproval = true
+	}
+}
+
+// WithTrusted stops marking the imported tools as Untrusted, for servers
+// whose results are written by the business only.
+func WithTrusted() ImportOption {
+	return func(o *importOptions) {
+		o.trusted = true
+	}
+}
+
+// WithSource makes the imported tools query source instead of the client,
+// such as the client wrapped in a datasource.Limited.
+func WithSource(source datasource.DataSource) ImportOption {
+	return func(o *importOptions) {
+		o.source = source
+	}
+}
+
+// Import discovers the tools of a connected client and registers them on d
+// next to its other tools. The model arguments are passed to the server as
+// they are, and the tools are Untrusted unless WithTrusted is given. It
+// returns the names the tools were registered under.
+func Import(ctx context.Context, d *doppelganger.Doppelganger, c *Client, opts ...ImportOption) ([]string, error) {
+	o := &importOptions{source: c}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	tools, err := c.ListTools(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, t := range tools {
+		if o.names != nil && !o.names[t.Name] {
+			continue
+		}
+
+		schema := t.InputSchema
+		if schema == nil {
+			schema = map[string]interface{}{"type": "object"}
+		}
+
+		name := o.prefix + t.Name
+		err := d.RegisterTool(tool.DataSourceTool{
+			Source:           o.source,
+			Name:             name,
+			Description:      t.Description,
+			Parameters:       schema,
+			Method:           t.Name,
+			Query:            "{{ json . }}",
+			RequiresApproval: o.requiresApproval,
+			Untrusted:        !o.trusted,
+		})
+		if err != nil {
+			return names, err
+		}
+
+		names = append(names, name)
+		delete(o.names, t.Name)
+	}
+
+	for name := range o.names {
+		return names, fmt.Errorf("mcp: server %s has no tool %s", c.ServerName, name)
+	}
+
+	return names, nil
+}
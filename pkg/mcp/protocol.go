@@ -0,0 +1,117 @@
+package mcp
+
+import (
+	jsoniter "github.com/json-iterator/go"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// ProtocolVersion is the latest revision of the Model Context Protocol
+// supported. Peers asking for an older supported revision get that one.
+const ProtocolVersion = "2025-03-26"
+
+var supportedVersions = map[string]bool{
+	"2024-11-05": true,
+	"2025-03-26": true,
+	"2025-06-18": true,
+}
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+type request struct {
+	JSONRPC string              `json:"jsonrpc"`
+	ID      jsoniter.RawMessage `json:"id,omitempty"`
+	Method  string              `json:"method"`
+	Params  jsoniter.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string              `json:"jsonrpc"`
+	ID      jsoniter.RawMessage `json:"id"`
+	Result  interface{}         `json:"result,omitempty"`
+	Error   *rpcError           `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+type implementation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type initializeParams struct {
+	ProtocolVersion string                 `json:"protocolVersion"`
+	Capabilities    map[string]interface{} `json:"capabilities"`
+	ClientInfo      implementation         `json:"clientInfo"`
+}
+
+type initializeResult struct {
+	ProtocolVersion string                 `json:"protocolVersion"`
+	Capabilities    map[string]interface{} `json:"capabilities"`
+	ServerInfo      implementation         `json:"serverInfo"`
+}
+
+// Tool is a tool as described by tools/list.
+type Tool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+}
+
+type listToolsParams struct {
+	Cursor string `json:"cursor,omitempty"`
+}
+
+type listToolsResult struct {
+	Tools      []Tool `json:"tools"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+type callToolParams struct {
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments,omitempty"`
+}
+
+type content struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+}
+
+type callToolResult struct {
+	Content []content `json:"content"`
+	IsError bool      `json:"isError,omitempty"`
+}
+
+// toolResult is a tools/call result as read by the client, keeping content
+// other than text as it was sent.
+type toolResult struct {
+	Content []jsoniter.RawMessage `json:"content"`
+	IsError bool                  `json:"isError"`
+}
+
+// message is any JSON-RPC message received by the client.
+type message struct {
+	ID     jsoniter.RawMessage `json:"id,omitempty"`
+	Method string              `json:"method,omitempty"`
+	Result jsoniter.RawMessage `json:"result,omitempty"`
+	Error  *rpcError           `json:"error,omitempty"`
+}
+
+type cancelledParams struct {
+	RequestID jsoniter.RawMessage `json:"requestId"`
+	Reason    string              `json:"reason,omitempty"`
+}
@@ -0,0 +1,302 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"doppelganger"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const maxMessageBytes = 10 << 20
+
+// Server exposes the tools registered on an agent over the Model Context
+// Protocol. Calls go through the agent, so they are validated, approved and
+// policed like calls made by its own models.
+type Server struct {
+	agent   *doppelganger.Doppelganger
+	info    implementation
+	cancels map[string]context.CancelFunc
+	mu      sync.Mutex
+}
+
+type ServerOption func(*Server)
+
+// WithServerInfo sets the name and version reported to clients.
+func WithServerInfo(name, version string) ServerOption {
+	return func(s *Server) {
+		s.info = implementation{Name: name, Version: version}
+	}
+}
+
+func NewServer(agent *doppelganger.Doppelganger, opts ...ServerOption) *Server {
+	s := &Server{
+		agent:   agent,
+		info:    implementation{Name: "doppelganger", Version: "1.0.0"},
+		cancels: make(map[string]context.CancelFunc),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// ServeStdio reads newline delimited messages from in and writes the
+// responses to out until in is closed or ctx is done. Requests are handled
+// concurrently so that a slow tool does not hold up the others.
+func (s *Server) ServeStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	var writeMu sync.Mutex
+	write := func(msg []byte) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		out.Write(append(msg, '\n'))
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageBytes)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		msg := append([]byte{}, line...)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res := s.Handle(ctx, msg); res != nil {
+				write(res)
+			}
+		}()
+	}
+
+	return scanner.Err()
+}
+
+// ServeHTTP implements the Streamable HTTP transport for clients that POST
+// messages. Responses are sent as plain JSON, notifications are accepted
+// without a body, and no server initiated stream is offered.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	msg, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	res := s.Handle(r.Context(), msg)
+	if res == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+// Handle answers a single JSON-RPC message. It returns nil for
+// notifications, which have no response.
+func (s *Server) Handle(ctx context.Context, msg []byte) []byte {
+	var req request
+	err := json.Unmarshal(msg, &req)
+	if err != nil {
+		return s.marshal(response{Error: &rpcError{Code: codeParseError, Message: err.Error()}})
+	}
+
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return s.marshal(response{ID: req.ID, Error: &rpcError{Code: codeInvalidRequest, Message: "invalid request"}})
+	}
+
+	// Notifications
+	if req.ID == nil {
+		if req.Method == "notifications/cancelled" {
+			s.cancel(req.Params)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.track(req.ID, cancel)
+	defer s.untrack(req.ID)
+
+	result, err := s.dispatch(ctx, req)
+	if err != nil {
+		rpcErr, ok := err.(*rpcError)
+		if !ok {
+			rpcErr = &rpcError{Code: codeInternalError, Message: err.Error()}
+		}
+
+		return s.marshal(response{ID: req.ID, Error: rpcErr})
+	}
+
+	return s.marshal(response{ID: req.ID, Result: result})
+}
+
+func (s *Server) dispatch(ctx context.Context, req request) (interface{}, error) {
+	switch req.Method {
+	case "initialize":
+		var params initializeParams
+		err := unmarshalParams(req.Params, &params)
+		if err != nil {
+			return nil, err
+		}
+
+		version := params.ProtocolVersion
+		if !supportedVersions[version] {
+			version = ProtocolVersion
+		}
+
+		return initializeResult{
+			ProtocolVersion: version,
+			Capabilities: map[string]interface{}{
+				"tools": map[string]interface{}{"listChanged": false},
+			},
+			ServerInfo: s.info,
+		}, nil
+	case "ping":
+		return struct{}{}, nil
+	case "tools/list":
+		return listToolsResult{Tools: s.tools(ctx)}, nil
+	case "tools/call":
+		var params callToolParams
+		err := unmarshalParams(req.Params, &params)
+		if err != nil {
+			return nil, err
+		}
+
+		return s.callTool(ctx, params)
+	}
+
+	return nil, &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %s not found", req.Method)}
+}
+
+// tools lists the tools the caller may use.
+func (s *Server) tools(ctx context.Context) []Tool {
+	allowed := s.agent.AllowedTools(ctx)
+	tools := make([]Tool, 0, len(allowed))
+	for _, t := range allowed {
+		schema := t.Parameters
+		if schema == nil {
+			schema = map[string]interface{}{"type": "object"}
+		}
+
+		tools = append(tools, Tool{
+			Name:        t.Name,
+			Description: t.Description,
+			InputSchema: schema,
+		})
+	}
+
+	return tools
+}
+
+// callTool runs the tool through the agent. Failures of the tool are
+// reported in the result so that the model calling it can see them.
+func (s *Server) callTool(ctx context.Context, params callToolParams) (interface{}, error) {
+	found := false
+	for _, t := range s.agent.AllowedTools(ctx) {
+		if t.Name == params.Name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("unknown tool: %s", params.Name)}
+	}
+
+	arguments := params.Arguments
+	if arguments == nil {
+		arguments = map[string]interface{}{}
+	}
+	args, err := json.Marshal(arguments)
+	if err != nil {
+		return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+
+	result, err := s.agent.ExecuteTool(ctx, params.Name, string(args))
+	if err != nil {
+		return callToolResult{
+			Content: []content{{Type: "text", Text: err.Error()}},
+			IsError: true,
+		}, nil
+	}
+
+	return callToolResult{Content: []content{{Type: "text", Text: result}}}, nil
+}
+
+func (s *Server) track(id jsoniter.RawMessage, cancel context.CancelFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cancels[string(id)] = cancel
+}
+
+func (s *Server) untrack(id jsoniter.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cancels, string(id))
+}
+
+// cancel stops the request named by a notifications/cancelled message.
+func (s *Server) cancel(raw jsoniter.RawMessage) {
+	var params cancelledParams
+	if json.Unmarshal(raw, &params) != nil {
+		return
+	}
+
+	s.mu.Lock()
+	cancel, ok := s.cancels[string(params.RequestID)]
+	s.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+func (s *Server) marshal(res response) []byte {
+	res.JSONRPC = "2.0"
+	if res.ID == nil {
+		res.ID = jsoniter.RawMessage("null")
+	}
+
+	msg, err := json.Marshal(res)
+	if err != nil {
+		msg, _ = json.Marshal(response{
+			JSONRPC: "2.0",
+			ID:      res.ID,
+			Error:   &rpcError{Code: codeInternalError, Message: err.Error()},
+		})
+	}
+
+	return msg
+}
+
+func unmarshalParams(raw jsoniter.RawMessage, v interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	err := json.Unmarshal(raw, v)
+	if err != nil {
+		return &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+
+	return nil
+}
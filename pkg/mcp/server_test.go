@@ -0,0 +1,261 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"doppelganger"
+	"doppelganger/pkg/auth"
+	"doppelganger/pkg/datasource"
+	"doppelganger/pkg/tool"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mockDatasource struct {
+	returnError bool
+}
+
+func (m *mockDatasource) Connect(ctx context.Context, connectionString string) error {
+	return nil
+}
+
+func (m *mockDatasource) Close(ctx context.Context) error {
+	return nil
+}
+
+func (m *mockDatasource) Query(ctx context.Context, database, method, collection, query string, opts ...datasource.QueryOption) ([]string, error) {
+	if m.returnError {
+		return nil, errors.New("connection refused")
+	}
+	return []string{query}, nil
+}
+
+func (m *mockDatasource) Type() string {
+	return "mock"
+}
+
+func newTestAgent(t *testing.T) *doppelganger.Doppelganger {
+	d := doppelganger.New()
+
+	err := d.RegisterTool(tool.DataSourceTool{
+		Name:        "get_user",
+		Description: "Gets a user",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"id": map[string]any{"type": "string"},
+			},
+		},
+		Query:  `{"id":"{{ .id }}"}`,
+		Source: &mockDatasource{},
+	})
+	require.Nil(t, err)
+
+	err = d.RegisterTool(tool.DataSourceTool{
+		Name:        "get_account",
+		Description: "Gets an account",
+		Parameters:  map[string]any{"type": "object"},
+		Source:      &mockDatasource{returnError: true},
+	})
+	require.Nil(t, err)
+
+	return d
+}
+
+func TestHandle(t *testing.T) {
+	tt := []struct {
+		description      string
+		message          string
+		expectedResponse string
+		expectedContains string
+	}{
+		{
+			description:      "when initializing should report the tools capability and the requested version",
+			message:          `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`,
+			expectedResponse: `{"jsonrpc":"2.0","id":1,"result":{"protocolVersion":"2024-11-05","capabilities":{"tools":{"listChanged":false}},"serverInfo":{"name":"doppelganger","version":"1.0.0"}}}`,
+		},
+		{
+			description:      "when initializing with an unknown version should answer with the latest",
+			message:          `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"1999-01-01"}}`,
+			expectedResponse: `{"jsonrpc":"2.0","id":1,"result":{"protocolVersion":"2025-03-26","capabilities":{"tools":{"listChanged":false}},"serverInfo":{"name":"doppelganger","version":"1.0.0"}}}`,
+		},
+		{
+			description:      "when pinged should answer with an empty result",
+			message:          `{"jsonrpc":"2.0","id":"a","method":"ping"}`,
+			expectedResponse: `{"jsonrpc":"2.0","id":"a","result":{}}`,
+		},
+		{
+			description:      "when listing tools should map the parameters to input schemas",
+			message:          `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+			expectedResponse: `{"jsonrpc":"2.0","id":2,"result":{"tools":[{"name":"get_user","description":"Gets a user","inputSchema":{"type":"object","properties":{"id":{"type":"string"}}}},{"name":"get_account","description":"Gets an account","inputSchema":{"type":"object"}}]}}`,
+		},
+		{
+			description:      "when calling a tool should return its result as text",
+			message:          `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"get_user","arguments":{"id":"42"}}}`,
+			expectedResponse: `{"jsonrpc":"2.0","id":3,"result":{"content":[{"type":"text","text":"[\"{\\\"id\\\":\\\"42\\\"}\"]"}]}}`,
+		},
+		{
+			description:      "when a tool fails should return the error as a result",
+			message:          `{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"get_account"}}`,
+			expectedResponse: `{"jsonrpc":"2.0","id":4,"result":{"content":[{"type":"text","text":"connection refused"}],"isError":true}}`,
+		},
+		{
+			description:      "when calling an unknown tool should return invalid params",
+			message:          `{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"delete_user"}}`,
+			expectedResponse: `{"jsonrpc":"2.0","id":5,"error":{"code":-32602,"message":"unknown tool: delete_user"}}`,
+		},
+		{
+			description:      "when the method is unknown should return method not found",
+			message:          `{"jsonrpc":"2.0","id":6,"method":"resources/list"}`,
+			expectedResponse: `{"jsonrpc":"2.0","id":6,"error":{"code":-32601,"message":"method resources/list not found"}}`,
+		},
+		{
+			description:      "when the message is not JSON should return a parse error",
+			message:          `{"jsonrpc":`,
+			expectedContains: `"id":null,"error":{"code":-32700`,
+		},
+		{
+			description:      "when the message is not JSON-RPC 2.0 should return invalid request",
+			message:          `{"id":7,"method":"ping"}`,
+			expectedResponse: `{"jsonrpc":"2.0","id":7,"error":{"code":-32600,"message":"invalid request"}}`,
+		},
+		{
+			description:      "when the message is a notification should not respond",
+			message:          `{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+			expectedResponse: "",
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			s := NewServer(newTestAgent(t))
+
+			res := s.Handle(context.Background(), []byte(test.message))
+			if test.expectedContains != "" {
+				require.Contains(t, string(res), test.expectedContains)
+				return
+			}
+			if test.expectedResponse == "" {
+				require.Nil(t, res)
+				return
+			}
+
+			require.JSONEq(t, test.expectedResponse, string(res))
+		})
+	}
+}
+
+func TestHandleAuthorization(t *testing.T) {
+	tt := []struct {
+		description      string
+		principal        *auth.Principal
+		message          string
+		expectedResponse string
+	}{
+		{
+			description:      "when the caller lacks the role of a tool should not list it",
+			principal:        &auth.Principal{Subject: "alice", Roles: []string{"customer"}},
+			message:          `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`,
+			expectedResponse: `{"jsonrpc":"2.0","id":1,"result":{"tools":[{"name":"get_user","description":"Gets a user","inputSchema":{"type":"object","properties":{"id":{"type":"string"}}}},{"name":"get_account","description":"Gets an account","inputSchema":{"type":"object"}}]}}`,
+		},
+		{
+			description:      "when the caller lacks the role of a tool should treat it as unknown",
+			principal:        &auth.Principal{Subject: "alice", Roles: []string{"customer"}},
+			message:          `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"get_audit"}}`,
+			expectedResponse: `{"jsonrpc":"2.0","id":2,"error":{"code":-32602,"message":"unknown tool: get_audit"}}`,
+		},
+		{
+			description:      "when the caller has the role of a tool should list it",
+			principal:        &auth.Principal{Subject: "bob", Roles: []string{"auditor"}},
+			message:          `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`,
+			expectedResponse: `{"jsonrpc":"2.0","id":3,"result":{"tools":[{"name":"get_user","description":"Gets a user","inputSchema":{"type":"object","properties":{"id":{"type":"string"}}}},{"name":"get_account","description":"Gets an account","inputSchema":{"type":"object"}},{"name":"get_audit","description":"Gets an audit","inputSchema":{"type":"object"}}]}}`,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			agent := newTestAgent(t)
+			err := agent.RegisterTool(tool.DataSourceTool{
+				Name:        "get_audit",
+				Description: "Gets an audit",
+				Parameters:  map[string]any{"type": "object"},
+				Source:      &mockDatasource{},
+				Roles:       []string{"auditor"},
+			})
+			require.Nil(t, err)
+			s := NewServer(agent)
+
+			res := s.Handle(auth.WithPrincipal(context.Background(), test.principal), []byte(test.message))
+			require.JSONEq(t, test.expectedResponse, string(res))
+		})
+	}
+}
+
+func TestServeStdio(t *testing.T) {
+	s := NewServer(newTestAgent(t))
+
+	in := strings.NewReader(strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26"}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		``,
+		`{"jsonrpc":"2.0","id":2,"method":"ping"}`,
+	}, "\n"))
+	var out bytes.Buffer
+
+	err := s.ServeStdio(context.Background(), in, &out)
+	require.Nil(t, err)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, out.String(), `"id":1,"result":{"protocolVersion":"2025-03-26"`)
+	require.Contains(t, out.String(), `{"jsonrpc":"2.0","id":2,"result":{}}`)
+}
+
+func TestServeHTTP(t *testing.T) {
+	tt := []struct {
+		description    string
+		method         string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			description:    "when a request is posted should answer with JSON",
+			method:         http.MethodPost,
+			body:           `{"jsonrpc":"2.0","id":1,"method":"ping"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"jsonrpc":"2.0","id":1,"result":{}}`,
+		},
+		{
+			description:    "when a notification is posted should accept it",
+			method:         http.MethodPost,
+			body:           `{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			description:    "when a stream is requested should not allow it",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			s := NewServer(newTestAgent(t))
+
+			req := httptest.NewRequest(test.method, "/mcp", strings.NewReader(test.body))
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+
+			require.Equal(t, test.expectedStatus, rec.Code)
+			if test.expectedBody != "" {
+				require.JSONEq(t, test.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
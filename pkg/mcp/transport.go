@@ -0,0 +1,287 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// transport carries messages to a server. roundTrip returns the response to
+// a request, or nil for a notification.
+type transport interface {
+	roundTrip(ctx context.Context, id string, msg []byte) ([]byte, error)
+	setProtocolVersion(version string)
+	close() error
+}
+
+var errTransportClosed = errors.New("mcp: transport closed")
+
+// stdioTransport talks to a server over newline delimited messages, usually
+// the standard input and output of a process it started.
+type stdioTransport struct {
+	w       io.Writer
+	closer  func() error
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan []byte
+	done    chan struct{}
+	err     error
+}
+
+func newStdioTransport(r io.Reader, w io.Writer, closer func() error) *stdioTransport {
+	t := &stdioTransport{
+		w:       w,
+		closer:  closer,
+		pending: make(map[string]chan []byte),
+		done:    make(chan struct{}),
+	}
+	go t.read(r)
+
+	return t
+}
+
+func (t *stdioTransport) read(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageBytes)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var msg message
+		if json.Unmarshal(line, &msg) != nil {
+			continue
+		}
+
+		// Requests from the server
+		if msg.Method != "" {
+			if msg.ID != nil {
+				t.answer(msg)
+			}
+			continue
+		}
+
+		t.mu.Lock()
+		ch, ok := t.pending[string(msg.ID)]
+		delete(t.pending, string(msg.ID))
+		t.mu.Unlock()
+
+		if ok {
+			ch <- append([]byte{}, line...)
+		}
+	}
+
+	t.mu.Lock()
+	t.err = scanner.Err()
+	if t.err == nil {
+		t.err = errTransportClosed
+	}
+	t.mu.Unlock()
+	close(t.done)
+}
+
+// answer replies to the requests a server may send. Only ping is supported.
+func (t *stdioTransport) answer(msg message) {
+	res := response{JSONRPC: "2.0", ID: msg.ID, Result: struct{}{}}
+	if msg.Method != "ping" {
+		res = response{JSONRPC: "2.0", ID: msg.ID, Error: &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %s not found", msg.Method)}}
+	}
+
+	b, err := json.Marshal(res)
+	if err == nil {
+		t.write(b)
+	}
+}
+
+func (t *stdioTransport) write(msg []byte) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	_, err := t.w.Write(append(msg, '\n'))
+	return err
+}
+
+func (t *stdioTransport) roundTrip(ctx context.Context, id string, msg []byte) ([]byte, error) {
+	var ch chan []byte
+	if id != "" {
+		ch = make(chan []byte, 1)
+
+		t.mu.Lock()
+		t.pending[id] = ch
+		t.mu.Unlock()
+
+		defer func() {
+			t.mu.Lock()
+			delete(t.pending, id)
+			t.mu.Unlock()
+		}()
+	}
+
+	err := t.write(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if ch == nil {
+		return nil, nil
+	}
+
+	select {
+	case res := <-ch:
+		return res, nil
+	case <-t.done:
+		return nil, t.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *stdioTransport) setProtocolVersion(version string) {}
+
+func (t *stdioTransport) close() error {
+	if t.closer == nil {
+		return nil
+	}
+
+	return t.closer()
+}
+
+// httpTransport implements the client side of the Streamable HTTP transport.
+// Responses may come as JSON or as a stream of server-sent events.
+type httpTransport struct {
+	url     string
+	client  *http.Client
+	headers http.Header
+
+	mu              sync.Mutex
+	sessionID       string
+	protocolVersion string
+}
+
+func (t *httpTransport) roundTrip(ctx context.Context, id string, msg []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	t.setHeaders(req)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if sessionID := res.Header.Get("Mcp-Session-Id"); sessionID != "" {
+		t.mu.Lock()
+		t.sessionID = sessionID
+		t.mu.Unlock()
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return nil, fmt.Errorf("mcp: %s returned %s: %s", t.url, res.Status, strings.TrimSpace(string(body)))
+	}
+
+	if id == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "text/event-stream") {
+		return readEventStream(res.Body, id)
+	}
+
+	return io.ReadAll(io.LimitReader(res.Body, maxMessageBytes))
+}
+
+// readEventStream returns the response with the given ID from a stream of
+// server-sent events, skipping the other messages.
+func readEventStream(r io.Reader, id string) ([]byte, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageBytes)
+
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(value, " "))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue
+		}
+
+		var msg message
+		if json.Unmarshal(data.Bytes(), &msg) == nil && msg.Method == "" && string(msg.ID) == id {
+			return data.Bytes(), nil
+		}
+		data.Reset()
+	}
+
+	err := scanner.Err()
+	if err == nil {
+		err = fmt.Errorf("mcp: event stream ended without a response")
+	}
+
+	return nil, err
+}
+
+func (t *httpTransport) setHeaders(req *http.Request) {
+	for key, values := range t.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", t.sessionID)
+	}
+	if t.protocolVersion != "" {
+		req.Header.Set("Mcp-Protocol-Version", t.protocolVersion)
+	}
+}
+
+func (t *httpTransport) setProtocolVersion(version string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.protocolVersion = version
+}
+
+// close ends the session, if the server started one.
+func (t *httpTransport) close() error {
+	t.mu.Lock()
+	sessionID := t.sessionID
+	t.mu.Unlock()
+
+	if sessionID == "" {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, t.url, nil)
+	if err != nil {
+		return err
+	}
+	t.setHeaders(req)
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
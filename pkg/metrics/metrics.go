@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"context"
+	"time"
+)
+
+// Outcomes of decisions, model calls, tool calls and queries.
+const (
+	OK       = "ok"
+	Error    = "error"
+	Rejected = "rejected"
+	// Blocked tool calls were refused by the injection defences, and
+	// withheld ones ran but their result was not shown to the model.
+	Blocked  = "blocked"
+	Withheld = "withheld"
+	// RateLimited tool calls did not get their turn from a rate limiter.
+	RateLimited = "rate_limited"
+	// TimedOut tool calls ran out of their own time.
+	TimedOut = "timeout"
+)
+
+// Recorder receives measurements of the agent. Implementations must be safe
+// for concurrent use.
+type Recorder interface {
+	// Decision is called when a decision ends, with the number of model
+	// rounds it took.
+	Decision(model, outcome string, rounds int, duration time.Duration)
+	ModelCall(model, outcome string, duration time.Duration, inputTokens, outputTokens int)
+	ToolCall(tool, outcome string, duration time.Duration)
+	Query(sourceType, method, outcome string, duration time.Duration)
+	// Retry and Fallback are recorded by the models of the llm package when
+	// they make a failed call again or hand it to another model. Custom
+	// providers record them with FromContext.
+	Retry(model string)
+	Fallback(from, to string)
+}
+
+// Nop discards every measurement.
+type Nop struct{}
+
+func (Nop) Decision(model, outcome string, rounds int, duration time.Duration) {}
+
+func (Nop) ModelCall(model, outcome string, duration time.Duration, inputTokens, outputTokens int) {}
+
+func (Nop) ToolCall(tool, outcome string, duration time.Duration) {}
+
+func (Nop) Query(sourceType, method, outcome string, duration time.Duration) {}
+
+func (Nop) Retry(model string) {}
+
+func (Nop) Fallback(from, to string) {}
+
+type recorderKey struct{}
+
+// WithRecorder makes r available to code that runs under ctx, such as
+// tools recording their queries.
+func WithRecorder(ctx context.Context, r Recorder) context.Context {
+	return context.WithValue(ctx, recorderKey{}, r)
+}
+
+// FromContext returns the recorder of ctx, or Nop.
+func FromContext(ctx context.Context) Recorder {
+	if r, ok := ctx.Value(recorderKey{}).(Recorder); ok {
+		return r
+	}
+
+	return Nop{}
+}
+
+// Outcome returns OK, or Error when err is not nil.
+func Outcome(err error) string {
+	if err != nil {
+		return Error
+	}
+
+	return OK
+}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "doppelganger"
+
+// Prometheus records measurements as Prometheus metrics:
+//
+//	doppelganger_decisions_total{model,outcome}
+//	doppelganger_decision_duration_seconds{model}
+//	doppelganger_decision_rounds{model}
+//	doppelganger_model_calls_total{model,outcome}
+//	doppelganger_model_call_duration_seconds{model}
+//	doppelganger_model_tokens_total{model,direction}
+//	doppelganger_tool_calls_total{tool,outcome}
+//	doppelganger_tool_call_duration_seconds{tool}
+//	doppelganger_datasource_queries_total{type,method,outcome}
+//	doppelganger_datasource_query_duration_seconds{type,method}
+//	doppelganger_model_retries_total{model}
+//	doppelganger_model_fallbacks_total{from,to}
+type Prometheus struct {
+	decisions        *prometheus.CounterVec
+	decisionDuration *prometheus.HistogramVec
+	decisionRounds   *prometheus.HistogramVec
+	modelCalls       *prometheus.CounterVec
+	modelDuration    *prometheus.HistogramVec
+	modelTokens      *prometheus.CounterVec
+	toolCalls        *prometheus.CounterVec
+	toolDuration     *prometheus.HistogramVec
+	queries          *prometheus.CounterVec
+	queryDuration    *prometheus.HistogramVec
+	retries          *prometheus.CounterVec
+	fallbacks        *prometheus.CounterVec
+}
+
+// NewPrometheus creates the metrics and registers them with reg.
+func NewPrometheus(reg prometheus.Registerer) (*Prometheus, error) {
+	// Decisions and model calls take seconds, queries milliseconds
+	slow := []float64{0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120}
+
+	p := &Prometheus{
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "decisions_total",
+			Help:      "Decisions made, by model and outcome.",
+		}, []string{"model", "outcome"}),
+		decisionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "decision_duration_seconds",
+			Help:      "Time taken by decisions, by model.",
+			Buckets:   slow,
+		}, []string{"model"}),
+		decisionRounds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "decision_rounds",
+			Help:      "Model rounds per decision, by model.",
+			Buckets:   []float64{1, 2, 3, 4, 5, 8, 13, 21},
+		}, []string{"model"}),
+		modelCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "model_calls_total",
+			Help:      "Calls to models, by model and outcome.",
+		}, []string{"model", "outcome"}),
+		modelDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "model_call_duration_seconds",
+			Help:      "Latency of model calls, by model.",
+			Buckets:   slow,
+		}, []string{"model"}),
+		modelTokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "model_tokens_total",
+			Help:      "Tokens used, by model and direction (input or output).",
+		}, []string{"model", "direction"}),
+		toolCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tool_calls_total",
+			Help:      "Tool calls, by tool and outcome.",
+		}, []string{"tool", "outcome"}),
+		toolDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "tool_call_duration_seconds",
+			Help:      "Latency of tool calls, by tool.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"tool"}),
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "datasource_queries_total",
+			Help:      "Data source queries, by data source type, method and outcome.",
+		}, []string{"type", "method", "outcome"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "datasource_query_duration_seconds",
+			Help:      "Latency of data source queries, by data source type and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"type", "method"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "model_retries_total",
+			Help:      "Model calls retried, by model.",
+		}, []string{"model"}),
+		fallbacks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "model_fallbacks_total",
+			Help:      "Model calls that fell back to another model.",
+		}, []string{"from", "to"}),
+	}
+
+	collectors := []prometheus.Collector{
+		p.decisions, p.decisionDuration, p.decisionRounds,
+		p.modelCalls, p.modelDuration, p.modelTokens,
+		p.toolCalls, p.toolDuration,
+		p.queries, p.queryDuration,
+		p.retries, p.fallbacks,
+	}
+	for _, c := range collectors {
+		err := reg.Register(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func (p *Prometheus) Decision(model, outcome string, rounds int, duration time.Duration) {
+	p.decisions.WithLabelValues(model, outcome).Inc()
+	p.decisionDuration.WithLabelValues(model).Observe(duration.Seconds())
+	p.decisionRounds.WithLabelValues(model).Observe(float64(rounds))
+}
+
+func (p *Prometheus) ModelCall(model, outcome string, duration time.Duration, inputTokens, outputTokens int) {
+	p.modelCalls.WithLabelValues(model, outcome).Inc()
+	p.modelDuration.WithLabelValues(model).Observe(duration.Seconds())
+	p.modelTokens.WithLabelValues(model, "input").Add(float64(inputTokens))
+	p.modelTokens.WithLabelValues(model, "output").Add(float64(outputTokens))
+}
+
+func (p *Prometheus) ToolCall(tool, outcome string, duration time.Duration) {
+	p.toolCalls.WithLabelValues(tool, outcome).Inc()
+	p.toolDuration.WithLabelValues(tool).Observe(duration.Seconds())
+}
+
+func (p *Prometheus) Query(sourceType, method, outcome string, duration time.Duration) {
+	p.queries.WithLabelValues(sourceType, method, outcome).Inc()
+	p.queryDuration.WithLabelValues(sourceType, method).Observe(duration.Seconds())
+}
+
+func (p *Prometheus) Retry(model string) {
+	p.retries.WithLabelValues(model).Inc()
+}
+
+func (p *Prometheus) Fallback(from, to string) {
+	p.fallbacks.WithLabelValues(from, to).Inc()
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheus(t *testing.T) {
+	tt := []struct {
+		description string
+		record      func(r Recorder)
+		metric      string
+		expected    string
+	}{
+		{
+			description: "when decisions end should count them by model and outcome",
+			record: func(r Recorder) {
+				r.Decision("gpt-4.1", OK, 2, time.Second)
+				r.Decision("gpt-4.1", OK, 1, time.Second)
+				r.Decision("gpt-4.1", Error, 1, time.Second)
+			},
+			metric: "doppelganger_decisions_total",
+			expected: `
+# HELP doppelganger_decisions_total Decisions made, by model and outcome.
+# TYPE doppelganger_decisions_total counter
+doppelganger_decisions_total{model="gpt-4.1",outcome="error"} 1
+doppelganger_decisions_total{model="gpt-4.1",outcome="ok"} 2
+`,
+		},
+		{
+			description: "when models are called should count input and output tokens",
+			record: func(r Recorder) {
+				r.ModelCall("gpt-4.1", OK, time.Second, 100, 20)
+				r.ModelCall("gpt-4.1", OK, time.Second, 150, 5)
+			},
+			metric: "doppelganger_model_tokens_total",
+			expected: `
+# HELP doppelganger_model_tokens_total Tokens used, by model and direction (input or output).
+# TYPE doppelganger_model_tokens_total counter
+doppelganger_model_tokens_total{direction="input",model="gpt-4.1"} 250
+doppelganger_model_tokens_total{direction="output",model="gpt-4.1"} 25
+`,
+		},
+		{
+			description: "when tools are called should count them by tool and outcome",
+			record: func(r Recorder) {
+				r.ToolCall("get_user", OK, time.Millisecond)
+				r.ToolCall("delete_user", Rejected, time.Millisecond)
+			},
+			metric: "doppelganger_tool_calls_total",
+			expected: `
+# HELP doppelganger_tool_calls_total Tool calls, by tool and outcome.
+# TYPE doppelganger_tool_calls_total counter
+doppelganger_tool_calls_total{outcome="ok",tool="get_user"} 1
+doppelganger_tool_calls_total{outcome="rejected",tool="delete_user"} 1
+`,
+		},
+		{
+			description: "when queries run should observe their latency by type and method",
+			record: func(r Recorder) {
+				r.Query("mongo", "find", OK, 20*time.Millisecond)
+			},
+			metric: "doppelganger_datasource_query_duration_seconds",
+			expected: `
+# HELP doppelganger_datasource_query_duration_seconds Latency of data source queries, by data source type and method.
+# TYPE doppelganger_datasource_query_duration_seconds histogram
+doppelganger_datasource_query_duration_seconds_bucket{method="find",type="mongo",le="0.005"} 0
+doppelganger_datasource_query_duration_seconds_bucket{method="find",type="mongo",le="0.01"} 0
+doppelganger_datasource_query_duration_seconds_bucket{method="find",type="mongo",le="0.025"} 1
+doppelganger_datasource_query_duration_seconds_bucket{method="find",type="mongo",le="0.05"} 1
+doppelganger_datasource_query_duration_seconds_bucket{method="find",type="mongo",le="0.1"} 1
+doppelganger_datasource_query_duration_seconds_bucket{method="find",type="mongo",le="0.25"} 1
+doppelganger_datasource_query_duration_seconds_bucket{method="find",type="mongo",le="0.5"} 1
+doppelganger_datasource_query_duration_seconds_bucket{method="find",type="mongo",le="1"} 1
+doppelganger_datasource_query_duration_seconds_bucket{method="find",type="mongo",le="2.5"} 1
+doppelganger_datasource_query_duration_seconds_bucket{method="find",type="mongo",le="5"} 1
+doppelganger_datasource_query_duration_seconds_bucket{method="find",type="mongo",le="10"} 1
+doppelganger_datasource_query_duration_seconds_bucket{method="find",type="mongo",le="+Inf"} 1
+doppelganger_datasource_query_duration_seconds_sum{method="find",type="mongo"} 0.02
+doppelganger_datasource_query_duration_seconds_count{method="find",type="mongo"} 1
+`,
+		},
+		{
+			description: "when a provider falls back should count it by both models",
+			record: func(r Recorder) {
+				r.Retry("gpt-4.1")
+				r.Fallback("gpt-4.1", "gpt-4.1-mini")
+			},
+			metric: "doppelganger_model_fallbacks_total",
+			expected: `
+# HELP doppelganger_model_fallbacks_total Model calls that fell back to another model.
+# TYPE doppelganger_model_fallbacks_total counter
+doppelganger_model_fallbacks_total{from="gpt-4.1",to="gpt-4.1-mini"} 1
+`,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			p, err := NewPrometheus(reg)
+			require.Nil(t, err)
+
+			test.record(p)
+
+			err = testutil.GatherAndCompare(reg, strings.NewReader(test.expected), test.metric)
+			require.Nil(t, err)
+		})
+	}
+}
+
+func TestNewPrometheusRegistersOnce(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	_, err := NewPrometheus(reg)
+	require.Nil(t, err)
+
+	_, err = NewPrometheus(reg)
+	require.NotNil(t, err)
+}
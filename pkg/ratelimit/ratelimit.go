@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrRateLimited is returned when a call could not get its turn in time.
+var ErrRateLimited = errors.New("rate limited")
+
+// Limits cap how often calls are made and how many run at once. Zero values
+// are unlimited.
+type Limits struct {
+	// Rate is the number of calls per second, in bursts of up to Burst
+	// calls. Burst defaults to a second of calls.
+	Rate  float64
+	Burst int
+	// MaxInFlight caps the calls running at once.
+	MaxInFlight int
+	// MaxWait caps how long a call queues for its turn. Zero queues until
+	// the deadline of its context.
+	MaxWait time.Duration
+}
+
+// Limiter enforces Limits with a token bucket and a semaphore. It is safe
+// for concurrent use.
+type Limiter struct {
+	limits Limits
+	bucket *rate.Limiter
+	slots  chan struct{}
+}
+
+func New(l Limits) *Limiter {
+	limiter := &Limiter{limits: l}
+	if l.Rate > 0 {
+		if l.Burst <= 0 {
+			l.Burst = int(math.Max(1, math.Ceil(l.Rate)))
+		}
+		limiter.bucket = rate.NewLimiter(rate.Limit(l.Rate), l.Burst)
+	}
+	if l.MaxInFlight > 0 {
+		limiter.slots = make(chan struct{}, l.MaxInFlight)
+	}
+
+	return limiter
+}
+
+// Acquire waits for the turn of a call, and returns the function to call
+// once it is done. It fails with ErrRateLimited, without waiting, when the
+// turn would come after the deadline of ctx or MaxWait.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	wait := ctx
+	if l.limits.MaxWait > 0 {
+		var cancel context.CancelFunc
+		wait, cancel = context.WithTimeout(ctx, l.limits.MaxWait)
+		defer cancel()
+	}
+
+	if l.bucket != nil {
+		err := l.bucket.Wait(wait)
+		if err != nil {
+			return nil, l.fail(ctx, fmt.Sprintf("more than %g calls per second", l.limits.Rate))
+		}
+	}
+
+	if l.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-wait.Done():
+		return nil, l.fail(ctx, fmt.Sprintf("more than %d calls in flight", l.limits.MaxInFlight))
+	}
+}
+
+// fail returns the error of a call that did not get its turn. Calls whose
+// caller went away are cancelled rather than limited.
+func (l *Limiter) fail(ctx context.Context, reason string) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return ctx.Err()
+	}
+
+	return fmt.Errorf("%w: %s", ErrRateLimited, reason)
+}
@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquire(t *testing.T) {
+	tt := []struct {
+		description   string
+		limits        Limits
+		held          int
+		ctx           func() (context.Context, context.CancelFunc)
+		expectedError error
+	}{
+		{
+			description: "when there are no limits should not wait",
+			held:        100,
+		},
+		{
+			description: "when the burst is not spent should not wait",
+			limits:      Limits{Rate: 1, Burst: 3},
+			held:        2,
+		},
+		{
+			description: "when the next token comes after the deadline should fail at once",
+			limits:      Limits{Rate: 0.1, Burst: 2},
+			held:        2,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), time.Second)
+			},
+			expectedError: ErrRateLimited,
+		},
+		{
+			description:   "when the next token comes after the maximum wait should fail at once",
+			limits:        Limits{Rate: 0.1, MaxWait: time.Second},
+			held:          1,
+			expectedError: ErrRateLimited,
+		},
+		{
+			description: "when the next token comes before the maximum wait should wait for it",
+			limits:      Limits{Rate: 100, MaxWait: time.Second},
+			held:        1,
+		},
+		{
+			description:   "when every slot is held until the maximum wait should fail",
+			limits:        Limits{MaxInFlight: 2, MaxWait: 10 * time.Millisecond},
+			held:          2,
+			expectedError: ErrRateLimited,
+		},
+		{
+			description: "when a slot is free should not wait",
+			limits:      Limits{MaxInFlight: 2, MaxWait: 10 * time.Millisecond},
+			held:        1,
+		},
+		{
+			description: "when the caller goes away while waiting should return its error",
+			limits:      Limits{MaxInFlight: 1},
+			held:        1,
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(10*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			expectedError: context.Canceled,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			l := New(test.limits)
+			for i := 0; i < test.held; i++ {
+				_, err := l.Acquire(context.Background())
+				require.Nil(t, err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if test.ctx != nil {
+				ctx, cancel = test.ctx()
+			}
+			defer cancel()
+
+			start := time.Now()
+			release, err := l.Acquire(ctx)
+			require.Less(t, time.Since(start), 500*time.Millisecond)
+			if test.expectedError != nil {
+				require.ErrorIs(t, err, test.expectedError)
+				return
+			}
+
+			require.Nil(t, err)
+			release()
+		})
+	}
+}
+
+func TestRelease(t *testing.T) {
+	l := New(Limits{MaxInFlight: 1, MaxWait: 10 * time.Millisecond})
+
+	release, err := l.Acquire(context.Background())
+	require.Nil(t, err)
+	_, err = l.Acquire(context.Background())
+	require.ErrorIs(t, err, ErrRateLimited)
+	require.EqualError(t, err, "rate limited: more than 1 calls in flight")
+
+	release()
+	_, err = l.Acquire(context.Background())
+	require.Nil(t, err)
+}
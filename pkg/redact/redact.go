@@ -0,0 +1,293 @@
+package redact
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// Kind is a kind of personal data found in text.
+type Kind string
+
+const (
+	IBAN  Kind = "iban"
+	Card  Kind = "card"
+	Email Kind = "email"
+	Phone Kind = "phone"
+	// Field is the kind of values redacted by their JSON path.
+	Field Kind = "field"
+)
+
+// Action is what is done with the personal data found.
+type Action string
+
+const (
+	// Mask replaces values with a placeholder naming their kind.
+	Mask Action = "mask"
+	// Tokenise replaces values with tokens that can be turned back into the
+	// values with a Vault.
+	Tokenise Action = "tokenise"
+)
+
+var detectors = map[Kind]struct {
+	pattern *regexp.Regexp
+	valid   func(string) bool
+}{
+	IBAN: {
+		pattern: regexp.MustCompile(`\b[A-Z]{2}[0-9]{2}(?: ?[A-Z0-9]){11,30}\b`),
+		valid:   validIBAN,
+	},
+	Card: {
+		pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		valid:   validLuhn,
+	},
+	Email: {
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	},
+	// International numbers starting with + and national numbers starting
+	// with 0. Other digit runs, such as dates and amounts, are left alone.
+	Phone: {
+		pattern: regexp.MustCompile(`(?:\+\d{1,3}|\b0)(?:[ .-]?\(?\d{1,4}\)?){2,5}\b`),
+		valid:   validPhone,
+	},
+}
+
+// order applies the detectors from the most to the least specific, so that
+// card numbers are not taken for phone numbers.
+var order = []Kind{IBAN, Card, Email, Phone}
+
+// Rules configure a Redactor.
+type Rules struct {
+	// Detect are the kinds of personal data searched for in every string.
+	Detect []Kind
+	// Fields are JSON paths, such as "email" or "customer.address", whose
+	// values are redacted whatever they hold. Arrays are searched through, so
+	// "accounts.iban" matches the iban of every account, and * matches any
+	// key.
+	Fields []string
+	// Action defaults to Mask.
+	Action Action
+}
+
+// Redactor removes personal data from tool results.
+type Redactor struct {
+	detect []Kind
+	fields [][]string
+	action Action
+}
+
+func New(rules Rules) (*Redactor, error) {
+	r := &Redactor{action: rules.Action}
+	if r.action == "" {
+		r.action = Mask
+	}
+	if r.action != Mask && r.action != Tokenise {
+		return nil, fmt.Errorf("unsupported redaction action %q, must be mask or tokenise", rules.Action)
+	}
+
+	wanted := make(map[Kind]bool)
+	for _, kind := range rules.Detect {
+		if _, ok := detectors[kind]; !ok {
+			return nil, fmt.Errorf("unsupported kind %q, must be iban, card, email or phone", kind)
+		}
+		wanted[kind] = true
+	}
+	for _, kind := range order {
+		if wanted[kind] {
+			r.detect = append(r.detect, kind)
+		}
+	}
+
+	for _, field := range rules.Fields {
+		path := strings.Split(strings.TrimPrefix(field, "$."), ".")
+		for _, key := range path {
+			if key == "" {
+				return nil, fmt.Errorf("invalid field path %q", field)
+			}
+		}
+		r.fields = append(r.fields, path)
+	}
+
+	return r, nil
+}
+
+// Rules returns the rules r was created with, with fields as paths joined by
+// dots and the default action filled in.
+func (r *Redactor) Rules() Rules {
+	rules := Rules{Detect: append([]Kind{}, r.detect...), Action: r.action}
+	for _, path := range r.fields {
+		rules.Fields = append(rules.Fields, strings.Join(path, "."))
+	}
+
+	return rules
+}
+
+// Redact returns the record without the personal data found. Records that
+// are JSON have their fields redacted and their strings searched; other
+// records are searched as text. Tokens are kept in v; without a vault values
+// are masked.
+func (r *Redactor) Redact(record string, v *Vault) string {
+	var doc interface{}
+	decoder := json.NewDecoder(strings.NewReader(record))
+	decoder.UseNumber()
+	if decoder.Decode(&doc) != nil || decoder.More() {
+		return r.text(record, v)
+	}
+
+	redacted, changed := r.value(doc, nil, v)
+	if !changed {
+		return record
+	}
+
+	out, err := json.Marshal(redacted)
+	if err != nil {
+		return r.text(record, v)
+	}
+
+	return string(out)
+}
+
+func (r *Redactor) value(value interface{}, path []string, v *Vault) (interface{}, bool) {
+	if r.matches(path) {
+		text, ok := value.(string)
+		if !ok {
+			raw, _ := json.Marshal(value)
+			text = string(raw)
+		}
+		return r.replace(Field, text, v), true
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		var changed bool
+		for key, elem := range val {
+			redacted, c := r.value(elem, append(path[:len(path):len(path)], key), v)
+			if c {
+				val[key] = redacted
+				changed = true
+			}
+		}
+		return val, changed
+	case []interface{}:
+		var changed bool
+		for i, elem := range val {
+			redacted, c := r.value(elem, path, v)
+			if c {
+				val[i] = redacted
+				changed = true
+			}
+		}
+		return val, changed
+	case string:
+		redacted := r.text(val, v)
+		return redacted, redacted != val
+	}
+
+	return value, false
+}
+
+func (r *Redactor) matches(path []string) bool {
+	for _, field := range r.fields {
+		if len(field) != len(path) {
+			continue
+		}
+
+		match := true
+		for i, key := range field {
+			if key != "*" && key != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Redactor) text(text string, v *Vault) string {
+	for _, kind := range r.detect {
+		d := detectors[kind]
+		text = d.pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if d.valid != nil && !d.valid(match) {
+				return match
+			}
+			return r.replace(kind, match, v)
+		})
+	}
+
+	return text
+}
+
+func (r *Redactor) replace(kind Kind, value string, v *Vault) string {
+	if r.action == Tokenise && v != nil {
+		return v.token(kind, value)
+	}
+
+	return "[REDACTED " + strings.ToUpper(string(kind)) + "]"
+}
+
+// validIBAN checks the ISO 13616 check digits.
+func validIBAN(match string) bool {
+	iban := strings.ReplaceAll(match, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+
+	var digits strings.Builder
+	for _, c := range iban[4:] + iban[:4] {
+		if c >= 'A' && c <= 'Z' {
+			fmt.Fprintf(&digits, "%d", c-'A'+10)
+		} else {
+			digits.WriteRune(c)
+		}
+	}
+
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	return ok && n.Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// validLuhn checks the check digit of card numbers.
+func validLuhn(match string) bool {
+	var sum int
+	var double bool
+	var count int
+	for i := len(match) - 1; i >= 0; i-- {
+		c := match[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		count++
+
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+
+	return count >= 13 && count <= 19 && sum%10 == 0
+}
+
+// validPhone requires the 8 to 15 digits of a full phone number.
+func validPhone(match string) bool {
+	var count int
+	for _, c := range match {
+		if c >= '0' && c <= '9' {
+			count++
+		}
+	}
+
+	return count >= 8 && count <= 15
+}
@@ -0,0 +1,140 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	tt := []struct {
+		description    string
+		rules          Rules
+		record         string
+		expectedRecord string
+	}{
+		{
+			description:    "when a record holds an IBAN should mask it",
+			rules:          Rules{Detect: []Kind{IBAN}},
+			record:         `Pay GB82 WEST 1234 5698 7654 32 today`,
+			expectedRecord: `Pay [REDACTED IBAN] today`,
+		},
+		{
+			description:    "when an IBAN has wrong check digits should leave it",
+			rules:          Rules{Detect: []Kind{IBAN}},
+			record:         `GB83WEST12345698765432`,
+			expectedRecord: `GB83WEST12345698765432`,
+		},
+		{
+			description:    "when a record holds a swift code should leave it",
+			rules:          Rules{Detect: []Kind{IBAN, Card, Email, Phone}},
+			record:         `{"swift":"BARCGB22","date":"2024-01-15","amount":1250.50}`,
+			expectedRecord: `{"swift":"BARCGB22","date":"2024-01-15","amount":1250.50}`,
+		},
+		{
+			description:    "when a record holds a card number should mask it only if it passes the Luhn check",
+			rules:          Rules{Detect: []Kind{Card}},
+			record:         `4111 1111 1111 1111 and 4111 1111 1111 1112`,
+			expectedRecord: `[REDACTED CARD] and 4111 1111 1111 1112`,
+		},
+		{
+			description:    "when a record holds emails and phone numbers should mask them",
+			rules:          Rules{Detect: []Kind{Email, Phone}},
+			record:         `jane.doe@example.com, +44 20 7946 0958 or 07946 095812`,
+			expectedRecord: `[REDACTED EMAIL], [REDACTED PHONE] or [REDACTED PHONE]`,
+		},
+		{
+			description:    "when a JSON record holds personal data in nested strings should mask them and keep the rest",
+			rules:          Rules{Detect: []Kind{Email}},
+			record:         `{"name":"Jane","contacts":[{"email":"jane@example.com"},{"note":"call jane@example.com"}]}`,
+			expectedRecord: `{"contacts":[{"email":"[REDACTED EMAIL]"},{"note":"call [REDACTED EMAIL]"}],"name":"Jane"}`,
+		},
+		{
+			description:    "when fields are named should redact their values whatever they hold",
+			rules:          Rules{Fields: []string{"name", "$.accounts.balance", "address.*"}},
+			record:         `{"name":"Jane","accounts":[{"balance":{"$numberDecimal":"10.5"}},{"balance":3}],"address":{"city":"Leeds"}}`,
+			expectedRecord: `{"accounts":[{"balance":"[REDACTED FIELD]"},{"balance":"[REDACTED FIELD]"}],"address":{"city":"[REDACTED FIELD]"},"name":"[REDACTED FIELD]"}`,
+		},
+		{
+			description:    "when nothing is found should return the record unchanged",
+			rules:          Rules{Detect: []Kind{Email}, Fields: []string{"email"}},
+			record:         `{ "b": 1, "a": 2 }`,
+			expectedRecord: `{ "b": 1, "a": 2 }`,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			r, err := New(test.rules)
+			require.Nil(t, err)
+
+			require.Equal(t, test.expectedRecord, r.Redact(test.record, NewVault()))
+		})
+	}
+}
+
+func TestTokenise(t *testing.T) {
+	r, err := New(Rules{Detect: []Kind{IBAN, Email}, Fields: []string{"id"}, Action: Tokenise})
+	require.Nil(t, err)
+
+	v := NewVault()
+	redacted := r.Redact(`{"id":42,"iban":"GB82WEST12345698765432","email":"jane@example.com","backup":"jane@example.com"}`, v)
+	require.Equal(t, `{"backup":"[EMAIL_1]","email":"[EMAIL_1]","iban":"[IBAN_1]","id":"[FIELD_1]"}`, redacted)
+
+	// The answer is de-tokenised for the end user
+	require.Equal(t, "Jane (42) pays from GB82WEST12345698765432, [PHONE_9] is unknown",
+		v.Detokenise("Jane ([FIELD_1]) pays from [IBAN_1], [PHONE_9] is unknown"))
+
+	// Tools receive the values behind the tokens the model passes on
+	args := map[string]interface{}{"email": "[EMAIL_1]", "filter": map[string]interface{}{"ids": []interface{}{"[FIELD_1]"}}}
+	v.DetokeniseArguments(args)
+	require.Equal(t, map[string]interface{}{"email": "jane@example.com", "filter": map[string]interface{}{"ids": []interface{}{"42"}}}, args)
+
+	// Answers sent back by clients are tokenised again before they reach a
+	// model, with the tokens the vault already gave and new ones
+	require.Equal(t, "[FIELD_1] pays from [IBAN_1], write to [EMAIL_1] or [EMAIL_2]",
+		v.Tokenise("42 pays from GB82WEST12345698765432, write to jane@example.com or john@example.com"))
+	require.Equal(t, "[IBAN_1]", NewVault().Tokenise("GB82WEST12345698765432"))
+
+	// Without a vault tokens cannot be resolved, so values are masked
+	require.Equal(t, `[REDACTED EMAIL]`, r.Redact(`jane@example.com`, nil))
+}
+
+func TestNew(t *testing.T) {
+	tt := []struct {
+		description   string
+		rules         Rules
+		expectedError bool
+	}{
+		{
+			description: "when the rules are valid should create a redactor",
+			rules:       Rules{Detect: []Kind{IBAN, Card, Email, Phone}, Fields: []string{"a.b"}, Action: Tokenise},
+		},
+		{
+			description:   "when the kind is unknown should return error",
+			rules:         Rules{Detect: []Kind{"passport"}},
+			expectedError: true,
+		},
+		{
+			description:   "when the action is unknown should return error",
+			rules:         Rules{Action: "hash"},
+			expectedError: true,
+		},
+		{
+			description:   "when a field path has an empty key should return error",
+			rules:         Rules{Fields: []string{"a..b"}},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			_, err := New(test.rules)
+			if test.expectedError {
+				require.NotNil(t, err)
+			} else {
+				require.Nil(t, err)
+			}
+		})
+	}
+}
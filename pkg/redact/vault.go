@@ -0,0 +1,134 @@
+package redact
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var tokenPattern = regexp.MustCompile(`\[(?:IBAN|CARD|EMAIL|PHONE|FIELD)_\d+\]`)
+
+// Vault keeps the values behind the tokens of a decision, so that the answer
+// can be de-tokenised for the end user while the model only sees tokens. The
+// same value always gets the same token.
+type Vault struct {
+	mu      sync.Mutex
+	values  map[string]string
+	tokens  map[string]string
+	counter map[Kind]int
+}
+
+func NewVault() *Vault {
+	return &Vault{
+		values:  make(map[string]string),
+		tokens:  make(map[string]string),
+		counter: make(map[Kind]int),
+	}
+}
+
+func (v *Vault) token(kind Kind, value string) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key := string(kind) + "\x00" + value
+	if token, ok := v.tokens[key]; ok {
+		return token
+	}
+
+	v.counter[kind]++
+	token := fmt.Sprintf("[%s_%d]", strings.ToUpper(string(kind)), v.counter[kind])
+	v.tokens[key] = token
+	v.values[token] = value
+
+	return token
+}
+
+// everyKind finds the personal data of every kind that can be detected.
+var everyKind = &Redactor{detect: order, action: Tokenise}
+
+// Tokenise replaces the values known to the vault, and the personal data
+// found in text, with their tokens. Use it on text that went back to the end
+// user de-tokenised, such as earlier answers of a conversation, before it is
+// sent to a model again.
+func (v *Vault) Tokenise(text string) string {
+	if v != nil {
+		v.mu.Lock()
+		tokens := make([]string, 0, len(v.values))
+		for token := range v.values {
+			tokens = append(tokens, token)
+		}
+		// Longer values first, so that a value holding another keeps its own
+		// token
+		sort.Slice(tokens, func(i, j int) bool {
+			return len(v.values[tokens[i]]) > len(v.values[tokens[j]])
+		})
+		for _, token := range tokens {
+			text = strings.ReplaceAll(text, v.values[token], token)
+		}
+		v.mu.Unlock()
+	}
+
+	return everyKind.text(text, v)
+}
+
+// Detokenise replaces the tokens of the vault in text with their values.
+// Unknown tokens are left as they are.
+func (v *Vault) Detokenise(text string) string {
+	if v == nil {
+		return text
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return tokenPattern.ReplaceAllStringFunc(text, func(token string) string {
+		if value, ok := v.values[token]; ok {
+			return value
+		}
+		return token
+	})
+}
+
+// DetokeniseArguments replaces tokens in the string values of tool
+// arguments, so that tools receive the values the model only knows by
+// token.
+func (v *Vault) DetokeniseArguments(args map[string]interface{}) {
+	if v == nil {
+		return
+	}
+
+	for key, value := range args {
+		args[key] = v.detokeniseValue(value)
+	}
+}
+
+func (v *Vault) detokeniseValue(value interface{}) interface{} {
+	switch val := value.(type) {
+	case string:
+		return v.Detokenise(val)
+	case map[string]interface{}:
+		v.DetokeniseArguments(val)
+	case []interface{}:
+		for i, elem := range val {
+			val[i] = v.detokeniseValue(elem)
+		}
+	}
+
+	return value
+}
+
+type vaultKey struct{}
+
+// WithVault makes v available to the tools called under ctx.
+func WithVault(ctx context.Context, v *Vault) context.Context {
+	return context.WithValue(ctx, vaultKey{}, v)
+}
+
+// VaultFrom returns the vault of ctx, or nil.
+func VaultFrom(ctx context.Context) *Vault {
+	v, _ := ctx.Value(vaultKey{}).(*Vault)
+	return v
+}
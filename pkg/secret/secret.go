@@ -0,0 +1,230 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("secret not found")
+
+// ErrNoBackend is returned for secret:// references when no resolver was
+// registered for them.
+var ErrNoBackend = errors.New("no secret backend configured")
+
+// Resolver looks up the value of a secret by name.
+type Resolver interface {
+	Resolve(ctx context.Context, name string) (string, error)
+}
+
+// ResolverFunc adapts a function to a Resolver, to plug in a secret manager.
+type ResolverFunc func(ctx context.Context, name string) (string, error)
+
+func (f ResolverFunc) Resolve(ctx context.Context, name string) (string, error) {
+	return f(ctx, name)
+}
+
+// Env resolves env://NAME from the environment.
+type Env struct{}
+
+func (Env) Resolve(ctx context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", notFound("environment variable %s is not set", name)
+	}
+
+	return value, nil
+}
+
+// File resolves file://PATH from the content of the file, without its
+// trailing newline. Relative paths are relative to Base.
+type File struct {
+	Base string
+}
+
+func (f File) Resolve(ctx context.Context, name string) (string, error) {
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(f.Base, path)
+	}
+
+	return readFile(path)
+}
+
+// Dir resolves secret://NAME from the file NAME in a directory, the way
+// Kubernetes and Docker mount secrets.
+type Dir struct {
+	Path string
+}
+
+func (d Dir) Resolve(ctx context.Context, name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || name == ".." {
+		return "", notFound("invalid secret name %q", name)
+	}
+
+	return readFile(filepath.Join(d.Path, name))
+}
+
+func readFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", notFound("%s does not exist", path)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Store resolves references such as env://MONGO_URI, file://mongo.txt or
+// secret://mongo-prod with the resolver registered for their scheme. Values
+// that are not references, including URLs such as mongodb://, are returned
+// as they are.
+type Store struct {
+	resolvers map[string]Resolver
+	ttl       time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cached
+	now   func() time.Time
+}
+
+type cached struct {
+	value   string
+	expires time.Time
+}
+
+type Option func(*Store)
+
+// WithResolver resolves references with the scheme using r, replacing the
+// built in env, file and secret resolvers or adding another.
+func WithResolver(scheme string, r Resolver) Option {
+	return func(s *Store) {
+		s.resolvers[scheme] = r
+	}
+}
+
+// WithTTL caches resolved values for ttl, for backends that are slow or
+// rate limited. Rotated values are picked up once it expires. By default
+// values are resolved every time.
+func WithTTL(ttl time.Duration) Option {
+	return func(s *Store) {
+		s.ttl = ttl
+	}
+}
+
+// NewStore returns a store resolving env:// and file:// references.
+// secret:// references fail with ErrNoBackend until a resolver is set for
+// them, such as Dir or a secret manager.
+func NewStore(opts ...Option) *Store {
+	s := &Store{
+		resolvers: map[string]Resolver{
+			"env":  Env{},
+			"file": File{},
+			"secret": ResolverFunc(func(ctx context.Context, name string) (string, error) {
+				return "", fmt.Errorf("%w for secret://%s", ErrNoBackend, name)
+			}),
+		},
+		cache: make(map[string]cached),
+		now:   time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// IsReference tells whether value is a reference the store resolves.
+func (s *Store) IsReference(value string) bool {
+	_, _, ok := s.parse(value)
+	return ok
+}
+
+// Resolve returns the value ref points to, or ref itself when it is not a
+// reference.
+func (s *Store) Resolve(ctx context.Context, ref string) (string, error) {
+	r, name, ok := s.parse(ref)
+	if !ok {
+		return ref, nil
+	}
+
+	if s.ttl > 0 {
+		s.mu.Lock()
+		c, ok := s.cache[ref]
+		s.mu.Unlock()
+		if ok && s.now().Before(c.expires) {
+			return c.value, nil
+		}
+	}
+
+	value, err := r.Resolve(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	if s.ttl > 0 {
+		s.mu.Lock()
+		s.cache[ref] = cached{value: value, expires: s.now().Add(s.ttl)}
+		s.mu.Unlock()
+	}
+
+	return value, nil
+}
+
+func (s *Store) parse(value string) (Resolver, string, bool) {
+	scheme, name, ok := strings.Cut(value, "://")
+	if !ok {
+		return nil, "", false
+	}
+
+	r, ok := s.resolvers[scheme]
+	return r, name, ok
+}
+
+// notFound returns an error wrapping ErrNotFound with only the message.
+func notFound(format string, args ...interface{}) error {
+	return &notFoundError{message: fmt.Sprintf(format, args...)}
+}
+
+type notFoundError struct {
+	message string
+}
+
+func (e *notFoundError) Error() string {
+	return e.message
+}
+
+func (e *notFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// Redact replaces value with ref in the message of err, for errors from
+// libraries that quote the connection strings they were given. It still
+// wraps err.
+func Redact(err error, value, ref string) error {
+	if err == nil || value == "" || value == ref || !strings.Contains(err.Error(), value) {
+		return err
+	}
+
+	return &redactedError{err: err, message: strings.ReplaceAll(err.Error(), value, ref)}
+}
+
+type redactedError struct {
+	err     error
+	message string
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,127 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "mongo-prod"), []byte("mongodb://app:s3cret@db:27017\n"), 0o600)
+	require.Nil(t, err)
+
+	tt := []struct {
+		description   string
+		opts          []Option
+		ref           string
+		expectedValue string
+		expectedError error
+	}{
+		{
+			description:   "When the value is not a reference, it is returned as it is",
+			ref:           "mongodb://localhost:27017",
+			expectedValue: "mongodb://localhost:27017",
+		},
+		{
+			description:   "When an environment variable is referenced, its value is returned",
+			ref:           "env://TEST_SECRET",
+			expectedValue: "abc",
+		},
+		{
+			description:   "When the environment variable is not set, not found is returned",
+			ref:           "env://TEST_UNSET_SECRET",
+			expectedError: ErrNotFound,
+		},
+		{
+			description:   "When a file is referenced, its content is returned without the trailing newline",
+			opts:          []Option{WithResolver("file", File{Base: dir})},
+			ref:           "file://mongo-prod",
+			expectedValue: "mongodb://app:s3cret@db:27017",
+		},
+		{
+			description:   "When the file does not exist, not found is returned",
+			ref:           "file://" + filepath.Join(dir, "missing"),
+			expectedError: ErrNotFound,
+		},
+		{
+			description:   "When a secret is read from a directory, the file named after it is returned",
+			opts:          []Option{WithResolver("secret", Dir{Path: dir})},
+			ref:           "secret://mongo-prod",
+			expectedValue: "mongodb://app:s3cret@db:27017",
+		},
+		{
+			description:   "When a secret name leaves the directory, not found is returned",
+			opts:          []Option{WithResolver("secret", Dir{Path: filepath.Join(dir, "sub")})},
+			ref:           "secret://../mongo-prod",
+			expectedError: ErrNotFound,
+		},
+		{
+			description:   "When no backend is set for secrets, an error is returned",
+			ref:           "secret://mongo-prod",
+			expectedError: ErrNoBackend,
+		},
+		{
+			description: "When a backend is plugged in, it resolves its scheme",
+			opts: []Option{WithResolver("vault", ResolverFunc(func(ctx context.Context, name string) (string, error) {
+				return "from vault " + name, nil
+			}))},
+			ref:           "vault://kv/mongo",
+			expectedValue: "from vault kv/mongo",
+		},
+	}
+
+	t.Setenv("TEST_SECRET", "abc")
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			value, err := NewStore(test.opts...).Resolve(context.Background(), test.ref)
+			if test.expectedError != nil {
+				require.ErrorIs(t, err, test.expectedError)
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, test.expectedValue, value)
+		})
+	}
+}
+
+func TestTTL(t *testing.T) {
+	calls := 0
+	s := NewStore(
+		WithTTL(time.Minute),
+		WithResolver("vault", ResolverFunc(func(ctx context.Context, name string) (string, error) {
+			calls += 1
+			return name, nil
+		})),
+	)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		_, err := s.Resolve(context.Background(), "vault://key")
+		require.Nil(t, err)
+	}
+	require.Equal(t, 1, calls)
+
+	now = now.Add(2 * time.Minute)
+	_, err := s.Resolve(context.Background(), "vault://key")
+	require.Nil(t, err)
+	require.Equal(t, 2, calls)
+}
+
+func TestRedact(t *testing.T) {
+	cause := errors.New(`invalid base url "ftp://user:pw@host"`)
+
+	err := Redact(cause, "ftp://user:pw@host", "secret://cases")
+	require.Equal(t, `invalid base url "secret://cases"`, err.Error())
+	require.ErrorIs(t, err, cause)
+
+	require.Equal(t, cause, Redact(cause, "unrelated", "secret://cases"))
+}
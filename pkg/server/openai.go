@@ -0,0 +1,290 @@
+package server
+
+import (
+	"context"
+	"doppelganger"
+	"doppelganger/pkg/redact"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// The OpenAI compatible endpoints let OpenAI SDKs and UIs talk to the agent.
+// The model of a request names an agent profile, and tools run server-side,
+// so clients only ever see the final answer.
+
+type chatCompletionRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+}
+
+type chatMessage struct {
+	Role    string      `json:"role"`
+	Content chatContent `json:"content"`
+}
+
+// chatContent is either a string or a list of content parts, of which only
+// text parts are supported.
+type chatContent string
+
+func (c *chatContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = chatContent(text)
+		return nil
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	err := json.Unmarshal(data, &parts)
+	if err != nil {
+		return fmt.Errorf("content must be a string or a list of parts")
+	}
+
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type != "text" {
+			return fmt.Errorf("content parts of type %q are not supported", part.Type)
+		}
+		texts = append(texts, part.Text)
+	}
+
+	*c = chatContent(strings.Join(texts, "\n"))
+	return nil
+}
+
+type chatCompletion struct {
+	ID      string       `json:"id"`
+	Object  string       `json:"object"`
+	Created int64        `json:"created"`
+	Model   string       `json:"model"`
+	Choices []chatChoice `json:"choices"`
+}
+
+type chatChoice struct {
+	Index        int                `json:"index"`
+	Message      *chatMessageOutput `json:"message,omitempty"`
+	Delta        *chatMessageOutput `json:"delta,omitempty"`
+	FinishReason *string            `json:"finish_reason"`
+}
+
+type chatMessageOutput struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type modelList struct {
+	Object string  `json:"object"`
+	Data   []model `json:"data"`
+}
+
+type model struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type openAIError struct {
+	Error openAIErrorBody `json:"error"`
+}
+
+type openAIErrorBody struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Code    *string `json:"code"`
+}
+
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	models := modelList{Object: "list", Data: []model{}}
+	for _, p := range s.profiles {
+		models.Data = append(models.Data, model{
+			ID:      p.Name,
+			Object:  "model",
+			Created: s.started.Unix(),
+			OwnedBy: "doppelganger",
+		})
+	}
+
+	writeJSON(w, http.StatusOK, models)
+}
+
+func (s *Server) handleChatCompletion(w http.ResponseWriter, r *http.Request) {
+	id := correlationID(w, r)
+
+	var body chatCompletionRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&body)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	profile, ok := s.profile(body.Model)
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "model_not_found", fmt.Sprintf("the model %q does not exist", body.Model))
+		return
+	}
+
+	req, err := chatRequest(profile, body.Messages)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+		return
+	}
+	req.CorrelationID = id
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+
+	completion := chatCompletion{
+		ID:      "chatcmpl-" + uuid.NewString(),
+		Created: time.Now().Unix(),
+		Model:   profile.Name,
+	}
+
+	if body.Stream {
+		s.streamChatCompletion(ctx, w, req, completion)
+		return
+	}
+
+	res, err := s.agent.Decide(ctx, req)
+	if err != nil {
+		status, code := errorStatus(ctx, err)
+		writeOpenAIError(w, status, "server_error", code, err.Error())
+		return
+	}
+
+	completion.Object = "chat.completion"
+	completion.Choices = []chatChoice{
+		{
+			Message:      &chatMessageOutput{Role: "assistant", Content: res.Answer},
+			FinishReason: finishReason("stop"),
+		},
+	}
+	writeJSON(w, http.StatusOK, completion)
+}
+
+// streamChatCompletion streams the answer as chat.completion.chunk events,
+// ending with [DONE] like the OpenAI API.
+func (s *Server) streamChatCompletion(ctx context.Context, w http.ResponseWriter, req doppelganger.DecisionRequest, completion chatCompletion) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "", "streaming is not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	completion.Object = "chat.completion.chunk"
+	send := func(data interface{}) error {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "data: %s\n\n", payload)
+		if err != nil {
+			return err
+		}
+
+		flusher.Flush()
+		return nil
+	}
+	sendDelta := func(delta chatMessageOutput, reason *string) error {
+		completion.Choices = []chatChoice{{Delta: &delta, FinishReason: reason}}
+		return send(completion)
+	}
+
+	sendDelta(chatMessageOutput{Role: "assistant"}, nil)
+
+	req.StreamingFunc = func(ctx context.Context, chunk []byte) error {
+		return sendDelta(chatMessageOutput{Content: string(chunk)}, nil)
+	}
+
+	_, err := s.agent.Decide(ctx, req)
+	if err != nil {
+		_, code := errorStatus(ctx, err)
+		send(openAIError{Error: openAIErrorBody{Message: err.Error(), Type: "server_error", Code: &code}})
+		return
+	}
+
+	sendDelta(chatMessageOutput{}, finishReason("stop"))
+
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+func (s *Server) profile(name string) (doppelganger.Profile, bool) {
+	for _, p := range s.profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	return doppelganger.Profile{}, false
+}
+
+// chatRequest turns chat messages into a decision request for the profile.
+// System messages are added to the instructions of profiles that allow them,
+// and the last message must come from the user. Earlier answers reached the client
+// de-tokenised, so the personal data in them is tokenised again before the
+// model sees it.
+func chatRequest(profile doppelganger.Profile, messages []chatMessage) (doppelganger.DecisionRequest, error) {
+	if len(messages) == 0 {
+		return doppelganger.DecisionRequest{}, fmt.Errorf("messages must not be empty")
+	}
+
+	last := messages[len(messages)-1]
+	if last.Role != "user" {
+		return doppelganger.DecisionRequest{}, fmt.Errorf("the last message must have the role user")
+	}
+
+	req := profile.Request(string(last.Content))
+	req.Vault = redact.NewVault()
+
+	var system []string
+	if req.SystemInstruction != "" {
+		system = append(system, req.SystemInstruction)
+	}
+
+	for _, m := range messages[:len(messages)-1] {
+		switch m.Role {
+		case "system", "developer":
+			if !profile.AllowSystemMessages {
+				return doppelganger.DecisionRequest{}, fmt.Errorf("messages with the role %q are not allowed for the agent %s", m.Role, profile.Name)
+			}
+			system = append(system, string(m.Content))
+		case "user":
+			req.History = append(req.History, llms.TextParts(llms.ChatMessageTypeHuman, string(m.Content)))
+		case "assistant":
+			req.History = append(req.History, llms.TextParts(llms.ChatMessageTypeAI, req.Vault.Tokenise(string(m.Content))))
+		default:
+			return doppelganger.DecisionRequest{}, fmt.Errorf("messages with the role %q are not supported, tools run on the server", m.Role)
+		}
+	}
+	req.SystemInstruction = strings.Join(system, "\n\n")
+
+	return req, nil
+}
+
+func finishReason(reason string) *string {
+	return &reason
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, errType, code, message string) {
+	body := openAIErrorBody{Message: message, Type: errType}
+	if code != "" {
+		body.Code = &code
+	}
+
+	writeJSON(w, status, openAIError{Error: body})
+}
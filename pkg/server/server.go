@@ -0,0 +1,434 @@
+package server
+
+import (
+	"context"
+	"doppelganger"
+	"doppelganger/pkg/auth"
+	"doppelganger/pkg/llm"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	jsoniter "github.com/json-iterator/go"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const maxRequestBytes = 1 << 20
+
+// correlationHeader carries the correlation ID of a decision. A caller may
+// set it to correlate the logs of the decision with its own, and it is set on
+// every response.
+const correlationHeader = "X-Correlation-ID"
+
+// Server exposes an agent over HTTP:
+//
+//	POST /v1/decisions         makes a decision and returns the answer
+//	POST /v1/decisions/stream  makes a decision and streams its progress as server-sent events
+//	GET  /v1/tools             lists the tools the caller may use
+//	POST /v1/chat/completions  OpenAI compatible chat completions, answered by agent profiles
+//	GET  /v1/models            lists the agent profiles as OpenAI models
+//	GET  /metrics              metrics, when WithMetricsHandler is used
+type Server struct {
+	agent           *doppelganger.Doppelganger
+	profiles        []doppelganger.Profile
+	defaultModel    string
+	models          map[string]bool
+	timeout         time.Duration
+	maxTimeout      time.Duration
+	shutdownTimeout time.Duration
+	metricsHandler  http.Handler
+	authenticate    auth.Authenticator
+	mux             *http.ServeMux
+	started         time.Time
+}
+
+type Option func(*Server)
+
+// WithDefaultModel sets the model used when a request does not name one.
+func WithDefaultModel(model string) Option {
+	return func(s *Server) {
+		s.defaultModel = model
+	}
+}
+
+// WithProfiles sets the agent profiles served by the chat completions
+// endpoint, where the model of a request names the profile.
+func WithProfiles(profiles ...doppelganger.Profile) Option {
+	return func(s *Server) {
+		s.profiles = profiles
+	}
+}
+
+// WithModels restricts the models requests may select. By default any model
+// supported by the agent can be used.
+func WithModels(models ...string) Option {
+	return func(s *Server) {
+		s.models = make(map[string]bool)
+		for _, model := range models {
+			s.models[model] = true
+		}
+	}
+}
+
+// WithTimeout sets the time a decision may take when the request does not
+// ask for one, and the longest a request may ask for.
+func WithTimeout(timeout, max time.Duration) Option {
+	return func(s *Server) {
+		s.timeout = timeout
+		s.maxTimeout = max
+	}
+}
+
+// WithShutdownTimeout bounds how long ListenAndServe waits for in-flight
+// requests when shutting down.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.shutdownTimeout = timeout
+	}
+}
+
+// WithMetricsHandler serves h on GET /metrics, such as promhttp.HandlerFor
+// the registry of a metrics.Prometheus recorder.
+func WithMetricsHandler(h http.Handler) Option {
+	return func(s *Server) {
+		s.metricsHandler = h
+	}
+}
+
+// WithAuthenticator authenticates every /v1 request, answering 401 when it
+// fails. The principal it returns decides which tools the caller may use and
+// the constraints applied to their queries.
+func WithAuthenticator(authenticate auth.Authenticator) Option {
+	return func(s *Server) {
+		s.authenticate = authenticate
+	}
+}
+
+func New(agent *doppelganger.Doppelganger, opts ...Option) *Server {
+	s := &Server{
+		agent:           agent,
+		defaultModel:    "gpt-4.1",
+		timeout:         time.Minute,
+		maxTimeout:      5 * time.Minute,
+		shutdownTimeout: 30 * time.Second,
+		mux:             http.NewServeMux(),
+		started:         time.Now(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	api := http.NewServeMux()
+	api.HandleFunc("POST /v1/decisions", s.handleDecision)
+	api.HandleFunc("POST /v1/decisions/stream", s.handleDecisionStream)
+	api.HandleFunc("GET /v1/tools", s.handleTools)
+	api.HandleFunc("POST /v1/chat/completions", s.handleChatCompletion)
+	api.HandleFunc("GET /v1/models", s.handleModels)
+	if s.authenticate != nil {
+		s.mux.Handle("/v1/", auth.Middleware(s.authenticate, api))
+	} else {
+		s.mux.Handle("/v1/", api)
+	}
+	if s.metricsHandler != nil {
+		s.mux.Handle("GET /metrics", s.metricsHandler)
+	}
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves on addr until ctx is done, then stops accepting
+// requests, waits for the in-flight ones and closes the data sources of the
+// agent.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ctx, listener)
+}
+
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	httpServer := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- httpServer.Serve(listener)
+	}()
+
+	var serveErr error
+	select {
+	case err := <-errCh:
+		serveErr = err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.shutdownTimeout)
+		defer cancel()
+
+		err := httpServer.Shutdown(shutdownCtx)
+		if err != nil {
+			serveErr = fmt.Errorf("shutting down: %w", err)
+			httpServer.Close()
+		}
+	}
+
+	closeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.shutdownTimeout)
+	defer cancel()
+
+	return errors.Join(serveErr, s.agent.Close(closeCtx))
+}
+
+type decisionRequest struct {
+	System    string `json:"system"`
+	Prompt    string `json:"prompt"`
+	Model     string `json:"model"`
+	TimeoutMs int64  `json:"timeoutMs"`
+}
+
+type decisionResponse struct {
+	CorrelationID string             `json:"correlationId"`
+	Answer        string             `json:"answer"`
+	ToolCalls     []toolCallResponse `json:"toolCalls"`
+	CacheHits     int                `json:"cacheHits,omitempty"`
+	CacheMisses   int                `json:"cacheMisses,omitempty"`
+	Cached        bool               `json:"cached,omitempty"`
+}
+
+type toolCallResponse struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Arguments  string `json:"arguments"`
+	Result     string `json:"result"`
+	DurationMs int64  `json:"durationMs"`
+	Cache      string `json:"cache,omitempty"`
+}
+
+type errorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+type toolResponse struct {
+	Name             string                 `json:"name"`
+	Description      string                 `json:"description"`
+	Parameters       map[string]interface{} `json:"parameters"`
+	Source           string                 `json:"source"`
+	Method           string                 `json:"method"`
+	RequiresApproval bool                   `json:"requiresApproval"`
+}
+
+func (s *Server) handleDecision(w http.ResponseWriter, r *http.Request) {
+	id := correlationID(w, r)
+	req, timeout, err := s.readDecisionRequest(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	req.CorrelationID = id
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	res, err := s.agent.Decide(ctx, req)
+	if err != nil {
+		status, code := errorStatus(ctx, err)
+		writeError(w, status, code, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newDecisionResponse(res))
+}
+
+// handleDecisionStream sends a tool_call event after every tool call, a token
+// event with the text of the answer, and finally an answer or error event.
+func (s *Server) handleDecisionStream(w http.ResponseWriter, r *http.Request) {
+	id := correlationID(w, r)
+	req, timeout, err := s.readDecisionRequest(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+		return
+	}
+	req.CorrelationID = id
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "internal", "streaming is not supported")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event string, data interface{}) error {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		if err != nil {
+			return err
+		}
+
+		flusher.Flush()
+		return nil
+	}
+
+	req.OnToolCall = func(trace doppelganger.ToolCallTrace) {
+		send("tool_call", newToolCallResponse(trace))
+	}
+	req.StreamingFunc = func(ctx context.Context, chunk []byte) error {
+		return send("token", map[string]string{"text": string(chunk)})
+	}
+
+	res, err := s.agent.Decide(ctx, req)
+	if err != nil {
+		_, code := errorStatus(ctx, err)
+		send("error", errorResponse{Error: code, Message: err.Error()})
+		return
+	}
+
+	send("answer", newDecisionResponse(res))
+}
+
+func (s *Server) handleTools(w http.ResponseWriter, r *http.Request) {
+	tools := []toolResponse{}
+	for _, t := range s.agent.AllowedTools(r.Context()) {
+		res := toolResponse{
+			Name:             t.Name,
+			Description:      t.Description,
+			Parameters:       t.Parameters,
+			Method:           t.Method,
+			RequiresApproval: t.RequiresApproval,
+		}
+		if t.Source != nil {
+			res.Source = t.Source.Type()
+		}
+
+		tools = append(tools, res)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tools": tools})
+}
+
+// readDecisionRequest decodes and validates the body, and works out the
+// model and timeout to use.
+func (s *Server) readDecisionRequest(w http.ResponseWriter, r *http.Request) (doppelganger.DecisionRequest, time.Duration, error) {
+	var body decisionRequest
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&body)
+	if err != nil {
+		return doppelganger.DecisionRequest{}, 0, fmt.Errorf("invalid request body: %w", err)
+	}
+
+	if body.Prompt == "" {
+		return doppelganger.DecisionRequest{}, 0, fmt.Errorf("prompt is required")
+	}
+
+	model := body.Model
+	if model == "" {
+		model = s.defaultModel
+	}
+	if s.models != nil && !s.models[model] {
+		return doppelganger.DecisionRequest{}, 0, fmt.Errorf("model %q is not available", model)
+	}
+
+	timeout := s.timeout
+	if body.TimeoutMs < 0 {
+		return doppelganger.DecisionRequest{}, 0, fmt.Errorf("timeoutMs must not be negative")
+	}
+	if body.TimeoutMs > 0 {
+		timeout = time.Duration(body.TimeoutMs) * time.Millisecond
+	}
+	if s.maxTimeout > 0 && timeout > s.maxTimeout {
+		return doppelganger.DecisionRequest{}, 0, fmt.Errorf("timeoutMs must be at most %d", s.maxTimeout.Milliseconds())
+	}
+
+	return doppelganger.DecisionRequest{
+		SystemInstruction: body.System,
+		UserInstruction:   body.Prompt,
+		Model:             model,
+	}, timeout, nil
+}
+
+// correlationID returns the correlation ID sent by the caller, or a new one
+// when it is missing or too long, and sets it on the response.
+func correlationID(w http.ResponseWriter, r *http.Request) string {
+	id := r.Header.Get(correlationHeader)
+	if id == "" || len(id) > 128 {
+		id = uuid.NewString()
+	}
+	w.Header().Set(correlationHeader, id)
+
+	return id
+}
+
+func newDecisionResponse(res *doppelganger.DecisionResult) decisionResponse {
+	response := decisionResponse{
+		CorrelationID: res.CorrelationID,
+		Answer:        res.Answer,
+		ToolCalls:     []toolCallResponse{},
+		CacheHits:     res.CacheHits,
+		CacheMisses:   res.CacheMisses,
+		Cached:        res.Cached,
+	}
+	for _, trace := range res.ToolCalls {
+		response.ToolCalls = append(response.ToolCalls, newToolCallResponse(trace))
+	}
+
+	return response
+}
+
+func newToolCallResponse(trace doppelganger.ToolCallTrace) toolCallResponse {
+	return toolCallResponse{
+		ID:         trace.ID,
+		Name:       trace.Name,
+		Arguments:  trace.Arguments,
+		Result:     trace.Result,
+		DurationMs: trace.Duration.Milliseconds(),
+		Cache:      trace.Cache,
+	}
+}
+
+func errorStatus(ctx context.Context, err error) (int, string) {
+	switch {
+	case errors.Is(err, llm.ErrModelNotFound):
+		return http.StatusBadRequest, "model_not_found"
+	case errors.Is(err, auth.ErrForbidden):
+		return http.StatusForbidden, "forbidden"
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "timeout"
+	case errors.Is(ctx.Err(), context.Canceled):
+		// The client went away, nobody reads the status
+		return http.StatusServiceUnavailable, "canceled"
+	}
+
+	return http.StatusInternalServerError, "internal"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, errorResponse{Error: code, Message: message})
+}
@@ -0,0 +1,417 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"doppelganger"
+	"doppelganger/pkg/auth"
+	"doppelganger/pkg/datasource"
+	"doppelganger/pkg/llm"
+	"doppelganger/pkg/tool"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+type mockDatasource struct {
+	mu     sync.Mutex
+	closed int
+}
+
+func (m *mockDatasource) Connect(ctx context.Context, connectionString string) error {
+	return nil
+}
+
+func (m *mockDatasource) Close(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed += 1
+	return nil
+}
+
+func (m *mockDatasource) Query(ctx context.Context, database, method, collection, query string, opts ...datasource.QueryOption) ([]string, error) {
+	return []string{query}, nil
+}
+
+func (m *mockDatasource) Type() string {
+	return "mock"
+}
+
+// mockProvider calls the get_user tool once, then answers with the model
+// name. The "slow" model waits for the context to be done. The text of every
+// message it is sent is kept in seen, when set.
+type mockProvider struct {
+	model string
+	seen  *[]string
+}
+
+func (m *mockProvider) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	if m.model == "slow" {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	if m.seen != nil {
+		for _, message := range messages {
+			for _, part := range message.Parts {
+				if text, ok := part.(llms.TextContent); ok {
+					*m.seen = append(*m.seen, text.Text)
+				}
+			}
+		}
+	}
+
+	if messages[len(messages)-1].Role != llms.ChatMessageTypeTool {
+		// Like the OpenAI client, stream the tool calls as JSON
+		if opts.StreamingFunc != nil {
+			err := opts.StreamingFunc(ctx, []byte(`[{"id":"1","type":"function","function":{"name":"get_user","arguments":"{\"id\":\"42\"}"}}]`))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return &llms.ContentResponse{
+			Choices: []*llms.ContentChoice{
+				{
+					ToolCalls: []llms.ToolCall{
+						{ID: "1", FunctionCall: &llms.FunctionCall{Name: "get_user", Arguments: `{"id":"42"}`}},
+					},
+				},
+			},
+		}, nil
+	}
+
+	answer := "answered by " + m.model
+	if opts.StreamingFunc != nil {
+		for _, word := range strings.SplitAfter(answer, " ") {
+			err := opts.StreamingFunc(ctx, []byte(word))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: answer}}}, nil
+}
+
+func (m *mockProvider) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return "", fmt.Errorf("not supported")
+}
+
+func newTestAgent(t *testing.T, source *mockDatasource) *doppelganger.Doppelganger {
+	d := doppelganger.New(doppelganger.WithProviderGenerator(func(model string) (llms.Model, error) {
+		if model == "unknown" {
+			return nil, llm.ErrModelNotFound
+		}
+		return &mockProvider{model: model}, nil
+	}))
+
+	err := d.RegisterTool(tool.DataSourceTool{
+		Name:        "get_user",
+		Description: "Gets a user",
+		Parameters:  map[string]any{"type": "object"},
+		Method:      "findOne",
+		Query:       `{"id":"{{ .id }}"}`,
+		Source:      source,
+	})
+	require.Nil(t, err)
+
+	return d
+}
+
+func TestDecisions(t *testing.T) {
+	tt := []struct {
+		description    string
+		opts           []Option
+		body           string
+		expectedStatus int
+		expectedBody   []string
+	}{
+		{
+			description:    "when the request is valid should return the answer and tool calls",
+			body:           `{"system":"abc","prompt":"who is 42?"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`"answer":"answered by gpt-4.1"`, `"name":"get_user"`, `"arguments":"{\"id\":\"42\"}"`},
+		},
+		{
+			description:    "when the request selects a model should use it",
+			body:           `{"prompt":"who is 42?","model":"claude-sonnet-4"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`"answer":"answered by claude-sonnet-4"`},
+		},
+		{
+			description:    "when the default model is changed should use it",
+			opts:           []Option{WithDefaultModel("claude-sonnet-4")},
+			body:           `{"prompt":"who is 42?"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`"answer":"answered by claude-sonnet-4"`},
+		},
+		{
+			description:    "when the model is not allowed should return bad request",
+			opts:           []Option{WithModels("gpt-4.1")},
+			body:           `{"prompt":"who is 42?","model":"claude-sonnet-4"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"error":"bad_request"`},
+		},
+		{
+			description:    "when the model does not exist should return bad request",
+			body:           `{"prompt":"who is 42?","model":"unknown"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"error":"model_not_found"`},
+		},
+		{
+			description:    "when the prompt is missing should return bad request",
+			body:           `{"system":"abc"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`prompt is required`},
+		},
+		{
+			description:    "when the body has unknown fields should return bad request",
+			body:           `{"prompt":"who is 42?","temperature":1}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			description:    "when the decision takes longer than the timeout should return gateway timeout",
+			body:           `{"prompt":"who is 42?","model":"slow","timeoutMs":10}`,
+			expectedStatus: http.StatusGatewayTimeout,
+			expectedBody:   []string{`"error":"timeout"`},
+		},
+		{
+			description:    "when the timeout is above the maximum should return bad request",
+			opts:           []Option{WithTimeout(time.Second, 2*time.Second)},
+			body:           `{"prompt":"who is 42?","timeoutMs":5000}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			s := New(newTestAgent(t, &mockDatasource{}), test.opts...)
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/decisions", strings.NewReader(test.body))
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+
+			require.Equal(t, test.expectedStatus, rec.Code)
+			for _, expected := range test.expectedBody {
+				require.Contains(t, rec.Body.String(), expected)
+			}
+		})
+	}
+}
+
+func TestCorrelationID(t *testing.T) {
+	tt := []struct {
+		description string
+		header      string
+		expectedID  string
+	}{
+		{
+			description: "when the caller sends a correlation ID should use it for the decision and return it",
+			header:      "req-123",
+			expectedID:  "req-123",
+		},
+		{
+			description: "when the caller sends no correlation ID should generate one",
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			s := New(newTestAgent(t, &mockDatasource{}))
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/decisions", strings.NewReader(`{"prompt":"who is 42?"}`))
+			if test.header != "" {
+				req.Header.Set("X-Correlation-ID", test.header)
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+
+			require.Equal(t, http.StatusOK, rec.Code)
+
+			var body decisionResponse
+			err := json.Unmarshal(rec.Body.Bytes(), &body)
+			require.Nil(t, err)
+
+			id := rec.Header().Get("X-Correlation-ID")
+			require.NotEmpty(t, id)
+			require.Equal(t, id, body.CorrelationID)
+			if test.expectedID != "" {
+				require.Equal(t, test.expectedID, id)
+			}
+		})
+	}
+}
+
+func TestDecisionStream(t *testing.T) {
+	s := New(newTestAgent(t, &mockDatasource{}))
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	res, err := http.Post(server.URL+"/v1/decisions/stream", "application/json", strings.NewReader(`{"prompt":"who is 42?"}`))
+	require.Nil(t, err)
+	defer res.Body.Close()
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	var events, data []string
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		if event, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+			events = append(events, event)
+		}
+		if d, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			data = append(data, d)
+		}
+	}
+	require.Nil(t, scanner.Err())
+	require.Equal(t, []string{"tool_call", "token", "answer"}, events)
+	// Only the answer is sent, not the tool calls of the model
+	require.Equal(t, `{"text":"answered by gpt-4.1"}`, data[1])
+}
+
+func TestTools(t *testing.T) {
+	s := New(newTestAgent(t, &mockDatasource{}))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/tools", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"tools":[{"name":"get_user","description":"Gets a user","parameters":{"type":"object"},"source":"mock","method":"findOne","requiresApproval":false}]}`, rec.Body.String())
+}
+
+func TestAuthenticator(t *testing.T) {
+	authenticate := func(r *http.Request) (*auth.Principal, error) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer teller":
+			return &auth.Principal{Subject: "bob", Roles: []string{"teller"}}, nil
+		case "Bearer customer":
+			return &auth.Principal{Subject: "alice", Roles: []string{"customer"}}, nil
+		}
+		return nil, errors.New("invalid token")
+	}
+
+	tt := []struct {
+		description    string
+		token          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			description:    "when the request is not authenticated should return unauthorized",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"unauthorized","message":"invalid token"}`,
+		},
+		{
+			description:    "when the caller has the role of a tool should list it",
+			token:          "teller",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"tools":[{"name":"get_user","description":"Gets a user","parameters":{"type":"object"},"source":"mock","method":"findOne","requiresApproval":false}]}`,
+		},
+		{
+			description:    "when the caller lacks the role of a tool should not list it",
+			token:          "customer",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"tools":[]}`,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			agent := doppelganger.New()
+			err := agent.RegisterTool(tool.DataSourceTool{
+				Name:        "get_user",
+				Description: "Gets a user",
+				Parameters:  map[string]any{"type": "object"},
+				Method:      "findOne",
+				Query:       `{"id":"{{ .id }}"}`,
+				Source:      &mockDatasource{},
+				Roles:       []string{"teller"},
+			})
+			require.Nil(t, err)
+			s := New(agent, WithAuthenticator(authenticate))
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/tools", nil)
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+
+			require.Equal(t, test.expectedStatus, rec.Code)
+			require.JSONEq(t, test.expectedBody, rec.Body.String())
+		})
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	tt := []struct {
+		description    string
+		opts           []Option
+		expectedStatus int
+	}{
+		{
+			description:    "when a metrics handler is set should serve it on /metrics",
+			opts:           []Option{WithMetricsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("metrics")) }))},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			description:    "when no metrics handler is set should not serve /metrics",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			s := New(newTestAgent(t, &mockDatasource{}), test.opts...)
+
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+
+			require.Equal(t, test.expectedStatus, rec.Code)
+		})
+	}
+}
+
+func TestServeShutdown(t *testing.T) {
+	source := &mockDatasource{}
+	s := New(newTestAgent(t, source))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(ctx, listener)
+	}()
+
+	res, err := http.Get("http://" + listener.Addr().String() + "/v1/tools")
+	require.Nil(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	cancel()
+	require.Nil(t, <-done)
+	require.Equal(t, 1, source.closed)
+
+	_, err = http.Get("http://" + listener.Addr().String() + "/v1/tools")
+	require.NotNil(t, err)
+}
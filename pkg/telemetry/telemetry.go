@@ -0,0 +1,110 @@
+package telemetry
+
+import (
+	"context"
+
+	"github.com/tmc/langchaingo/llms"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the instrumentation name of the spans emitted by Doppelganger.
+const TracerName = "doppelganger"
+
+// Attribute keys, following the OpenTelemetry semantic conventions for
+// generative AI and databases where they exist.
+const (
+	OperationName  = attribute.Key("gen_ai.operation.name")
+	RequestModel   = attribute.Key("gen_ai.request.model")
+	ResponseModel  = attribute.Key("gen_ai.response.model")
+	InputTokens    = attribute.Key("gen_ai.usage.input_tokens")
+	OutputTokens   = attribute.Key("gen_ai.usage.output_tokens")
+	FinishReasons  = attribute.Key("gen_ai.response.finish_reasons")
+	ToolName       = attribute.Key("gen_ai.tool.name")
+	ToolCallID     = attribute.Key("gen_ai.tool.call.id")
+	Prompt         = attribute.Key("gen_ai.prompt")
+	Completion     = attribute.Key("gen_ai.completion")
+	ToolArguments  = attribute.Key("gen_ai.tool.call.arguments")
+	ToolResult     = attribute.Key("gen_ai.tool.call.result")
+	DBSystem       = attribute.Key("db.system.name")
+	DBOperation    = attribute.Key("db.operation.name")
+	DBNamespace    = attribute.Key("db.namespace")
+	DBCollection   = attribute.Key("db.collection.name")
+	DBQueryText    = attribute.Key("db.query.text")
+	DBReturnedRows = attribute.Key("db.response.returned_rows")
+
+	Round         = attribute.Key("doppelganger.round")
+	Rounds        = attribute.Key("doppelganger.rounds")
+	ToolCalls     = attribute.Key("doppelganger.tool_calls")
+	ArgumentsSize = attribute.Key("doppelganger.tool.arguments.size")
+	ResultSize    = attribute.Key("doppelganger.tool.result.size")
+	Approved      = attribute.Key("doppelganger.tool.approved")
+	QuerySize     = attribute.Key("doppelganger.query.size")
+	CorrelationID = attribute.Key("doppelganger.correlation_id")
+	Untrusted     = attribute.Key("doppelganger.tool.untrusted")
+	Injection     = attribute.Key("doppelganger.tool.injection")
+	Cache         = attribute.Key("doppelganger.tool.cache")
+	DecisionCache = attribute.Key("doppelganger.decision.cache")
+)
+
+type contentKey struct{}
+
+// WithRecordContent marks ctx so that spans started under it record prompts,
+// responses, arguments and query text. They may hold personal data, so this
+// is off unless asked for.
+func WithRecordContent(ctx context.Context, record bool) context.Context {
+	return context.WithValue(ctx, contentKey{}, record)
+}
+
+func RecordContent(ctx context.Context) bool {
+	record, _ := ctx.Value(contentKey{}).(bool)
+	return record
+}
+
+// Tracer returns the tracer of the provider the span in ctx came from, so
+// packages without their own configuration trace into the same provider.
+func Tracer(ctx context.Context) trace.Tracer {
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(TracerName)
+}
+
+// End records err, if any, on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// Usage returns the tokens used by a response, as reported by the OpenAI and
+// Anthropic providers.
+func Usage(res *llms.ContentResponse) (input, output int) {
+	if res == nil {
+		return 0, 0
+	}
+
+	for _, choice := range res.Choices {
+		info := choice.GenerationInfo
+		input = max(input, intValue(info, "PromptTokens"), intValue(info, "InputTokens"))
+		output = max(output, intValue(info, "CompletionTokens"), intValue(info, "OutputTokens"))
+	}
+
+	return input, output
+}
+
+func intValue(info map[string]interface{}, key string) int {
+	switch v := info[key].(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+
+	return 0
+}
@@ -0,0 +1,63 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestUsage(t *testing.T) {
+	tt := []struct {
+		description    string
+		response       *llms.ContentResponse
+		expectedInput  int
+		expectedOutput int
+	}{
+		{
+			description: "when OpenAI reports usage should read prompt and completion tokens",
+			response: &llms.ContentResponse{Choices: []*llms.ContentChoice{
+				{GenerationInfo: map[string]any{"PromptTokens": 12, "CompletionTokens": 3, "TotalTokens": 15}},
+			}},
+			expectedInput:  12,
+			expectedOutput: 3,
+		},
+		{
+			description: "when Anthropic reports the usage on every choice should count it once",
+			response: &llms.ContentResponse{Choices: []*llms.ContentChoice{
+				{GenerationInfo: map[string]any{"InputTokens": 40, "OutputTokens": 8}},
+				{GenerationInfo: map[string]any{"InputTokens": 40, "OutputTokens": 8}},
+			}},
+			expectedInput:  40,
+			expectedOutput: 8,
+		},
+		{
+			description:    "when no usage is reported should return zero",
+			response:       &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "hi"}}},
+			expectedInput:  0,
+			expectedOutput: 0,
+		},
+		{
+			description:    "when there is no response should return zero",
+			response:       nil,
+			expectedInput:  0,
+			expectedOutput: 0,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			input, output := Usage(test.response)
+			require.Equal(t, test.expectedInput, input)
+			require.Equal(t, test.expectedOutput, output)
+		})
+	}
+}
+
+func TestRecordContent(t *testing.T) {
+	ctx := context.Background()
+	require.False(t, RecordContent(ctx))
+	require.True(t, RecordContent(WithRecordContent(ctx, true)))
+	require.False(t, RecordContent(WithRecordContent(ctx, false)))
+}
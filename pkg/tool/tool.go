@@ -3,37 +3,343 @@ package tool
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"doppelganger/pkg/auth"
+	"doppelganger/pkg/cache"
 	"doppelganger/pkg/datasource"
+	"doppelganger/pkg/metrics"
+	"doppelganger/pkg/ratelimit"
+	"doppelganger/pkg/redact"
+	"doppelganger/pkg/telemetry"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"text/template"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ErrTimeout is returned when a tool runs out of its own Timeout.
+var ErrTimeout = errors.New("timed out")
+
 type DataSourceTool struct {
-	Source         datasource.DataSource
-	Name           string
-	Description    string
-	Parameters     map[string]interface{}
-	Database       string
-	Collection     string
-	Method         string
-	Query          string
-	parsedTemplate *template.Template
+	Source      datasource.DataSource
+	Name        string
+	Description string
+	Parameters  map[string]interface{}
+	Database    string
+	Collection  string
+	Method      string
+	Query       string
+
+	// Projection, Sort, Limit and Skip are passed to the data source with the
+	// query. Each is either a fixed value or a template rendered with the
+	// model arguments, like Query.
+	Projection string
+	Sort       string
+	Limit      string
+	Skip       string
+	// MaxLimit caps the rendered Limit and MaxSkip rejects larger Skip
+	// values. Zero means unbounded.
+	MaxLimit int64
+	MaxSkip  int64
+	// Policy overrides the data source's default query policy for this tool.
+	Policy *datasource.Policy
+	// RequiresApproval makes the agent ask its approver before every call,
+	// for tools that change data.
+	RequiresApproval bool
+	// Redact removes personal data from the records before they are
+	// returned.
+	Redact *redact.Redactor
+	// Roles limits the tool to callers with any of the roles. Empty allows
+	// every caller.
+	Roles []string
+	// Constraints map query fields to attributes of the caller, such as
+	// "branch_id" to "branch". The data source only returns records where the
+	// fields have the caller's values, whatever the model asks for.
+	Constraints map[string]string
+	// Untrusted marks results that hold text written outside the business,
+	// such as documents or free-text fields, for the injection defences of
+	// the agent.
+	Untrusted bool
+	// CacheTTL caches the results of the tool for this long, keyed on its
+	// arguments, when the agent has a cache. Only set it for lookups whose
+	// results change rarely.
+	CacheTTL time.Duration
+	// Invalidates names the tools whose cached results are dropped after a
+	// successful call, such as the lookups of the data the tool changes.
+	Invalidates []string
+	// Limiter caps the rate and concurrency of the queries of the tool, on
+	// top of the limits of its data source.
+	Limiter *ratelimit.Limiter
+	// Timeout caps how long a call of the tool runs, within the deadline of
+	// its decision. Zero runs until that deadline.
+	Timeout time.Duration
+
+	parsedTemplate   *template.Template
+	parsedProjection *template.Template
+	parsedSort       *template.Template
+	parsedLimit      *template.Template
+	parsedSkip       *template.Template
+}
+
+// Funcs are the functions available in query templates. json writes a value
+// as JSON, for example {{ json . }} passes every argument on as an object.
+var Funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
 }
 
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
 var bufferPool = sync.Pool{
 	New: func() interface{} {
 		return bytes.NewBuffer(make([]byte, 0, 1024))
 	},
 }
 
+// Execute runs the query rendered with params, for the auth.Principal of
+// ctx. When a redact.Vault is in ctx, tokens in params are replaced with
+// their values first.
 func (dst *DataSourceTool) Execute(ctx context.Context, params map[string]interface{}) ([]string, error) {
-	if dst.parsedTemplate == nil {
-		tmpl, err := template.New("test").Option("missingkey=error").Parse(dst.Query)
+	records, _, err := dst.ExecuteCached(ctx, params, nil)
+	return records, err
+}
+
+// ExecuteCached runs like Execute, looking the records up in c first when the
+// tool has a CacheTTL. It tells whether they came from the cache. Records are
+// cached before redaction, and redacted on every call.
+func (dst *DataSourceTool) ExecuteCached(ctx context.Context, params map[string]interface{}, c *cache.Results) ([]string, bool, error) {
+	if dst.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, dst.Timeout, ErrTimeout)
+		defer cancel()
+	}
+
+	principal := auth.FromContext(ctx)
+	if !auth.Allowed(principal, dst.Roles) {
+		return nil, false, fmt.Errorf("%w: tool %s requires one of the roles %s", auth.ErrForbidden, dst.Name, strings.Join(dst.Roles, ", "))
+	}
+
+	constraints, err := auth.Constraints(principal, dst.Constraints)
+	if err != nil {
+		return nil, false, err
+	}
+
+	redact.VaultFrom(ctx).DetokeniseArguments(params)
+
+	var key string
+	if c != nil && dst.CacheTTL > 0 {
+		key, err = c.Key(dst.Name, dst.Version(), params, constraints)
+		if err != nil {
+			return nil, false, err
+		}
+		if records, ok := c.Get(ctx, key); ok {
+			return dst.redact(ctx, records), true, nil
+		}
+	}
+
+	query, err := render(&dst.parsedTemplate, dst.Query, dst.escape(params))
+	if err != nil {
+		return nil, false, err
+	}
+
+	opts, err := dst.queryOptions(params)
+	if err != nil {
+		return nil, false, err
+	}
+	if constraints != nil {
+		opts = append(opts, datasource.WithConstraints(constraints))
+	}
+
+	if dst.Limiter != nil {
+		release, err := dst.Limiter.Acquire(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		defer release()
+	}
+
+	records, err := dst.query(ctx, query, opts)
+	// Only the expiry of the tool's own deadline is a timeout, the decision
+	// running out of time is not
+	if err != nil && context.Cause(ctx) == ErrTimeout {
+		return nil, false, fmt.Errorf("%w after %v", ErrTimeout, dst.Timeout)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if key != "" {
+		c.Set(ctx, key, records, dst.CacheTTL)
+	}
+
+	return dst.redact(ctx, records), false, nil
+}
+
+// escape returns params with their strings escaped for the query of the data
+// source, or params when the query needs no escaping.
+func (dst *DataSourceTool) escape(params map[string]interface{}) map[string]interface{} {
+	if dst.Source == nil {
+		return params
+	}
+
+	escaper, ok := datasource.ArgumentEscapers[dst.Source.Type()][dst.Method]
+	if !ok {
+		return params
+	}
+
+	return escapeValue(params, escaper).(map[string]interface{})
+}
+
+func escapeValue(value interface{}, escaper func(string) string) interface{} {
+	switch val := value.(type) {
+	case string:
+		return escaper(val)
+	case map[string]interface{}:
+		escaped := make(map[string]interface{}, len(val))
+		for key, elem := range val {
+			escaped[key] = escapeValue(elem, escaper)
+		}
+		return escaped
+	case []interface{}:
+		escaped := make([]interface{}, len(val))
+		for i, elem := range val {
+			escaped[i] = escapeValue(elem, escaper)
+		}
+		return escaped
+	}
+
+	return value
+}
+
+// redact removes personal data from the records, in place.
+func (dst *DataSourceTool) redact(ctx context.Context, records []string) []string {
+	if dst.Redact == nil {
+		return records
+	}
+
+	vault := redact.VaultFrom(ctx)
+	for i, record := range records {
+		records[i] = dst.Redact.Redact(record, vault)
+	}
+
+	return records
+}
+
+// Version returns a hash of the definition of the tool, which changes when
+// its description, parameters, query, type of data source, access rules,
+// query policy or redaction do.
+func (dst *DataSourceTool) Version() string {
+	var sourceType string
+	if dst.Source != nil {
+		sourceType = dst.Source.Type()
+	}
+	var rules *redact.Rules
+	if dst.Redact != nil {
+		r := dst.Redact.Rules()
+		rules = &r
+	}
+	definition, _ := json.Marshal([]interface{}{
+		sourceType, dst.Name, dst.Description, dst.Parameters, dst.Database, dst.Collection, dst.Method,
+		dst.Query, dst.Projection, dst.Sort, dst.Limit, dst.Skip, dst.MaxLimit, dst.MaxSkip,
+		dst.Roles, dst.Constraints, dst.Untrusted, dst.Policy, rules,
+	})
+	sum := sha256.Sum256(definition)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// query runs the rendered query in a span of the tracer provider found in
+// ctx, and records its latency with the metrics recorder found in ctx.
+func (dst *DataSourceTool) query(ctx context.Context, query string, opts []datasource.QueryOption) (records []string, err error) {
+	ctx, span := telemetry.Tracer(ctx).Start(ctx, strings.TrimSpace(dst.Method+" "+dst.Collection),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			telemetry.DBSystem.String(dst.Source.Type()),
+			telemetry.DBOperation.String(dst.Method),
+			telemetry.DBNamespace.String(dst.Database),
+			telemetry.DBCollection.String(dst.Collection),
+			telemetry.QuerySize.Int(len(query)),
+		),
+	)
+	start := time.Now()
+	defer func() {
+		metrics.FromContext(ctx).Query(dst.Source.Type(), dst.Method, metrics.Outcome(err), time.Since(start))
+		span.SetAttributes(telemetry.DBReturnedRows.Int(len(records)))
+		telemetry.End(span, err)
+	}()
+
+	if telemetry.RecordContent(ctx) {
+		span.SetAttributes(telemetry.DBQueryText.String(query))
+	}
+
+	return dst.Source.Query(ctx, dst.Database, dst.Method, dst.Collection, query, opts...)
+}
+
+func (dst *DataSourceTool) queryOptions(params map[string]interface{}) ([]datasource.QueryOption, error) {
+	var opts []datasource.QueryOption
+
+	projection, err := render(&dst.parsedProjection, dst.Projection, params)
+	if err != nil {
+		return nil, err
+	}
+	if projection != "" {
+		opts = append(opts, datasource.WithProjection(projection))
+	}
+
+	sort, err := render(&dst.parsedSort, dst.Sort, params)
+	if err != nil {
+		return nil, err
+	}
+	if sort != "" {
+		opts = append(opts, datasource.WithSort(sort))
+	}
+
+	limit, err := renderInt(&dst.parsedLimit, "limit", dst.Limit, params)
+	if err != nil {
+		return nil, err
+	}
+	if dst.MaxLimit > 0 && (limit == 0 || limit > dst.MaxLimit) {
+		limit = dst.MaxLimit
+	}
+	if limit > 0 {
+		opts = append(opts, datasource.WithLimit(limit))
+	}
+
+	skip, err := renderInt(&dst.parsedSkip, "skip", dst.Skip, params)
+	if err != nil {
+		return nil, err
+	}
+	if dst.MaxSkip > 0 && skip > dst.MaxSkip {
+		return nil, fmt.Errorf("skip %d exceeds the maximum of %d", skip, dst.MaxSkip)
+	}
+	if skip > 0 {
+		opts = append(opts, datasource.WithSkip(skip))
+	}
+
+	if dst.Policy != nil {
+		opts = append(opts, datasource.WithPolicy(dst.Policy))
+	}
+
+	return opts, nil
+}
+
+func render(parsed **template.Template, text string, params map[string]interface{}) (string, error) {
+	if *parsed == nil {
+		tmpl, err := template.New("test").Option("missingkey=error").Funcs(Funcs).Parse(text)
 		if err != nil {
-			return nil, err
+			return "", err
 		}
 
-		dst.parsedTemplate = tmpl
+		*parsed = tmpl
 	}
 
 	buf := bufferPool.Get().(*bytes.Buffer)
@@ -42,15 +348,33 @@ func (dst *DataSourceTool) Execute(ctx context.Context, params map[string]interf
 		bufferPool.Put(buf)
 	}()
 
-	err := dst.parsedTemplate.Execute(buf, params)
+	err := (*parsed).Execute(buf, params)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	records, err := dst.Source.Query(ctx, dst.Database, dst.Method, dst.Collection, buf.String())
+	return buf.String(), nil
+}
+
+func renderInt(parsed **template.Template, name, text string, params map[string]interface{}) (int64, error) {
+	if text == "" {
+		return 0, nil
+	}
+
+	value, err := render(parsed, text, params)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+
+	// Numbers in model arguments are decoded as float64
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || f != float64(int64(f)) {
+		return 0, fmt.Errorf("invalid %s %q: must be an integer", name, value)
+	}
+	n := int64(f)
+	if n < 0 {
+		return 0, fmt.Errorf("invalid %s %d: must not be negative", name, n)
 	}
 
-	return records, nil
+	return n, nil
 }
@@ -2,7 +2,11 @@ package tool
 
 import (
 	"context"
+	"doppelganger/pkg/datasource"
+	"doppelganger/pkg/redact"
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -43,6 +47,16 @@ func TestExecute(t *testing.T) {
 			expectedError:     false,
 			expectedResult:    []string{"This is the sample code: abc"},
 		},
+		{
+			description: "When the query uses the json function the arguments should be written as JSON",
+			query:       `{"filter": {{ json .filter }}, "all": {{ json . }}}`,
+			params: map[string]interface{}{
+				"filter": map[string]interface{}{"name": "Ann \"O\""},
+			},
+			queryReturnsError: false,
+			expectedError:     false,
+			expectedResult:    []string{`{"filter": {"name":"Ann \"O\""}, "all": {"filter":{"name":"Ann \"O\""}}}`},
+		},
 		{
 			description: "When query execution fails an error should be returned",
 			query:       "This is the sample code: {{ .code }}",
@@ -82,8 +96,215 @@ func TestExecute(t *testing.T) {
 	}
 }
 
+func TestExecuteEscapesArguments(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(r.URL.Query())
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	source := datasource.NewHTTPDataSource()
+	err := source.Connect(ctx, server.URL)
+	require.Nil(t, err)
+
+	dst := DataSourceTool{
+		Source:     source,
+		Name:       "get_case",
+		Collection: "cases",
+		Method:     "get",
+		Query:      "status=open&id={{ .id }}",
+	}
+
+	res, err := dst.Execute(ctx, map[string]interface{}{"id": "42&status=closed&admin=true"})
+	require.Nil(t, err)
+	require.JSONEq(t, `{"id":["42&status=closed&admin=true"],"status":["open"]}`, res[0])
+}
+
+func TestExecuteQueryOptions(t *testing.T) {
+	tt := []struct {
+		description     string
+		tool            DataSourceTool
+		params          map[string]interface{}
+		expectedError   bool
+		expectedOptions datasource.QueryOptions
+	}{
+		{
+			description: "When fixed options are set, they are passed to the data source",
+			tool: DataSourceTool{
+				Projection: "{ \"iban\": 0 }",
+				Sort:       "{ \"updated\": -1 }",
+				Limit:      "5",
+				Skip:       "10",
+			},
+			params:        map[string]interface{}{},
+			expectedError: false,
+			expectedOptions: datasource.QueryOptions{
+				Projection: "{ \"iban\": 0 }",
+				Sort:       "{ \"updated\": -1 }",
+				Limit:      5,
+				Skip:       10,
+			},
+		},
+		{
+			description: "When options are templated, they are rendered with the model arguments",
+			tool: DataSourceTool{
+				Sort:  "{ \"{{ .field }}\": -1 }",
+				Limit: "{{ .limit }}",
+				Skip:  "{{ .skip }}",
+			},
+			params: map[string]interface{}{
+				"field": "updated",
+				"limit": float64(3),
+				"skip":  float64(6),
+			},
+			expectedError: false,
+			expectedOptions: datasource.QueryOptions{
+				Sort:  "{ \"updated\": -1 }",
+				Limit: 3,
+				Skip:  6,
+			},
+		},
+		{
+			description: "When the limit exceeds the maximum, it is capped",
+			tool: DataSourceTool{
+				Limit:    "{{ .limit }}",
+				MaxLimit: 20,
+			},
+			params: map[string]interface{}{
+				"limit": float64(500),
+			},
+			expectedError: false,
+			expectedOptions: datasource.QueryOptions{
+				Limit: 20,
+			},
+		},
+		{
+			description: "When no limit is set but a maximum is, the maximum is used",
+			tool: DataSourceTool{
+				MaxLimit: 20,
+			},
+			params:        map[string]interface{}{},
+			expectedError: false,
+			expectedOptions: datasource.QueryOptions{
+				Limit: 20,
+			},
+		},
+		{
+			description: "When a policy is set, it is passed to the data source",
+			tool: DataSourceTool{
+				Policy: &datasource.Policy{MaxRegexLength: 10},
+			},
+			params:        map[string]interface{}{},
+			expectedError: false,
+			expectedOptions: datasource.QueryOptions{
+				Policy: &datasource.Policy{MaxRegexLength: 10},
+			},
+		},
+		{
+			description: "When the skip exceeds the maximum, an error is returned",
+			tool: DataSourceTool{
+				Skip:    "{{ .skip }}",
+				MaxSkip: 100,
+			},
+			params: map[string]interface{}{
+				"skip": float64(101),
+			},
+			expectedError: true,
+		},
+		{
+			description: "When the limit is not an integer, an error is returned",
+			tool: DataSourceTool{
+				Limit: "{{ .limit }}",
+			},
+			params: map[string]interface{}{
+				"limit": "ten",
+			},
+			expectedError: true,
+		},
+		{
+			description: "When the limit is negative, an error is returned",
+			tool: DataSourceTool{
+				Limit: "{{ .limit }}",
+			},
+			params: map[string]interface{}{
+				"limit": float64(-1),
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tt {
+		ctx := context.Background()
+		t.Run(test.description, func(t *testing.T) {
+			source := &mockDatasource{}
+			dst := test.tool
+			dst.Source = source
+			dst.Method = "find"
+
+			_, err := dst.Execute(ctx, test.params)
+			if test.expectedError {
+				require.NotNil(t, err)
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, test.expectedOptions, *source.options)
+		})
+	}
+}
+
+func TestVersion(t *testing.T) {
+	mask, err := redact.New(redact.Rules{Detect: []redact.Kind{redact.Email}})
+	require.Nil(t, err)
+	tokenise, err := redact.New(redact.Rules{Detect: []redact.Kind{redact.Email}, Action: redact.Tokenise})
+	require.Nil(t, err)
+
+	base := DataSourceTool{Name: "find_customer", Description: "Finds a customer", Method: "find", Query: `{"id": "{{ .id }}"}`, Redact: mask}
+
+	tt := []struct {
+		description     string
+		change          func(dst *DataSourceTool)
+		expectedChanged bool
+	}{
+		{
+			description:     "When nothing changes, the version is the same",
+			change:          func(dst *DataSourceTool) {},
+			expectedChanged: false,
+		},
+		{
+			description:     "When the query changes, the version changes",
+			change:          func(dst *DataSourceTool) { dst.Query = `{"email": "{{ .email }}"}` },
+			expectedChanged: true,
+		},
+		{
+			description:     "When the query policy changes, the version changes",
+			change:          func(dst *DataSourceTool) { dst.Policy = &datasource.Policy{AllowWrites: true} },
+			expectedChanged: true,
+		},
+		{
+			description:     "When the redaction changes, the version changes",
+			change:          func(dst *DataSourceTool) { dst.Redact = tokenise },
+			expectedChanged: true,
+		},
+		{
+			description:     "When the redaction is removed, the version changes",
+			change:          func(dst *DataSourceTool) { dst.Redact = nil },
+			expectedChanged: true,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			changed := base
+			test.change(&changed)
+			require.Equal(t, test.expectedChanged, base.Version() != changed.Version())
+		})
+	}
+}
+
 type mockDatasource struct {
 	returnError bool
+	options     *datasource.QueryOptions
 }
 
 func (m *mockDatasource) Connect(ctx context.Context, connectionString string) error {
@@ -94,7 +315,8 @@ func (m *mockDatasource) Close(ctx context.Context) error {
 	return nil
 }
 
-func (m *mockDatasource) Query(ctx context.Context, database, method, collection, query string) ([]string, error) {
+func (m *mockDatasource) Query(ctx context.Context, database, method, collection, query string, opts ...datasource.QueryOption) ([]string, error) {
+	m.options = datasource.NewQueryOptions(opts...)
 	mockError := errors.New("Mock Error")
 	if m.returnError == true {
 		return nil, mockError
@@ -0,0 +1,84 @@
+package doppelganger
+
+import (
+	"context"
+	"doppelganger/pkg/datasource"
+	"doppelganger/pkg/ratelimit"
+	"doppelganger/pkg/tool"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestRateLimit(t *testing.T) {
+	full := func() *ratelimit.Limiter {
+		l := ratelimit.New(ratelimit.Limits{MaxInFlight: 1, MaxWait: 10 * time.Millisecond})
+		_, err := l.Acquire(context.Background())
+		require.Nil(t, err)
+		return l
+	}
+
+	tt := []struct {
+		description     string
+		toolLimiter     *ratelimit.Limiter
+		sourceLimiter   *ratelimit.Limiter
+		expectedResult  string
+		expectedQueries int
+	}{
+		{
+			description:     "when within the limits should run the query",
+			toolLimiter:     ratelimit.New(ratelimit.Limits{Rate: 10, MaxInFlight: 1}),
+			sourceLimiter:   ratelimit.New(ratelimit.Limits{Rate: 10, MaxInFlight: 1}),
+			expectedResult:  `["UBSWCHZH80A"]`,
+			expectedQueries: 1,
+		},
+		{
+			description:    "when the tool is limited should tell the model",
+			toolLimiter:    full(),
+			expectedResult: `{"error":"rate_limited","message":"validate_swift_code is rate limited: more than 1 calls in flight, try again later"}`,
+		},
+		{
+			description:    "when the data source is limited should tell the model",
+			sourceLimiter:  full(),
+			expectedResult: `{"error":"rate_limited","message":"validate_swift_code is rate limited: more than 1 calls in flight, try again later"}`,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			provider := &mockProvider{
+				responses: []*llms.ContentResponse{
+					{Choices: []*llms.ContentChoice{{ToolCalls: []llms.ToolCall{
+						{ID: "call_1", FunctionCall: &llms.FunctionCall{Name: "validate_swift_code", Arguments: `{"code":"UBSWCHZH80A"}`}},
+					}}}},
+					{Choices: []*llms.ContentChoice{{Content: "try again later"}}},
+				},
+			}
+			d := New(WithProviderGenerator(func(model string) (llms.Model, error) {
+				return provider, nil
+			}))
+
+			source := &mockDatasource{}
+			var ds datasource.DataSource = source
+			if test.sourceLimiter != nil {
+				ds = datasource.NewLimited(source, test.sourceLimiter)
+			}
+			err := d.RegisterTool(tool.DataSourceTool{
+				Name:        "validate_swift_code",
+				Description: "Validates whether a swift code is valid",
+				Parameters:  map[string]any{"type": "object"},
+				Query:       `{{ .code }}`,
+				Source:      ds,
+				Limiter:     test.toolLimiter,
+			})
+			require.Nil(t, err)
+
+			res, err := d.Decide(context.Background(), DecisionRequest{UserInstruction: "validate", Model: "mock"})
+			require.Nil(t, err)
+			require.Equal(t, test.expectedResult, res.ToolCalls[0].Result)
+			require.Equal(t, test.expectedQueries, source.calls)
+		})
+	}
+}
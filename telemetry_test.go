@@ -0,0 +1,155 @@
+package doppelganger
+
+import (
+	"context"
+	"doppelganger/pkg/telemetry"
+	"doppelganger/pkg/tool"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	tt := []struct {
+		description     string
+		opts            []Option
+		sourceError     bool
+		expectedError   bool
+		expectedSpans   []string
+		expectedContent bool
+	}{
+		{
+			description:   "when a decision calls a tool should emit a span for the decision, every round, the tool and the query",
+			expectedSpans: []string{"findOne users", "execute_tool get_user", "chat mock", "chat mock", "decision"},
+		},
+		{
+			description:     "when content recording is enabled should add prompts, responses, arguments and queries",
+			opts:            []Option{WithContentRecording()},
+			expectedSpans:   []string{"findOne users", "execute_tool get_user", "chat mock", "chat mock", "decision"},
+			expectedContent: true,
+		},
+		{
+			description:   "when the query fails should record the error on every span up to the decision",
+			sourceError:   true,
+			expectedError: true,
+			expectedSpans: []string{"chat mock", "findOne users", "execute_tool get_user", "decision"},
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+			provider := &mockProvider{
+				responses: []*llms.ContentResponse{
+					{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{ID: "call_1", FunctionCall: &llms.FunctionCall{Name: "get_user", Arguments: `{"id":"42"}`}},
+								},
+								StopReason:     "tool_calls",
+								GenerationInfo: map[string]any{"PromptTokens": 100, "CompletionTokens": 20},
+							},
+						},
+					},
+					{
+						Choices: []*llms.ContentChoice{
+							{
+								Content:        "user 42",
+								StopReason:     "stop",
+								GenerationInfo: map[string]any{"PromptTokens": 150, "CompletionTokens": 5},
+							},
+						},
+					},
+				},
+			}
+
+			opts := append([]Option{
+				WithTracerProvider(tp),
+				WithProviderGenerator(func(model string) (llms.Model, error) {
+					return provider, nil
+				}),
+			}, test.opts...)
+			d := New(opts...)
+
+			err := d.RegisterTool(tool.DataSourceTool{
+				Name:        "get_user",
+				Description: "Gets a user",
+				Parameters:  map[string]any{"type": "object"},
+				Database:    "crm",
+				Collection:  "users",
+				Method:      "findOne",
+				Query:       `{"id":"{{ .id }}"}`,
+				Source:      &mockDatasource{returnError: test.sourceError},
+			})
+			require.Nil(t, err)
+
+			_, err = d.MakeDecision(context.Background(), "abc", "who is 42?", "mock")
+			if test.expectedError {
+				require.NotNil(t, err)
+			} else {
+				require.Nil(t, err)
+			}
+
+			spans := recorder.Ended()
+			var names []string
+			byName := make(map[string]sdktrace.ReadOnlySpan)
+			for _, span := range spans {
+				names = append(names, span.Name())
+				byName[span.Name()] = span
+			}
+			require.ElementsMatch(t, test.expectedSpans, names)
+
+			decision := byName["decision"]
+			toolSpan := byName["execute_tool get_user"]
+			query := byName["findOne users"]
+
+			// Every span belongs to the decision
+			for _, span := range spans {
+				require.Equal(t, decision.SpanContext().TraceID(), span.SpanContext().TraceID())
+			}
+			require.Equal(t, toolSpan.SpanContext().SpanID(), query.Parent().SpanID())
+			require.Equal(t, decision.SpanContext().SpanID(), toolSpan.Parent().SpanID())
+
+			require.Contains(t, query.Attributes(), telemetry.DBSystem.String("mock"))
+			require.Contains(t, query.Attributes(), telemetry.DBCollection.String("users"))
+			require.Contains(t, toolSpan.Attributes(), telemetry.ToolName.String("get_user"))
+			require.Contains(t, toolSpan.Attributes(), telemetry.ArgumentsSize.Int(11))
+
+			if test.expectedError {
+				require.Equal(t, codes.Error, query.Status().Code)
+				require.Equal(t, codes.Error, toolSpan.Status().Code)
+				require.Equal(t, codes.Error, decision.Status().Code)
+				return
+			}
+
+			require.Contains(t, query.Attributes(), telemetry.DBReturnedRows.Int(1))
+			require.Contains(t, decision.Attributes(), telemetry.InputTokens.Int(250))
+			require.Contains(t, decision.Attributes(), telemetry.OutputTokens.Int(25))
+			require.Contains(t, decision.Attributes(), telemetry.ToolCalls.Int(1))
+			require.Contains(t, decision.Attributes(), telemetry.Rounds.Int(2))
+
+			contentKeys := map[attribute.Key]bool{}
+			for _, span := range spans {
+				for _, attr := range span.Attributes() {
+					switch attr.Key {
+					case telemetry.Prompt, telemetry.Completion, telemetry.ToolArguments, telemetry.ToolResult, telemetry.DBQueryText:
+						contentKeys[attr.Key] = true
+					}
+				}
+			}
+			if test.expectedContent {
+				require.Len(t, contentKeys, 5)
+			} else {
+				require.Empty(t, contentKeys)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package doppelganger
+
+import (
+	"context"
+	"doppelganger/pkg/datasource"
+	"doppelganger/pkg/tool"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// slowSource answers after delay, or fails when ctx ends first.
+type slowSource struct {
+	mockDatasource
+	delay time.Duration
+}
+
+func (s *slowSource) Query(ctx context.Context, database, method, collection, query string, opts ...datasource.QueryOption) ([]string, error) {
+	select {
+	case <-time.After(s.delay):
+		return []string{query}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestToolTimeout(t *testing.T) {
+	tt := []struct {
+		description    string
+		timeout        time.Duration
+		delay          time.Duration
+		decisionWait   time.Duration
+		expectedResult string
+		expectedError  error
+	}{
+		{
+			description:    "when the query ends in time should return its result",
+			timeout:        time.Second,
+			delay:          time.Millisecond,
+			expectedResult: `["UBSWCHZH80A"]`,
+		},
+		{
+			description:    "when the tool runs out of time should tell the model",
+			timeout:        10 * time.Millisecond,
+			delay:          time.Second,
+			expectedResult: `{"error":"timeout","message":"validate_swift_code timed out after 10ms"}`,
+		},
+		{
+			description:   "when the decision runs out of time should fail the decision",
+			timeout:       time.Second,
+			delay:         time.Second,
+			decisionWait:  10 * time.Millisecond,
+			expectedError: context.DeadlineExceeded,
+		},
+	}
+
+	for _, test := range tt {
+		t.Run(test.description, func(t *testing.T) {
+			provider := &mockProvider{
+				responses: []*llms.ContentResponse{
+					{Choices: []*llms.ContentChoice{{ToolCalls: []llms.ToolCall{
+						{ID: "call_1", FunctionCall: &llms.FunctionCall{Name: "validate_swift_code", Arguments: `{"code":"UBSWCHZH80A"}`}},
+					}}}},
+					{Choices: []*llms.ContentChoice{{Content: "done"}}},
+				},
+			}
+			d := New(WithProviderGenerator(func(model string) (llms.Model, error) {
+				return provider, nil
+			}))
+
+			err := d.RegisterTool(tool.DataSourceTool{
+				Name:        "validate_swift_code",
+				Description: "Validates whether a swift code is valid",
+				Parameters:  map[string]any{"type": "object"},
+				Query:       `{{ .code }}`,
+				Source:      &slowSource{delay: test.delay},
+				Timeout:     test.timeout,
+			})
+			require.Nil(t, err)
+
+			ctx := context.Background()
+			if test.decisionWait > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.decisionWait)
+				defer cancel()
+			}
+
+			start := time.Now()
+			res, err := d.Decide(ctx, DecisionRequest{UserInstruction: "validate", Model: "mock"})
+			require.Less(t, time.Since(start), 500*time.Millisecond)
+			if test.expectedError != nil {
+				require.ErrorIs(t, err, test.expectedError)
+				return
+			}
+
+			require.Nil(t, err)
+			require.Equal(t, test.expectedResult, res.ToolCalls[0].Result)
+		})
+	}
+}